@@ -0,0 +1,61 @@
+package csrf
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxAlertOrigins bounds the number of origins whose failures are counted for
+// OriginFailureAlert.
+const maxAlertOrigins = 10000
+
+// OriginAlertFunc is called by OriginFailureAlert when the CSRF failures of a
+// single origin reach the threshold within the window. origin is the
+// "scheme://host" of the Origin or Referer header of the request, or empty if
+// the request carries neither; r is the request reaching the threshold.
+type OriginAlertFunc func(origin string, failures int, r *http.Request)
+
+// failureWindow counts the failures of an origin in a fixed time window.
+type failureWindow struct {
+	start    time.Time
+	failures int
+}
+
+// failureOrigin returns the origin a failed request is attributed to.
+func failureOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return origin
+	}
+
+	if referer, err := url.Parse(r.Referer()); err == nil && referer.Host != "" {
+		return referer.Scheme + "://" + referer.Host
+	}
+
+	return ""
+}
+
+// countFailure records a CSRF failure of r, and calls the alert function once
+// the failures of its origin reach the threshold within the window.
+func (cs *csrf) countFailure(r *http.Request) {
+	if cs.failures == nil {
+		return
+	}
+
+	origin := failureOrigin(r)
+	window := cs.opts.AlertWindow
+	now := timeNow()
+
+	w := cs.failures.update(origin, func(w failureWindow, found bool) failureWindow {
+		if !found || now.Sub(w.start) >= window {
+			return failureWindow{start: now, failures: 1}
+		}
+		w.failures++
+		return w
+	})
+
+	// Alert once per window.
+	if w.failures == cs.opts.AlertThreshold {
+		cs.opts.AlertFunc(origin, w.failures, r)
+	}
+}
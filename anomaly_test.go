@@ -0,0 +1,55 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestOriginFailureAlert tests that the alert fires once per window when the
+// failures of a single origin reach the threshold.
+func TestOriginFailureAlert(t *testing.T) {
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	alerts := map[string]int{}
+	p := Protect(testKey, OriginFailureAlert(3, time.Minute, func(origin string, failures int, r *http.Request) {
+		alerts[origin]++
+	}))(testHandler)
+
+	post := func(origin, referer string) {
+		r := httptest.NewRequest("POST", "/", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if referer != "" {
+			r.Header.Set("Referer", referer)
+		}
+		p.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	for i := 0; i < 5; i++ {
+		post("https://evil.example", "")
+	}
+	post("", "https://other.example/form")
+	post("", "https://other.example/page")
+
+	if alerts["https://evil.example"] != 1 || alerts["https://other.example"] != 0 {
+		t.Fatalf("alerts in the first window: got %v", alerts)
+	}
+
+	post("", "https://other.example/again")
+	if alerts["https://other.example"] != 1 {
+		t.Fatalf("referer origin not counted: got %v", alerts)
+	}
+
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		post("https://evil.example", "")
+	}
+	if alerts["https://evil.example"] != 2 {
+		t.Fatalf("alerts in the second window: got %v", alerts)
+	}
+}
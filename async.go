@@ -0,0 +1,109 @@
+package csrf
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// flushInterval is the interval at which Flush checks for pending events.
+const flushInterval = 10 * time.Millisecond
+
+// AsyncEventSink is an EventSink that queues events in a bounded buffer and
+// emits them to another sink from a background goroutine, so that a slow sink,
+// e.g. a remote SIEM, never delays requests. Events are dropped while the
+// buffer is full. It is safe for concurrent use.
+type AsyncEventSink struct {
+	sink EventSink
+
+	start   sync.Once
+	mu      sync.RWMutex // guards sending to queue against Close
+	closed  bool
+	queue   chan Event
+	pending atomic.Int64 // queued or being emitted
+	dropped atomic.Int64
+}
+
+// AsyncSink returns an AsyncEventSink emitting to sink, buffering up to size
+// events:
+//
+//	audit := csrf.AsyncSink(csrf.WriterSink(conn, csrf.FormatCEF), 1024)
+//	CSRF := csrf.Protect(key, csrf.AuditEvents(audit))
+//	...
+//	// on shutdown, after the server has stopped:
+//	audit.Flush(ctx)
+func AsyncSink(sink EventSink, size int) *AsyncEventSink {
+	return &AsyncEventSink{sink: sink, queue: make(chan Event, size)}
+}
+
+// Emit queues e, or drops it if the buffer is full or the sink is closed. It
+// never blocks.
+func (as *AsyncEventSink) Emit(e Event) {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+	if as.closed {
+		as.dropped.Add(1)
+		return
+	}
+
+	as.start.Do(func() { go as.run() })
+
+	as.pending.Add(1)
+	select {
+	case as.queue <- e:
+	default:
+		as.pending.Add(-1)
+		as.dropped.Add(1)
+	}
+}
+
+// run emits the queued events.
+func (as *AsyncEventSink) run() {
+	for e := range as.queue {
+		as.sink.Emit(e)
+		as.pending.Add(-1)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full,
+// or the sink was closed.
+func (as *AsyncEventSink) Dropped() int64 {
+	return as.dropped.Load()
+}
+
+// Flush waits until all queued events have been emitted, or ctx is done. It
+// returns the error of ctx in the latter case.
+func (as *AsyncEventSink) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for as.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// Close flushes the queued events like Flush, and stops the goroutine once
+// they are emitted. Events emitted afterwards are dropped. If ctx is done
+// first, Close returns the error of ctx without waiting for the remaining
+// events. Close does not close the underlying sink.
+func (as *AsyncEventSink) Close(ctx context.Context) error {
+	as.mu.Lock()
+	if as.closed {
+		as.mu.Unlock()
+		return nil
+	}
+	as.closed = true
+	as.mu.Unlock()
+
+	err := as.Flush(ctx)
+	close(as.queue)
+
+	return err
+}
@@ -0,0 +1,67 @@
+package csrf
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingSink is an EventSink that blocks until released.
+type blockingSink struct {
+	release chan struct{}
+
+	mu     sync.Mutex
+	events []Event
+}
+
+func (bs *blockingSink) Emit(e Event) {
+	<-bs.release
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.events = append(bs.events, e)
+}
+
+// TestAsyncSink tests that a blocked sink does not block requests, that
+// events beyond the buffer are dropped, and that Flush waits for the
+// queued events.
+func TestAsyncSink(t *testing.T) {
+	bs := &blockingSink{release: make(chan struct{})}
+	as := AsyncSink(bs, 2)
+	p := Protect(testKey, AuditEvents(as))(testHandler)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("requests blocked by the sink")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := as.Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("flush of a blocked sink: got %v", err)
+	}
+
+	close(bs.release)
+	if err := as.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// One event is taken by the goroutine, two are buffered.
+	bs.mu.Lock()
+	emitted := len(bs.events)
+	bs.mu.Unlock()
+	if emitted+int(as.Dropped()) != 5 || emitted < 2 || emitted > 3 {
+		t.Fatalf("got %d emitted, %d dropped", emitted, as.Dropped())
+	}
+}
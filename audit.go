@@ -0,0 +1,191 @@
+package csrf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit actions
+const (
+	// ActionReject is the action of a request rejected by the middleware.
+	ActionReject = "reject"
+	// ActionReport is the action of a failed request let through in
+	// report-only mode.
+	ActionReport = "report"
+)
+
+// Event is an audit event describing a request that failed CSRF validation.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Action is ActionReject or ActionReport.
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+	// Code is the stable code of the failure, see FailureCode.
+	Code       string `json:"code"`
+	Method     string `json:"method"`
+	Host       string `json:"host"`
+	Path       string `json:"path"`
+	RemoteAddr string `json:"remote_addr"`
+	// Origin is the origin of the Origin or Referer header, if any.
+	Origin string `json:"origin,omitempty"`
+}
+
+// EventSink receives the audit events of the middleware, see AuditEvents.
+// Emit is called on the request goroutine and must be safe for concurrent
+// use; wrap sinks that may block with AsyncSink.
+type EventSink interface {
+	Emit(e Event)
+}
+
+// newEvent returns the audit event of r failing with err.
+func (cs *csrf) newEvent(r *http.Request, err error) Event {
+	action := ActionReject
+	if cs.reportOnly(r) {
+		action = ActionReport
+	}
+
+	return Event{
+		Time:       timeNow(),
+		Action:     action,
+		Reason:     err.Error(),
+		Code:       failureCode(r, err),
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+		Origin:     failureOrigin(r),
+	}
+}
+
+// writerSink writes formatted events to an io.Writer, one per line.
+type writerSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format func(Event) string
+}
+
+// WriterSink returns an EventSink writing each event to w on a line of its
+// own, formatted with format, e.g. FormatJSON, FormatCEF or FormatLEEF.
+// Writes are serialized; write errors are ignored.
+func WriterSink(w io.Writer, format func(Event) string) EventSink {
+	return &writerSink{w: w, format: format}
+}
+
+func (ws *writerSink) Emit(e Event) {
+	line := ws.format(e) + "\n"
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	io.WriteString(ws.w, line)
+}
+
+// Event format constants
+const (
+	eventVendor  = "gorilla"
+	eventProduct = "csrf"
+	eventVersion = "1"
+)
+
+// FormatJSON formats e as a single line of JSON, e.g. for Elastic.
+func FormatJSON(e Event) string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+// FormatCEF formats e in the ArcSight Common Event Format, e.g. for Splunk:
+//
+//	CEF:0|gorilla|csrf|1|reject|CSRF token invalid|7|rt=... src=... request=...
+func FormatCEF(e Event) string {
+	severity := 7
+	if e.Action == ActionReport {
+		severity = 3
+	}
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(e.Time.UnixMilli(), 10),
+		"act=" + cefValue(e.Action),
+		"requestMethod=" + cefValue(e.Method),
+		"dhost=" + cefValue(e.Host),
+		"request=" + cefValue(e.Path),
+		"reason=" + cefValue(e.Reason),
+	}
+	if ip := remoteIP(e.RemoteAddr); ip != "" {
+		ext = append(ext, "src="+cefValue(ip))
+	}
+	if e.Origin != "" {
+		ext = append(ext, "cs1Label=origin", "cs1="+cefValue(e.Origin))
+	}
+	if e.Code != "" {
+		ext = append(ext, "cs2Label=code", "cs2="+cefValue(e.Code))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s", eventVendor, eventProduct, eventVersion,
+		cefHeader(e.Action), cefHeader(e.Reason), severity, strings.Join(ext, " "))
+}
+
+// FormatLEEF formats e in the IBM Log Event Extended Format 2.0, e.g. for
+// QRadar, with tab-separated (x09) attributes:
+//
+//	LEEF:2.0|gorilla|csrf|1|reject|x09|devTime=...	src=...	url=...
+func FormatLEEF(e Event) string {
+	sev := "7"
+	if e.Action == ActionReport {
+		sev = "3"
+	}
+
+	attrs := []string{
+		"devTime=" + strconv.FormatInt(e.Time.UnixMilli(), 10),
+		"devTimeFormat=epoch",
+		"cat=csrf",
+		"sev=" + sev,
+		"action=" + leefValue(e.Action),
+		"method=" + leefValue(e.Method),
+		"dstHost=" + leefValue(e.Host),
+		"url=" + leefValue(e.Path),
+		"reason=" + leefValue(e.Reason),
+	}
+	if ip := remoteIP(e.RemoteAddr); ip != "" {
+		attrs = append(attrs, "src="+leefValue(ip))
+	}
+	if e.Origin != "" {
+		attrs = append(attrs, "origin="+leefValue(e.Origin))
+	}
+	if e.Code != "" {
+		attrs = append(attrs, "code="+leefValue(e.Code))
+	}
+
+	return fmt.Sprintf("LEEF:2.0|%s|%s|%s|%s|x09|%s", eventVendor, eventProduct, eventVersion,
+		leefHeader(e.Action), strings.Join(attrs, "\t"))
+}
+
+// remoteIP returns the IP address of a http.Request.RemoteAddr.
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+var (
+	cefHeaderEscaper  = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefValueEscaper   = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	leefHeaderEscaper = strings.NewReplacer(`|`, ` `, "\r", " ", "\n", " ")
+	leefValueEscaper  = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+func cefHeader(s string) string  { return cefHeaderEscaper.Replace(s) }
+func cefValue(s string) string   { return cefValueEscaper.Replace(s) }
+func leefHeader(s string) string { return leefHeaderEscaper.Replace(s) }
+func leefValue(s string) string  { return leefValueEscaper.Replace(s) }
@@ -0,0 +1,87 @@
+package csrf
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eventRecorder is an EventSink recording all events.
+type eventRecorder struct {
+	events []Event
+}
+
+func (er *eventRecorder) Emit(e Event) {
+	er.events = append(er.events, e)
+}
+
+// TestAuditEvents tests that failed requests are emitted as audit events with
+// the action taken.
+func TestAuditEvents(t *testing.T) {
+	er := &eventRecorder{}
+	p := Protect(testKey, AuditEvents(er), ReportOnlyFrom("10.0.0.0/8"))(testHandler)
+
+	for _, remote := range []string{"192.0.2.1:1234", "10.0.0.1:1234"} {
+		r := httptest.NewRequest("POST", "/form", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("Origin", "https://evil.example")
+		p.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if len(er.events) != 2 {
+		t.Fatalf("events: got %d want 2", len(er.events))
+	}
+
+	e := er.events[0]
+	if e.Action != ActionReject || e.Reason != ErrNoToken.Error() || e.Path != "/form" ||
+		e.Origin != "https://evil.example" || e.RemoteAddr != "192.0.2.1:1234" {
+		t.Fatalf("rejected event: got %+v", e)
+	}
+	if er.events[1].Action != ActionReport {
+		t.Fatalf("reported event: got %+v", er.events[1])
+	}
+}
+
+// TestEventFormats tests the JSON, CEF and LEEF formats, including escaping.
+func TestEventFormats(t *testing.T) {
+	e := Event{
+		Time:       time.UnixMilli(1700000000123),
+		Action:     ActionReject,
+		Reason:     "bad|token=x\nnext",
+		Method:     "POST",
+		Host:       "example.com",
+		Path:       "/a=b",
+		RemoteAddr: "192.0.2.1:1234",
+		Origin:     "https://evil.example",
+	}
+
+	var decoded Event
+	if err := json.Unmarshal([]byte(FormatJSON(e)), &decoded); err != nil || decoded.Reason != e.Reason {
+		t.Fatalf("JSON: got %+v, %v", decoded, err)
+	}
+
+	cef := FormatCEF(e)
+	want := `CEF:0|gorilla|csrf|1|reject|bad\|token=x next|7|rt=1700000000123 act=reject requestMethod=POST ` +
+		`dhost=example.com request=/a\=b reason=bad|token\=x\nnext src=192.0.2.1 cs1Label=origin cs1=https://evil.example`
+	if cef != want {
+		t.Fatalf("CEF:\ngot  %s\nwant %s", cef, want)
+	}
+
+	leef := FormatLEEF(e)
+	if !strings.HasPrefix(leef, "LEEF:2.0|gorilla|csrf|1|reject|x09|devTime=1700000000123\t") ||
+		!strings.Contains(leef, "\treason=bad|token=x next\t") || strings.Contains(leef, "\n") {
+		t.Fatalf("LEEF: got %q", leef)
+	}
+
+	var buf bytes.Buffer
+	sink := WriterSink(&buf, FormatCEF)
+	sink.Emit(e)
+	sink.Emit(e)
+	if buf.String() != want+"\n"+want+"\n" {
+		t.Fatalf("WriterSink: got %q", buf.String())
+	}
+}
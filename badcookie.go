@@ -0,0 +1,46 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrBadCookie is returned by the BadCookieReject and BadCookieRotateReject
+// policies when the CSRF cookie of an unsafe request cannot be decoded, e.g.
+// because it was tampered with or signed with a previous key.
+var ErrBadCookie = errors.New("CSRF cookie invalid")
+
+// BadCookiePolicy governs how requests with a CSRF cookie that cannot be
+// decoded are handled. Missing and expired cookies are not affected.
+type BadCookiePolicy int
+
+// Bad cookie policies
+const (
+	// BadCookieRotate replaces the cookie with a new one, so that unsafe
+	// requests fail the token check with ErrBadToken. This is the default.
+	BadCookieRotate BadCookiePolicy = iota
+	// BadCookieReject rejects unsafe requests with ErrBadCookie and leaves
+	// the cookie in place. Safe requests still replace it.
+	BadCookieReject
+	// BadCookieRotateReject replaces the cookie and rejects unsafe requests
+	// with ErrBadCookie, so clients can tell that a retry with the new token
+	// may succeed.
+	BadCookieRotateReject
+	// BadCookieReportOnly replaces the cookie and lets unsafe requests
+	// through without a token check, logging ErrBadCookie to ErrorLog. This
+	// disables CSRF protection for such requests; use it only to assess the
+	// impact of a key change.
+	BadCookieReportOnly
+)
+
+// isBadCookie reports whether the result of Store.Get indicates a cookie that
+// is present but cannot be decoded.
+func isBadCookie(token []byte, err error) bool {
+	if err == nil {
+		return len(token) != tokenLength
+	}
+
+	return !errors.Is(err, http.ErrNoCookie) &&
+		!errors.Is(err, errNoSessionToken) &&
+		!errors.Is(err, ErrTokenExpired)
+}
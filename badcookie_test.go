@@ -0,0 +1,74 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestBadCookiePolicy tests the handling of unsafe requests with an undecodable
+// cookie under each policy.
+func TestBadCookiePolicy(t *testing.T) {
+	testTable := []struct {
+		policy  BadCookiePolicy
+		code    int
+		reason  error
+		rotated bool
+	}{
+		{BadCookieRotate, http.StatusForbidden, ErrBadToken, true},
+		{BadCookieReject, http.StatusForbidden, ErrBadCookie, false},
+		{BadCookieRotateReject, http.StatusForbidden, ErrBadCookie, true},
+		{BadCookieReportOnly, http.StatusOK, nil, true},
+	}
+
+	for _, item := range testTable {
+		var reason error
+		p := Protect(testKey, BadCookie(item.policy), ErrorHandler(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				reason = FailureReason(r)
+				w.WriteHeader(http.StatusForbidden)
+			})))(testHandler)
+
+		r, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.AddCookie(&http.Cookie{Name: cookieName, Value: "tampered"})
+		r.Header.Set("X-CSRF-Token", "dG9rZW4=")
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("policy %d: got %v want %v", item.policy, rr.Code, item.code)
+		}
+		if reason != item.reason {
+			t.Errorf("policy %d: got reason %v want %v", item.policy, reason, item.reason)
+		}
+		if rotated := rr.Header().Get("Set-Cookie") != ""; rotated != item.rotated {
+			t.Errorf("policy %d: cookie rotated: got %v want %v", item.policy, rotated, item.rotated)
+		}
+	}
+}
+
+// TestBadCookieMissing tests that a missing cookie is not a bad cookie.
+func TestBadCookieMissing(t *testing.T) {
+	var reason error
+	p := Protect(testKey, BadCookie(BadCookieReject), ErrorHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			reason = FailureReason(r)
+			w.WriteHeader(http.StatusForbidden)
+		})))(testHandler)
+
+	r, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if reason != ErrNoToken {
+		t.Fatalf("missing cookie: got reason %v want %v", reason, ErrNoToken)
+	}
+}
@@ -0,0 +1,129 @@
+package csrf
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+)
+
+// bootstrap is the token information exposed to front-end code.
+type bootstrap struct {
+	Token  string `json:"token"`
+	Header string `json:"header"`
+}
+
+// bootstrapJSON returns the token and request header name of r as JSON. The
+// encoding escapes <, > and & so the result is safe to embed in a <script>.
+func bootstrapJSON(r *http.Request) (string, bool) {
+	header := HeaderName(r)
+	if header == "" {
+		return "", false
+	}
+
+	b, err := json.Marshal(bootstrap{Token: Token(r), Header: header})
+	if err != nil {
+		return "", false
+	}
+
+	return string(b), true
+}
+
+// ScriptTag returns an inline <script> exposing the CSRF token and the request
+// header to send it in as window.__CSRF__, so that fetch()-based front ends
+// can attach the token without parsing hidden inputs:
+//
+//	<script>window.__CSRF__ = {"token":"...","header":"X-CSRF-Token"};</script>
+//
+//	fetch(url, {method: "POST", headers: {[__CSRF__.header]: __CSRF__.token}})
+//
+// Pages with a strict Content-Security-Policy should use JSONScriptTag instead.
+func ScriptTag(r *http.Request) template.HTML {
+	js, ok := bootstrapJSON(r)
+	if !ok {
+		return template.HTML("")
+	}
+
+	return template.HTML("<script>window.__CSRF__ = " + js + ";</script>")
+}
+
+// JSONScriptTag returns a non-executable <script type="application/json">
+// element with the given id holding the CSRF token and request header, e.g.
+// for JSON.parse(document.getElementById(id).textContent).
+func JSONScriptTag(r *http.Request, id string) template.HTML {
+	js, ok := bootstrapJSON(r)
+	if !ok {
+		return template.HTML("")
+	}
+
+	return template.HTML(fmt.Sprintf(`<script type="application/json" id="%s">%s</script>`,
+		html.EscapeString(id), js))
+}
+
+// TokenHandler returns a handler for single-page applications that issues the
+// CSRF cookie and returns the matching masked token in one response, as JSON
+// in the format of ScriptTag:
+//
+//	{"token":"...","header":"X-CSRF-Token"}
+//
+// A valid cookie sent with the request is re-issued unchanged, without
+// extending its IdleTimeout or AbsoluteTimeout, and a new one is issued
+// otherwise, so that the cookie and the token in the response always match,
+// e.g. after a hard refresh served a cached page with a stale token. Mount the
+// handler for GET requests.
+//
+// The response is marked private and no-store for browsers, proxies and CDNs,
+// and carries no validators (ETag or Last-Modified) that a cache could use to
+// revalidate one user's token for another: conditional requests always
+// receive a full response. Outer middleware must not add validators either.
+func (m *Middleware) TokenHandler() http.Handler {
+	cs := m.cs
+	return cs.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		noStore(w.Header())
+
+		js, ok := bootstrapJSON(r)
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// Re-issue the cookie of the token, so that both arrive together.
+		if realToken, err := cs.issuedToken(r); err == nil {
+			if err := cs.save(r, realToken, w); err != nil {
+				cs.logf("re-issuing CSRF cookie: %v", err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, js)
+	}))
+}
+
+// issuedToken returns the real token of the masked token issued for r. Tokens
+// bound to the TLS connection (see BindTLS) cannot be unmasked to the real
+// token, so it is read from the cookie instead; a newly generated token has
+// already been saved by the middleware.
+func (cs *csrf) issuedToken(r *http.Request) ([]byte, error) {
+	if cs.opts.BindTLS {
+		return cs.st.Get(r)
+	}
+
+	issued, err := decodeToken(Token(r), encodedTokenLength)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmask(issued), nil
+}
+
+// noStore marks a response as uncacheable by browsers and shared caches, and
+// removes its validators.
+func noStore(h http.Header) {
+	h.Set("Cache-Control", "private, no-store, no-cache, max-age=0")
+	h.Set("Pragma", "no-cache")
+	h.Set("Expires", "0")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+}
@@ -0,0 +1,242 @@
+package csrf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestScriptTag tests that the bootstrap snippets expose the token and the
+// configured header name.
+func TestScriptTag(t *testing.T) {
+	var token, script, blob string
+	s := http.NewServeMux()
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+		script = string(ScriptTag(r))
+		blob = string(JSONScriptTag(r, "csrf"))
+	})
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Protect(testKey, RequestHeader("X-Token"))(s).ServeHTTP(httptest.NewRecorder(), r)
+
+	prefix, suffix := "<script>window.__CSRF__ = ", ";</script>"
+	if !strings.HasPrefix(script, prefix) || !strings.HasSuffix(script, suffix) {
+		t.Fatalf("unexpected script tag: got %q", script)
+	}
+
+	var b bootstrap
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(script, prefix), suffix)), &b); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Token != token || b.Header != "X-Token" {
+		t.Fatalf("unexpected bootstrap data: got %+v", b)
+	}
+
+	if !strings.HasPrefix(blob, `<script type="application/json" id="csrf">{"token":`) {
+		t.Fatalf("unexpected JSON script tag: got %q", blob)
+	}
+
+	if ScriptTag(r) != "" {
+		t.Fatal("script tag rendered without the middleware")
+	}
+}
+
+// TestTokenHandler tests that the token endpoint returns a token matching the
+// cookie in the same response, re-issuing a valid cookie, and is not cached.
+func TestTokenHandler(t *testing.T) {
+	m, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := m.TokenHandler()
+	protected := m.Wrap(testHandler)
+
+	fetch := func(cookie *httptest.ResponseRecorder) (*httptest.ResponseRecorder, bootstrap) {
+		r := httptest.NewRequest("GET", "/csrf-token", nil)
+		if cookie != nil {
+			setCookie(cookie, r)
+		}
+
+		rr := httptest.NewRecorder()
+		endpoint.ServeHTTP(rr, r)
+
+		var b bootstrap
+		if err := json.Unmarshal(rr.Body.Bytes(), &b); err != nil {
+			t.Fatalf("invalid response %q: %v", rr.Body.String(), err)
+		}
+		if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "no-store") {
+			t.Fatalf("response cacheable: got Cache-Control %q", cc)
+		}
+		if n := len(rr.Header().Values("Set-Cookie")); n != 1 {
+			t.Fatalf("cookie not issued once: got %d Set-Cookie headers", n)
+		}
+		return rr, b
+	}
+
+	post := func(cookie *httptest.ResponseRecorder, token string) int {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set(headerName, token)
+		setCookie(cookie, r)
+
+		rr := httptest.NewRecorder()
+		protected.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	first, b := fetch(nil)
+	if b.Header != headerName || post(first, b.Token) != http.StatusOK {
+		t.Fatalf("issued token rejected: got %+v", b)
+	}
+
+	second, b2 := fetch(first)
+	if post(second, b2.Token) != http.StatusOK || post(second, b.Token) != http.StatusOK {
+		t.Fatal("re-issued cookie does not keep the token")
+	}
+}
+
+// TestTokenHandlerTimeouts tests that polling the token endpoint does not keep
+// a token alive past its idle or absolute timeout.
+func TestTokenHandlerTimeouts(t *testing.T) {
+	for _, opt := range []Option{IdleTimeout(time.Hour), AbsoluteTimeout(time.Hour)} {
+		start := time.Now()
+		clock := start
+		timeNow = func() time.Time { return clock }
+		defer func() { timeNow = time.Now }()
+
+		m, err := New(testKey, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoint := m.TokenHandler()
+
+		var cookies []*http.Cookie
+		var first bootstrap
+		for elapsed := time.Duration(0); elapsed <= 80*time.Minute; elapsed += 40 * time.Minute {
+			clock = start.Add(elapsed)
+
+			r := httptest.NewRequest("GET", "/csrf-token", nil)
+			for _, c := range cookies {
+				r.AddCookie(c)
+			}
+			rr := httptest.NewRecorder()
+			endpoint.ServeHTTP(rr, r)
+			cookies = rr.Result().Cookies()
+
+			var b bootstrap
+			if err := json.Unmarshal(rr.Body.Bytes(), &b); err != nil {
+				t.Fatalf("invalid response %q: %v", rr.Body.String(), err)
+			}
+			if elapsed == 0 {
+				first = b
+			}
+		}
+
+		// The cookie now holds a new token, which the first one does not match.
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set(headerName, first.Token)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		m.Wrap(testHandler).ServeHTTP(rr, r)
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("token kept alive past its timeout by polling: got %v", rr.Code)
+		}
+	}
+}
+
+// sharedCache is a shared cache in front of a handler, such as a CDN that is
+// configured to cache all GET responses, including their cookies, unless the
+// response forbids it.
+type sharedCache struct {
+	h       http.Handler
+	entries map[string]*httptest.ResponseRecorder
+}
+
+func (c *sharedCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rr, ok := c.entries[r.URL.Path]; ok {
+		for k, v := range rr.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rr.Code)
+		w.Write(rr.Body.Bytes())
+		return
+	}
+
+	rr := httptest.NewRecorder()
+	c.h.ServeHTTP(rr, r)
+
+	cc := rr.Header().Get("Cache-Control")
+	if !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private") {
+		c.entries[r.URL.Path] = rr
+	}
+
+	for k, v := range rr.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rr.Code)
+	w.Write(rr.Body.Bytes())
+}
+
+// TestTokenHandlerUncacheable tests that a shared cache cannot serve the token
+// of one user to another, and that the response carries no validators.
+func TestTokenHandlerUncacheable(t *testing.T) {
+	m, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Validators set by other middleware are removed.
+	endpoint := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"static"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		m.TokenHandler().ServeHTTP(w, r)
+	})
+	cdn := &sharedCache{h: endpoint, entries: make(map[string]*httptest.ResponseRecorder)}
+
+	fetch := func() (*httptest.ResponseRecorder, bootstrap) {
+		r := httptest.NewRequest("GET", "/csrf-token", nil)
+		r.Header.Set("If-None-Match", `"static"`)
+		r.Header.Set("If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT")
+
+		rr := httptest.NewRecorder()
+		cdn.ServeHTTP(rr, r)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("conditional request not answered in full: got %v", rr.Code)
+		}
+		for _, h := range []string{"ETag", "Last-Modified"} {
+			if v := rr.Header().Get(h); v != "" {
+				t.Fatalf("validator %s set: got %q", h, v)
+			}
+		}
+		if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "private") || !strings.Contains(cc, "no-store") {
+			t.Fatalf("response cacheable by shared caches: got Cache-Control %q", cc)
+		}
+
+		var b bootstrap
+		if err := json.Unmarshal(rr.Body.Bytes(), &b); err != nil {
+			t.Fatal(err)
+		}
+		return rr, b
+	}
+
+	alice, a := fetch()
+	bob, b := fetch()
+
+	if a.Token == b.Token || alice.Header().Get("Set-Cookie") == bob.Header().Get("Set-Cookie") {
+		t.Fatal("shared cache served the same token to different users")
+	}
+	if len(cdn.entries) != 0 {
+		t.Fatal("token response stored by shared cache")
+	}
+}
@@ -0,0 +1,97 @@
+package csrf
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CookielessPolicy governs unsafe requests that carry no cookies at all.
+type CookielessPolicy int
+
+// Cookie-less client policies
+const (
+	// CookielessReject validates cookie-less requests like any other request,
+	// which rejects them for lack of a CSRF cookie. This is the default.
+	CookielessReject CookielessPolicy = iota
+	// CookielessSkip skips validation for requests without any cookies, e.g.
+	// pure API clients that never authenticate via cookies.
+	CookielessSkip
+	// CookielessSkipUncredentialed skips validation for requests that carry no
+	// ambient credentials at all: no cookies, no Authorization header (which
+	// browsers attach automatically for Basic, Digest or NTLM auth) and no TLS
+	// client certificate. Such requests are not CSRF-able, e.g. cross-origin
+	// API calls without credentials.
+	CookielessSkipUncredentialed
+)
+
+// skipCookieless reports whether r is an unsafe request without any cookies
+// (or ambient credentials) that should skip validation according to the
+// CookielessPolicy.
+func (cs *csrf) skipCookieless(r *http.Request) bool {
+	if contains(safeMethods, r.Method) {
+		return false
+	}
+
+	switch cs.opts.CookielessPolicy {
+	case CookielessSkip:
+		return r.Header.Get("Cookie") == ""
+	case CookielessSkipUncredentialed:
+		return r.Header.Get("Cookie") == "" && r.Header.Get("Authorization") == "" &&
+			(r.TLS == nil || len(r.TLS.PeerCertificates) == 0)
+	}
+
+	return false
+}
+
+// skipAuthHeader reports whether r carries the configured authentication
+// header with one of the accepted schemes. Such requests cannot be forged by
+// a browser without a CORS preflight and are therefore not CSRF-able.
+func (cs *csrf) skipAuthHeader(r *http.Request) bool {
+	if cs.opts.AuthHeader == "" {
+		return false
+	}
+
+	value := r.Header.Get(cs.opts.AuthHeader)
+	if value == "" {
+		return false
+	}
+
+	for _, scheme := range cs.opts.AuthSchemes {
+		if len(value) > len(scheme) && strings.EqualFold(value[:len(scheme)], scheme) && value[len(scheme)] == ' ' {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skipClientCert reports whether r presents a verified TLS client certificate
+// with one of the configured subject alternative names.
+func (cs *csrf) skipClientCert(r *http.Request) bool {
+	if len(cs.opts.ClientCertSANs) == 0 || r.TLS == nil {
+		return false
+	}
+
+	// Only trust certificates that were verified during the handshake.
+	if len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+
+	for _, san := range sans {
+		if contains(cs.opts.ClientCertSANs, san) {
+			return true
+		}
+	}
+
+	return false
+}
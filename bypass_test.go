@@ -0,0 +1,126 @@
+package csrf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSkipAuthHeader tests that requests carrying a bearer token skip CSRF
+// validation, while other schemes are still checked.
+func TestSkipAuthHeader(t *testing.T) {
+	p := Protect(testKey, SkipAuthHeader("Authorization"))(testHandler)
+
+	testTable := []struct {
+		auth string
+		code int
+	}{
+		{"Bearer abc", http.StatusOK},
+		{"bearer abc", http.StatusOK},
+		{"Basic dXNlcjpwYXNz", http.StatusForbidden},
+		{"Bearer", http.StatusForbidden},
+		{"", http.StatusForbidden},
+	}
+
+	for _, item := range testTable {
+		r, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.auth != "" {
+			r.Header.Set("Authorization", item.auth)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("Authorization %q: got %v want %v", item.auth, rr.Code, item.code)
+		}
+	}
+}
+
+// TestSkipClientCerts tests that requests with a verified client certificate
+// matching a configured SAN skip CSRF validation.
+func TestSkipClientCerts(t *testing.T) {
+	p := Protect(testKey, SkipClientCerts("billing.internal"))(testHandler)
+
+	cert := &x509.Certificate{DNSNames: []string{"billing.internal"}}
+	other := &x509.Certificate{DNSNames: []string{"www.example.com"}}
+
+	testTable := []struct {
+		state *tls.ConnectionState
+		code  int
+	}{
+		{&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}, http.StatusOK},
+		{&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, http.StatusForbidden},
+		{&tls.ConnectionState{PeerCertificates: []*x509.Certificate{other}, VerifiedChains: [][]*x509.Certificate{{other}}}, http.StatusForbidden},
+		{nil, http.StatusForbidden},
+	}
+
+	for i, item := range testTable {
+		r, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.TLS = item.state
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("case %d: got %v want %v", i, rr.Code, item.code)
+		}
+	}
+}
+
+// TestCookieless tests that unsafe requests without any cookies skip CSRF
+// validation with CookielessSkip, but not if they carry any cookie, and that
+// CookielessSkipUncredentialed also requires the absence of other ambient
+// credentials.
+func TestCookieless(t *testing.T) {
+	testTable := []struct {
+		policy CookielessPolicy
+		cookie string
+		auth   string
+		cert   bool
+		code   int
+	}{
+		{CookielessReject, "", "", false, http.StatusForbidden},
+		{CookielessSkip, "", "", false, http.StatusOK},
+		{CookielessSkip, "session=abc", "", false, http.StatusForbidden},
+		{CookielessSkip, "", "Basic dXNlcjpwYXNz", false, http.StatusOK},
+		{CookielessSkipUncredentialed, "", "", false, http.StatusOK},
+		{CookielessSkipUncredentialed, "session=abc", "", false, http.StatusForbidden},
+		{CookielessSkipUncredentialed, "", "Basic dXNlcjpwYXNz", false, http.StatusForbidden},
+		{CookielessSkipUncredentialed, "", "", true, http.StatusForbidden},
+	}
+
+	for _, item := range testTable {
+		p := Protect(testKey, Cookieless(item.policy))(testHandler)
+
+		r, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.cookie != "" {
+			r.Header.Set("Cookie", item.cookie)
+		}
+		if item.auth != "" {
+			r.Header.Set("Authorization", item.auth)
+		}
+		if item.cert {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("policy %v, cookie %q, auth %q, cert %v: got %v want %v",
+				item.policy, item.cookie, item.auth, item.cert, rr.Code, item.code)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// contextCloser is implemented by resources that can be closed within the
+// deadline of a context, e.g. AsyncEventSink.
+type contextCloser interface {
+	Close(ctx context.Context) error
+}
+
+// Close releases the resources of the middleware on shutdown, after the
+// server has stopped serving requests, e.g. after http.Server.Shutdown: it
+// flushes the ReportURI reports, stops background refreshes of
+// TrustedOriginsURL, and closes the EventSink, the TokenStore, the
+// SessionStore or the OpaqueTokens backend if they have a Close method,
+// either Close() error or Close(context.Context) error.
+//
+// Close returns the errors of all resources joined, including the error of
+// ctx if it is done before the reports are flushed. The middleware must not be used after
+// Close; calling Close again returns nil.
+func (m *Middleware) Close(ctx context.Context) error {
+	if !m.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	cs := m.cs
+
+	var errs []error
+	for _, src := range cs.originSources {
+		if ro, ok := src.(*remoteOrigins); ok {
+			ro.stop()
+		}
+	}
+
+	if cs.reporter != nil {
+		errs = append(errs, cs.reporter.async.Close(ctx))
+	}
+	errs = append(errs, closeResource(ctx, cs.opts.EventSink))
+
+	st := cs.st
+	if gs, ok := st.(*guardedStore); ok {
+		st = gs.st
+	}
+	if ss, ok := st.(*splitStore); ok {
+		// Both stores share the backend.
+		st = ss.tls
+	}
+	switch st := st.(type) {
+	case *opaqueStore:
+		errs = append(errs, closeResource(ctx, st.backend))
+	case *sessionStore:
+		errs = append(errs, closeResource(ctx, st.store))
+	default:
+		errs = append(errs, closeResource(ctx, st))
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeResource closes v if it has a Close method.
+func closeResource(ctx context.Context, v interface{}) error {
+	switch v := v.(type) {
+	case contextCloser:
+		return v.Close(ctx)
+	case io.Closer:
+		return v.Close()
+	}
+
+	return nil
+}
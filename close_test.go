@@ -0,0 +1,56 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// closingBackend is a TokenBackend that records being closed.
+type closingBackend struct {
+	memoryBackend
+	closed int
+}
+
+func (cb *closingBackend) Close() error {
+	cb.closed++
+	return errors.New("connection reset")
+}
+
+// TestClose tests that Close flushes the pending reports, closes the backend
+// and reports its error, and does nothing when called again.
+func TestClose(t *testing.T) {
+	var reports atomic.Int64
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reports.Add(1)
+	}))
+	defer endpoint.Close()
+
+	backend := &closingBackend{memoryBackend: memoryBackend{tokens: make(map[string][]byte)}}
+	m, err := New(testKey, OpaqueTokens(backend), ReportURI(endpoint.URL, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := m.Wrap(testHandler)
+	for i := 0; i < 3; i++ {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	}
+
+	if err := m.Close(context.Background()); err == nil || err.Error() != "connection reset" {
+		t.Fatalf("got %v, want the error of the backend", err)
+	}
+	if n := reports.Load(); n != 3 {
+		t.Fatalf("reports: got %d want 3", n)
+	}
+	if backend.closed != 1 {
+		t.Fatalf("backend closed %d times", backend.closed)
+	}
+
+	if err := m.Close(context.Background()); err != nil || backend.closed != 1 {
+		t.Fatalf("second close: got %v, backend closed %d times", err, backend.closed)
+	}
+}
@@ -0,0 +1,47 @@
+// Command csrfkeygen prints a cryptographically random 32 byte authentication
+// key for the CSRF middleware, as returned by csrf.GenerateKey.
+//
+// Usage:
+//
+//	csrfkeygen [-format base64|hex]
+//
+// Decode the key before passing it to csrf.Protect, e.g. with
+// base64.StdEncoding.DecodeString.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/meplato/csrf"
+)
+
+func main() {
+	format := flag.String("format", "base64", "output format: base64 or hex")
+	flag.Parse()
+
+	var encode func([]byte) string
+	switch *format {
+	case "base64":
+		encode = base64.StdEncoding.EncodeToString
+	case "hex":
+		encode = hex.EncodeToString
+	default:
+		fatalf("invalid format %q: must be base64 or hex", *format)
+	}
+
+	key, err := csrf.GenerateKey()
+	if err != nil {
+		fatalf("generating key: %v", err)
+	}
+
+	fmt.Println(encode(key))
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "csrfkeygen: "+format+"\n", args...)
+	os.Exit(2)
+}
@@ -0,0 +1,130 @@
+package csrf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNoCookie is returned if the request carries a token, but no CSRF cookie
+// (or session) to check it against, e.g. because the browser blocked or
+// dropped the cookie.
+var ErrNoCookie = fmt.Errorf("%w: no CSRF cookie", ErrBadToken)
+
+// Failure codes are stable, machine-readable identifiers of the classes of
+// validation failures, see FailureCode. Codes are never renumbered or reused,
+// so dashboards and runbooks can refer to them across releases.
+const (
+	CodeNoCookie         = "CSRF001_NO_COOKIE"         // ErrNoCookie
+	CodeNoToken          = "CSRF002_NO_TOKEN"          // ErrNoToken
+	CodeBadToken         = "CSRF003_BAD_TOKEN"         // ErrBadToken
+	CodeTokenTooLong     = "CSRF004_TOKEN_TOO_LONG"    // ErrTokenTooLong
+	CodeTokenEncoding    = "CSRF005_TOKEN_ENCODING"    // ErrTokenEncoding
+	CodeTokenLength      = "CSRF006_TOKEN_LENGTH"      // ErrTokenLength
+	CodeTokenExpired     = "CSRF007_TOKEN_EXPIRED"     // ErrTokenExpired
+	CodeNoReferer        = "CSRF008_NO_REFERER"        // ErrNoReferer
+	CodeBadReferer       = "CSRF009_BAD_REFERER"       // ErrBadReferer
+	CodeBadRefererPath   = "CSRF010_BAD_REFERER_PATH"  // ErrBadRefererPath
+	CodeBadOrigin        = "CSRF011_BAD_ORIGIN"        // ErrBadOrigin
+	CodeOriginLookup     = "CSRF012_ORIGIN_LOOKUP"     // ErrOriginLookup
+	CodeBadCookie        = "CSRF013_BAD_COOKIE"        // ErrBadCookie
+	CodeCompanionToken   = "CSRF014_COMPANION_TOKEN"   // ErrCompanionToken
+	CodeTLSBinding       = "CSRF015_TLS_BINDING"       // ErrTLSBinding
+	CodeBodyTooLarge     = "CSRF016_BODY_TOO_LARGE"    // ErrBodyTooLarge
+	CodeVerifyTimeout    = "CSRF017_VERIFY_TIMEOUT"    // ErrVerifyTimeout
+	CodeStoreUnavailable = "CSRF018_STORE_UNAVAILABLE" // ErrStoreUnavailable
+	CodeBadWebhook       = "CSRF019_BAD_WEBHOOK"       // ErrBadWebhookSignature
+	CodeCookieWrite      = "CSRF020_COOKIE_WRITE"      // ErrCookieWrite
+	CodeDomainMismatch   = "CSRF021_DOMAIN_MISMATCH"   // ErrDomainMismatch
+	CodeInternal         = "CSRF999_INTERNAL"          // any other error
+)
+
+// failureCodes maps errors to their codes. Errors wrapping others come first,
+// e.g. ErrNoCookie before ErrBadToken.
+var failureCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrNoCookie, CodeNoCookie},
+	{ErrNoToken, CodeNoToken},
+	{ErrTokenTooLong, CodeTokenTooLong},
+	{ErrTokenEncoding, CodeTokenEncoding},
+	{ErrTokenLength, CodeTokenLength},
+	{ErrTokenExpired, CodeTokenExpired},
+	{ErrBadToken, CodeBadToken},
+	{ErrNoReferer, CodeNoReferer},
+	{ErrBadRefererPath, CodeBadRefererPath},
+	{ErrBadReferer, CodeBadReferer},
+	{ErrBadOrigin, CodeBadOrigin},
+	{ErrOriginLookup, CodeOriginLookup},
+	{ErrBadCookie, CodeBadCookie},
+	{ErrCompanionToken, CodeCompanionToken},
+	{ErrTLSBinding, CodeTLSBinding},
+	{ErrBodyTooLarge, CodeBodyTooLarge},
+	{ErrVerifyTimeout, CodeVerifyTimeout},
+	{ErrStoreUnavailable, CodeStoreUnavailable},
+	{ErrBadWebhookSignature, CodeBadWebhook},
+	{ErrDomainMismatch, CodeDomainMismatch},
+	{ErrCookieWrite, CodeCookieWrite},
+}
+
+// FailureCode returns the stable code of a validation failure, e.g.
+// CodeBadToken for ErrBadToken, or CodeInternal for errors without a code of
+// their own. It returns an empty string for a nil error.
+func FailureCode(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	for _, fc := range failureCodes {
+		if errors.Is(err, fc.err) {
+			return fc.code
+		}
+	}
+
+	return CodeInternal
+}
+
+// failureCode is like FailureCode, but returns the code of the cause of
+// ErrBadToken if r failed because its token was malformed, see MalformedToken.
+func failureCode(r *http.Request, err error) string {
+	if err == ErrBadToken {
+		if cause := MalformedToken(r); cause != nil {
+			return FailureCode(cause)
+		}
+	}
+
+	return FailureCode(err)
+}
+
+// failureHeader is the response header carrying the failure code with
+// DebugHeaders.
+const failureHeader = "X-CSRF-Failure"
+
+// DebugHeaders sets the X-CSRF-Failure response header to the failure code of
+// requests that fail validation, e.g. "CSRF003_BAD_TOKEN", including those let
+// through in report-only mode. It is meant for staging environments and
+// support sessions; the header tells attackers why a forged request failed.
+func DebugHeaders() Option {
+	return func(cs *csrf) {
+		cs.opts.DebugHeaders = true
+	}
+}
+
+// refineBadToken returns ErrNoCookie, or ErrBadToken wrapping ErrTokenExpired,
+// instead of ErrBadToken if the store found no token, or an expired one, as
+// reported by storeErr.
+func refineBadToken(err, storeErr error) error {
+	if err != ErrBadToken {
+		return err
+	}
+
+	switch {
+	case errors.Is(storeErr, ErrTokenExpired):
+		return fmt.Errorf("%w: %w", ErrBadToken, ErrTokenExpired)
+	case errors.Is(storeErr, http.ErrNoCookie), errors.Is(storeErr, errNoSessionToken):
+		return ErrNoCookie
+	}
+
+	return err
+}
@@ -0,0 +1,81 @@
+package csrf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestFailureCode tests that errors map to their codes, including wrapped
+// errors.
+func TestFailureCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{nil, ""},
+		{ErrNoCookie, CodeNoCookie},
+		{ErrNoToken, CodeNoToken},
+		{ErrBadToken, CodeBadToken},
+		{ErrTokenEncoding, CodeTokenEncoding},
+		{fmt.Errorf("%w: %w", ErrBadToken, ErrTokenExpired), CodeTokenExpired},
+		{ErrBadRefererPath, CodeBadRefererPath},
+		{ErrBadReferer, CodeBadReferer},
+		{fmt.Errorf("%w: %w", ErrOriginLookup, errors.New("timeout")), CodeOriginLookup},
+		{errors.New("entropy exhausted"), CodeInternal},
+	}
+
+	for _, tt := range tests {
+		if code := FailureCode(tt.err); code != tt.code {
+			t.Errorf("%v: got %q want %q", tt.err, code, tt.code)
+		}
+	}
+}
+
+// TestFailureCodes tests that failures are counted per code, and that a
+// request without a cookie is told apart from a mismatched token.
+func TestFailureCodes(t *testing.T) {
+	m, err := New(testKey, DebugHeaders())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var token string
+	p := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	}))
+
+	get := httptest.NewRecorder()
+	p.ServeHTTP(get, httptest.NewRequest("GET", "/", nil))
+
+	post := func(cookie bool, token string) string {
+		r := httptest.NewRequest("POST", "/", nil)
+		if cookie {
+			setCookie(get, r)
+		}
+		if token != "" {
+			r.Header.Set("X-CSRF-Token", token)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+		return rr.Header().Get("X-CSRF-Failure")
+	}
+
+	if code := post(false, token); code != CodeNoCookie {
+		t.Errorf("no cookie: got %q want %q", code, CodeNoCookie)
+	}
+	if code := post(true, ""); code != CodeNoToken {
+		t.Errorf("no token: got %q want %q", code, CodeNoToken)
+	}
+	if code := post(true, token); code != "" {
+		t.Errorf("valid token: got %q", code)
+	}
+
+	counts := m.FailureCounts()
+	if len(counts) != 2 || counts[CodeNoCookie] != 1 || counts[CodeNoToken] != 1 {
+		t.Fatalf("unexpected failure counts: %v", counts)
+	}
+}
@@ -0,0 +1,31 @@
+package csrf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrCompanionToken is wrapped in ErrBadToken if the companion header set
+// with CompanionHeader is missing or does not match the CSRF cookie.
+var ErrCompanionToken = errors.New("CSRF companion header missing or invalid")
+
+// checkCompanion checks the companion header of r against the real token, so
+// that the submitted token, the companion header and the cookie all match.
+func (cs *csrf) checkCompanion(r *http.Request, realToken []byte) error {
+	if cs.opts.CompanionHeader == "" {
+		return nil
+	}
+
+	value := r.Header.Get(cs.opts.CompanionHeader)
+	if value == "" {
+		return fmt.Errorf("%w: %w", ErrBadToken, ErrCompanionToken)
+	}
+
+	issued, err := decodeToken(value, cs.opts.MaxHeaderTokenSize)
+	if err != nil || !compareTokens(unmask(issued), realToken) {
+		return fmt.Errorf("%w: %w", ErrBadToken, ErrCompanionToken)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestCompanionHeader tests that the submitted token, the companion header and
+// the cookie must all match.
+func TestCompanionHeader(t *testing.T) {
+	var token string
+	var reason error
+	p := Protect(testKey, CompanionHeader("X-CSRF-Companion"),
+		ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reason = FailureReason(r)
+			w.WriteHeader(http.StatusForbidden)
+		})))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	}))
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	// A token for another cookie, e.g. planted by an attacker.
+	other := httptest.NewRecorder()
+	otherToken := ""
+	Protect(testKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherToken = Token(r)
+	})).ServeHTTP(other, httptest.NewRequest("GET", "/", nil))
+
+	for _, item := range []struct {
+		companion string
+		code      int
+	}{
+		{token, http.StatusOK},
+		{"", http.StatusForbidden},
+		{otherToken, http.StatusForbidden},
+		{"garbage", http.StatusForbidden},
+	} {
+		reason = nil
+		form := url.Values{fieldName: {token}}
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if item.companion != "" {
+			r.Header.Set("X-CSRF-Companion", item.companion)
+		}
+		setCookie(rr, r)
+
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, r)
+
+		if res.Code != item.code {
+			t.Fatalf("companion %.20q: got %v want %v", item.companion, res.Code, item.code)
+		}
+		if item.code != http.StatusOK && !errors.Is(reason, ErrCompanionToken) {
+			t.Fatalf("companion %.20q: got %v want %v", item.companion, reason, ErrCompanionToken)
+		}
+	}
+}
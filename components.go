@@ -0,0 +1,33 @@
+package csrf
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// FieldComponent renders the hidden CSRF <input> field. It satisfies the
+// templ.Component interface of github.com/a-h/templ, so it can be used as
+// @csrf.TemplComponent(r) in a templ template.
+type FieldComponent struct {
+	r *http.Request
+}
+
+// TemplComponent returns the CSRF field of r as a templ component.
+func TemplComponent(r *http.Request) FieldComponent {
+	return FieldComponent{r: r}
+}
+
+// Render writes the CSRF field to w.
+func (c FieldComponent) Render(ctx context.Context, w io.Writer) error {
+	_, err := io.WriteString(w, string(TemplateField(c.r)))
+	return err
+}
+
+// WriteTemplateField writes the CSRF field of r to w. It follows the
+// conventions of the Write* functions generated by quicktemplate, so it can be
+// called from a .qtpl template as
+// {% code csrf.WriteTemplateField(qw422016.N(), r) %}.
+func WriteTemplateField(w io.Writer, r *http.Request) {
+	io.WriteString(w, string(TemplateField(r)))
+}
@@ -0,0 +1,81 @@
+package csrf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// VerifyRequest checks the CSRF token of r - or its origin, with
+// JSONPolicy(PolicyOrigin) for JSON API calls - against the cookie, as the
+// wrapped handlers do, but without writing a response: the caller decides how
+// to reject the request. Safe requests always pass. Together with IssueToken,
+// it allows compositions that Wrap cannot express, e.g. verifying in one layer
+// and issuing tokens in another.
+//
+// Token grants, exclusions, report-only mode and the other skip options are
+// not applied, and no Outcome, counters or audit events are recorded.
+func (m *Middleware) VerifyRequest(r *http.Request) error {
+	cs := m.cs
+	if contains(safeMethods, r.Method) {
+		return nil
+	}
+
+	realToken, err := cs.st.Get(r)
+	if errors.Is(err, ErrStoreUnavailable) {
+		return err
+	}
+	storeErr := err
+	if isBadCookie(realToken, err) {
+		switch cs.opts.BadCookiePolicy {
+		case BadCookieReject, BadCookieRotateReject:
+			return ErrBadCookie
+		}
+	}
+	if err != nil || len(realToken) != tokenLength {
+		// Without a valid cookie no token can match.
+		realToken = nil
+	}
+
+	if cs.opts.JSONPolicy == PolicyOrigin && isJSONRequest(r) {
+		return cs.checkFetchOrigin(r)
+	}
+
+	// Cookies refreshed on use, e.g. with IdleTimeout, are not written.
+	err = cs.verify(&headerWriter{header: make(http.Header)}, r, realToken)
+	if errors.As(err, &malformedToken{}) {
+		return ErrBadToken
+	}
+	return refineBadToken(err, storeErr)
+}
+
+// IssueToken returns a masked token for r, as Token does in a wrapped handler,
+// for handlers that are not wrapped by m, e.g. a login page served by a
+// separate mux. The cookie sent with r is reused if it is valid, and a new
+// cookie is set on w otherwise; call it before writing the response headers.
+func (m *Middleware) IssueToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	cs := m.cs
+
+	realToken, err := cs.st.Get(r)
+	if errors.Is(err, ErrStoreUnavailable) {
+		return "", err
+	}
+	if err != nil || len(realToken) != tokenLength {
+		badCookie := isBadCookie(realToken, err)
+		if realToken, err = cs.generateToken(); err != nil {
+			return "", err
+		}
+		if err := cs.save(r, realToken, w); err != nil {
+			return "", fmt.Errorf("%w: %w", ErrCookieWrite, err)
+		}
+		cs.countIssue(r, badCookie)
+	} else {
+		cs.stats.issuance.reused.Add(1)
+	}
+
+	if _, err := cs.bindToken(r, realToken); err != nil {
+		return "", err
+	}
+
+	return cs.mask(realToken, r), nil
+}
@@ -0,0 +1,63 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestVerifyIssue tests that tokens issued by IssueToken outside of a wrapped
+// handler are verified by VerifyRequest, and by wrapped handlers.
+func TestVerifyIssue(t *testing.T) {
+	m, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	token, err := m.IssueToken(rr, httptest.NewRequest("GET", "/login", nil))
+	if err != nil || token == "" || rr.Header().Get("Set-Cookie") == "" {
+		t.Fatalf("token not issued: got %q, %v", token, err)
+	}
+
+	// The cookie is reused.
+	r := httptest.NewRequest("GET", "/login", nil)
+	setCookie(rr, r)
+	again := httptest.NewRecorder()
+	if _, err := m.IssueToken(again, r); err != nil || again.Header().Get("Set-Cookie") != "" {
+		t.Fatalf("cookie not reused: got %q, %v", again.Header().Get("Set-Cookie"), err)
+	}
+
+	post := func(token string) *http.Request {
+		r := httptest.NewRequest("POST", "/login", nil)
+		setCookie(rr, r)
+		if token != "" {
+			r.Header.Set("X-CSRF-Token", token)
+		}
+		return r
+	}
+
+	if err := m.VerifyRequest(post(token)); err != nil {
+		t.Fatalf("issued token rejected: got %v", err)
+	}
+	if err := m.VerifyRequest(post("")); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("missing token: got %v want %v", err, ErrNoToken)
+	}
+	other, _, _ := m.Mint()
+	if err := m.VerifyRequest(post(other)); !errors.Is(err, ErrBadToken) {
+		t.Fatalf("foreign token: got %v want %v", err, ErrBadToken)
+	}
+	if err := m.VerifyRequest(httptest.NewRequest("POST", "/login", nil)); err == nil {
+		t.Fatal("request without cookie accepted")
+	}
+	if err := m.VerifyRequest(httptest.NewRequest("GET", "/", nil)); err != nil {
+		t.Fatalf("safe request rejected: got %v", err)
+	}
+
+	wrapped := httptest.NewRecorder()
+	m.Wrap(testHandler).ServeHTTP(wrapped, post(token))
+	if wrapped.Code != http.StatusOK {
+		t.Fatalf("issued token rejected by wrapped handler: got %v", wrapped.Code)
+	}
+}
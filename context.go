@@ -9,7 +9,16 @@ import (
 	"net/http"
 )
 
-type contextKey string
+// contextKey is the type of the keys of the values the middleware stores in
+// the request context. Being an unexported struct type, its values cannot
+// collide with keys of other packages; use the accessors to read them.
+type contextKey struct {
+	name string
+}
+
+func (k contextKey) String() string {
+	return k.name
+}
 
 func contextGet(r *http.Request, key contextKey) (interface{}, error) {
 	val := r.Context().Value(key)
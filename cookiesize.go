@@ -0,0 +1,59 @@
+package csrf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxCookieSize is the largest cookie, including its name and attributes,
+// that browsers are required to accept (RFC 6265, section 6.1). Many CDNs and
+// proxies enforce the same limit.
+const maxCookieSize = 4096
+
+// ErrCookieTooLarge is returned by New if the CSRF cookie would exceed 4096
+// bytes with the configured options, e.g. because of a very long cookie name,
+// Domain or Path. Browsers silently drop such cookies, so that every unsafe
+// request would fail.
+var ErrCookieTooLarge = errors.New("CSRF cookie too large")
+
+// checkCookieSize encodes a sample token with the default cookie store, and
+// the one of PlaintextCookie, and returns ErrCookieTooLarge if the resulting
+// Set-Cookie header exceeds maxCookieSize. Custom stores are not checked.
+func (cs *csrf) checkCookieSize() error {
+	st := cs.st
+	if gs, ok := st.(*guardedStore); ok {
+		st = gs.st
+	}
+
+	if ss, ok := st.(*splitStore); ok {
+		if err := checkStoreCookieSize(ss.plain); err != nil {
+			return err
+		}
+		st = ss.tls
+	}
+
+	return checkStoreCookieSize(st)
+}
+
+// checkStoreCookieSize checks the cookie size of st, if it is a cookie store.
+func checkStoreCookieSize(st Store) error {
+	cstore, ok := st.(*cookieStore)
+	if !ok {
+		return nil
+	}
+
+	hw := &headerWriter{header: http.Header{}}
+	if err := cstore.Save(make([]byte, tokenLength), hw); err != nil {
+		return fmt.Errorf("%sencoding sample cookie: %w", errorPrefix, err)
+	}
+
+	for _, c := range hw.header["Set-Cookie"] {
+		if len(c) > maxCookieSize {
+			return fmt.Errorf("%s%w: %d bytes exceed the limit of %d bytes of most browsers",
+				errorPrefix, ErrCookieTooLarge, len(c), maxCookieSize)
+		}
+	}
+
+	return nil
+}
@@ -1,11 +1,16 @@
 package csrf
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"log"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"runtime/pprof"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/securecookie"
 )
@@ -13,19 +18,32 @@ import (
 // CSRF token length in bytes.
 const tokenLength = 32
 
-// Context/session keys & prefixes
+// Context/session keys
+var (
+	tokenKey     = contextKey{"gorilla.csrf.Token"}
+	formKey      = contextKey{"gorilla.csrf.Form"}
+	headerKey    = contextKey{"gorilla.csrf.Header"}
+	errorKey     = contextKey{"gorilla.csrf.Error"}
+	skipCheckKey = contextKey{"gorilla.csrf.Skip"}
+	protectedKey = contextKey{"gorilla.csrf.Protected"}
+	refreshKey   = contextKey{"gorilla.csrf.Refresh"}
+	outcomeKey   = contextKey{"gorilla.csrf.Outcome"}
+	deadlineKey  = contextKey{"gorilla.csrf.Deadline"}
+	varyKey      = contextKey{"gorilla.csrf.Vary"}
+	injectedKey  = contextKey{"gorilla.csrf.Injected"}
+	defectKey    = contextKey{"gorilla.csrf.Defect"}
+	syntheticKey = contextKey{"gorilla.csrf.Synthetic"}
+)
+
+// Prefixes
 const (
-	tokenKey            = contextKey("gorilla.csrf.Token")
-	formKey             = contextKey("gorilla.csrf.Form")
-	errorKey            = contextKey("gorilla.csrf.Error")
-	skipCheckKey        = contextKey("gorilla.csrf.Skip")
-	cookieName   string = "_gorilla_csrf"
-	errorPrefix  string = "gorilla/csrf: "
+	cookieName  string = "_gorilla_csrf"
+	errorPrefix string = "gorilla/csrf: "
 )
 
 var (
 	// The name value used in form fields.
-	fieldName = string(tokenKey)
+	fieldName = tokenKey.name
 	// defaultAge sets the default MaxAge for cookies.
 	defaultAge = 3600 * 12
 	// The default HTTP request header to inspect
@@ -45,11 +63,26 @@ var (
 	// ErrBadReferer is returned when the scheme & host in the URL do not match
 	// the supplied Referer header.
 	ErrBadReferer = errors.New("referer invalid")
+	// ErrBadOrigin is returned when a request checked by PolicyOrigin comes
+	// from an untrusted origin.
+	ErrBadOrigin = errors.New("origin invalid")
 	// ErrNoToken is returned if no CSRF token is supplied in the request.
 	ErrNoToken = errors.New("CSRF token not found in request")
 	// ErrBadToken is returned if the CSRF token in the request does not match
 	// the token in the session, or is otherwise malformed.
 	ErrBadToken = errors.New("CSRF token invalid")
+	// ErrTokenTooLong is reported by MalformedToken if the CSRF token in the
+	// request is longer than any valid token.
+	ErrTokenTooLong = errors.New("CSRF token too long")
+	// ErrTokenEncoding is reported by MalformedToken if the CSRF token in the
+	// request is not canonical base64.
+	ErrTokenEncoding = errors.New("CSRF token not valid base64")
+	// ErrTokenLength is reported by MalformedToken if the CSRF token in the
+	// request decodes to the wrong length.
+	ErrTokenLength = errors.New("CSRF token has the wrong length")
+	// ErrBodyTooLarge is returned if the request body exceeds the limit set
+	// with MaxBodySize while looking for the CSRF token in the form.
+	ErrBodyTooLarge = errors.New("request body too large")
 )
 
 // SameSiteMode allows a server to define a cookie attribute making it impossible for
@@ -75,27 +108,144 @@ const (
 type csrf struct {
 	h    http.Handler
 	sc   *securecookie.SecureCookie
-	st   store
+	st   Store
 	opts options
+	// excluded matches requests against opts.ExcludePatterns.
+	excluded *patternMatcher
+	// origins caches the decisions of the trusted origin callbacks.
+	origins *originCache
+	// trusted and denied are the compiled trusted and denied origins.
+	trusted []originPattern
+	denied  []originPattern
+	// originSources provide trusted origins that may change at runtime.
+	originSources []originSource
+	// stats counts decisions for the admin handler.
+	stats *adminStats
+	// failures counts failures per origin for OriginFailureAlert.
+	failures *shardedCache[failureWindow]
+	// reportPrefixes are the parsed opts.ReportOnlyFrom prefixes.
+	reportPrefixes []netip.Prefix
+	// external is the parsed opts.ExternalOrigin, if any.
+	external *url.URL
+	// reporter sends violation reports to opts.ReportURI, if set.
+	reporter *reporter
+	// warnedHosts are the hosts a cookie domain mismatch was logged for.
+	warnedHosts *hostSet
+	// grants are the nonces of the redeemed token grants, see AcceptGrants.
+	grants *shardedCache[bool]
+	// forms encrypts the forms saved with PreserveForm, if set.
+	forms *securecookie.SecureCookie
+	// keyLen is the length of the authentication key, for Describe.
+	keyLen int
+	// id identifies the instance in the request context, see saveValue.
+	id *instanceID
+	// nestedOnce ensures the double-wrapping warning is logged only once.
+	nestedOnce sync.Once
 }
 
 // options contains the optional settings for the CSRF middleware.
 type options struct {
-	MaxAge       int
-	Domain       string
-	Path         string
-	ExcludePaths []string
+	MaxAge          int
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	Domain          string
+	StrictDomain    bool
+	// PlaintextCookie overrides cookie attributes for plaintext requests.
+	PlaintextCookie *CookieProfile
+	CrossSubdomain  string
+	Path            string
+	PathFunc        func(*http.Request) string
+	ExcludePaths    []string
+	// ExcludePathsMode controls how ExcludePaths are matched.
+	ExcludePathsMode PathMatchMode
+	// Webhooks verify provider signatures on their paths instead of tokens.
+	Webhooks []webhook
+	// ExcludePatterns are http.ServeMux patterns excluded from protection.
+	ExcludePatterns []string
+	// ExcludeRoutes matches gorilla/mux routes excluded from protection.
+	ExcludeRoutes *routeMatcher
+	// ExcludeMatchers match requests excluded from protection.
+	ExcludeMatchers []PathMatcher
+	// OnlyUnder limits the middleware to the given path subtrees.
+	OnlyUnder []string
+	// OnlyHosts limits the middleware to the given hosts.
+	OnlyHosts []string
+	// OnlyMatchers limit the middleware to the requests they match.
+	OnlyMatchers []PathMatcher
 	// Note that the function and field names match the case of the associated
 	// http.Cookie field instead of the "correct" HTTPOnly name that golint suggests.
-	HttpOnly               bool
-	Secure                 bool
-	SameSite               SameSiteMode
-	RequestHeader          string
-	FieldName              string
-	ErrorHandler           http.Handler
-	CookieName             string
-	TrustedOrigins         []string
+	HttpOnly           bool
+	Secure             bool
+	SameSite           SameSiteMode
+	RequestHeader      string
+	CompanionHeader    string
+	FieldName          string
+	ErrorHandler       http.Handler
+	FailureHandlers    []FailureHandler
+	ErrorRenderers     map[string]ErrorRenderer
+	CookieName         string
+	TrustedOrigins     []string
+	ExternalOrigin     string
+	HostOnlyOrigins    bool
+	RefererMatch       RefererMatchMode
+	LegacyPaths        []string
+	LegacyReportOnly   bool
+	JSONPolicy         ContentPolicy
+	BadCookiePolicy    BadCookiePolicy
+	IssueFailurePolicy IssueFailurePolicy
+	RefreshOnFailure   bool
+	StreamRefresh      time.Duration
+	PreserveForm       time.Duration
+	VerifiedHeader     string
+	TokenMetrics       *TokenMetrics
+	ProfilerLabels     bool
+	LogMalformedTokens bool
+	DebugHeaders       bool
+	BindTLS            bool
+	LazyVaryCookie     bool
+	GrantField         string
+	GrantBackend       GrantBackend
+	GrantPaths         []string
+	DeniedOrigins      []string
+	// RefererPaths restrict the Referer paths allowed to submit to a path.
+	RefererPaths           []refererRule
 	TrustedOriginsCallback TrustedOriginsCallbackFunc
+	EnforcementCallback    EnforcementFunc
+	TrustedOriginsContext  TrustedOriginsContextFunc
+	TrustedOriginsFile     string
+	TrustedOriginsReload   time.Duration
+	TrustedOriginsURL      string
+	TrustedOriginsRefresh  time.Duration
+	TrustedOriginsMaxStale time.Duration
+	OriginCacheTTL         time.Duration
+	OriginCacheSize        int
+	ErrorLog               *log.Logger
+	DuplicateCookieDomains []string
+	StoreTimeout           time.Duration
+	StoreRetries           int
+	StoreBreakerThreshold  int
+	StoreBreakerCooldown   time.Duration
+	StoreFailurePolicy     FailurePolicy
+	VerifyDeadline         time.Duration
+	TokenBackend           TokenBackend
+	AuthHeader             string
+	AuthSchemes            []string
+	ClientCertSANs         []string
+	DeriveKey              bool
+	MaxBodySize            int64
+	MaxHeaderTokenSize     int
+	MaxFieldTokenSize      int
+	ReportOnly             bool
+	ReportOnlyFrom         []string
+	AlertThreshold         int
+	AlertWindow            time.Duration
+	AlertFunc              OriginAlertFunc
+	EventSink              EventSink
+	ReportURI              string
+	ReportSampleRate       float64
+	CookielessPolicy       CookielessPolicy
+	// InsecureSeed makes tokens deterministic. Test builds only.
+	InsecureSeed []byte
 }
 
 // Protect is HTTP middleware that provides Cross-Site Request Forgery
@@ -147,200 +297,645 @@ type options struct {
 //		// This is useful if you're sending JSON to clients or a front-end JavaScript
 //		// framework.
 //	}
+//
+// Protect logs an error (see ErrorLog) if the authentication key is not 32 or
+// 64 bytes long, or if the CSRF cookie would exceed the 4096 byte limit of
+// browsers; use New to get the error returned instead. Likewise, it forces
+// Secure cookies with SameSite(SameSiteNoneMode), whereas New returns
+// ErrInsecureSameSite.
+//
+// The returned handler also implements interface{ Describe() string }, which
+// returns a redacted dump of the configuration for startup logging.
 func Protect(authKey []byte, opts ...Option) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
-		cs := parseOptions(h, opts...)
+		return mustCSRF(authKey, h, opts...)
+	}
+}
+
+// mustCSRF is like newCSRF for Protect and NewServeMux, which cannot return
+// errors: it panics if an option is invalid, and logs the other problems New
+// returns as errors.
+func mustCSRF(authKey []byte, h http.Handler, opts ...Option) *csrf {
+	cs, err := newCSRF(authKey, h, opts...)
+	if err != nil {
+		panic(err)
+	}
+	if err := cs.checkCookieSize(); err != nil {
+		cs.warnf("%v", err)
+	}
+	cs.logWarnings()
+
+	return cs
+}
 
-		// Set the defaults if no options have been specified
-		if cs.opts.ErrorHandler == nil {
-			cs.opts.ErrorHandler = http.HandlerFunc(unauthorizedHandler)
+// newCSRF creates the middleware for h: it applies the options and defaults,
+// and sets up the codec, store and matchers. It returns an error if an option
+// is invalid; the problems Protect tolerates are logged.
+func newCSRF(authKey []byte, h http.Handler, opts ...Option) (*csrf, error) {
+	cs := parseOptions(h, opts...)
+	if err := cs.setup(authKey, false); err != nil {
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+// setup applies the defaults to the parsed options of cs, and sets up the
+// codec, store and matchers. If strict is set, as by New, it also returns an
+// error for the problems Protect only logs, e.g. a key of the wrong length.
+func (cs *csrf) setup(authKey []byte, strict bool) error {
+	key, err := checkKey(authKey, cs.opts.DeriveKey)
+	if err != nil {
+		if strict {
+			return err
 		}
+		// Keep going for backwards compatibility, but make sure the
+		// problem is visible. Use New to fail instead.
+		cs.warnf("%v", err)
+		key = authKey
+	}
+
+	cs.keyLen = len(authKey)
+	cs.stats = newAdminStats()
+	cs.id = new(instanceID)
+	cs.warnedHosts = new(hostSet)
+
+	// Set the defaults if no options have been specified
+	if cs.opts.ErrorHandler == nil {
+		cs.opts.ErrorHandler = errorHandler(mergeRenderers(cs.opts.ErrorRenderers))
+	}
+
+	if cs.opts.MaxAge < 0 {
+		// Default of 12 hours
+		cs.opts.MaxAge = defaultAge
+	}
+
+	if cs.opts.FieldName == "" {
+		cs.opts.FieldName = fieldName
+	}
 
-		if cs.opts.MaxAge < 0 {
-			// Default of 12 hours
-			cs.opts.MaxAge = defaultAge
+	if err := checkSameSite(cs.opts); err != nil {
+		if strict {
+			return err
 		}
+		// Browsers discard the cookie otherwise. Use New to fail instead.
+		cs.warnf("%v; forcing Secure", err)
+		cs.opts.Secure = true
+	}
 
-		if cs.opts.FieldName == "" {
-			cs.opts.FieldName = fieldName
+	if err := checkPlaintextSameSite(cs.opts); err != nil {
+		if strict {
+			return err
 		}
+		cs.warnf("%v; using SameSite=Lax", err)
+		p := *cs.opts.PlaintextCookie
+		p.SameSite = SameSiteLaxMode
+		cs.opts.PlaintextCookie = &p
+	}
+
+	if cs.opts.CookieName == "" {
+		cs.opts.CookieName = cookieName
+	}
+
+	if cs.opts.RequestHeader == "" {
+		cs.opts.RequestHeader = headerName
+	}
+
+	if cs.opts.MaxHeaderTokenSize <= 0 {
+		cs.opts.MaxHeaderTokenSize = encodedTokenLength
+	}
 
-		if cs.opts.CookieName == "" {
-			cs.opts.CookieName = cookieName
+	if cs.opts.MaxFieldTokenSize <= 0 {
+		cs.opts.MaxFieldTokenSize = encodedTokenLength
+	}
+
+	if strict {
+		if err := checkDomain(cs.opts); err != nil {
+			return err
 		}
+	}
 
-		if cs.opts.RequestHeader == "" {
-			cs.opts.RequestHeader = headerName
+	// Create an authenticated securecookie instance.
+	if cs.sc == nil {
+		cs.sc = securecookie.New(key, nil)
+		// Use JSON serialization (faster than one-off gob encoding)
+		cs.sc.SetSerializer(securecookie.JSONEncoder{})
+		// Set the MaxAge of the underlying securecookie.
+		cs.sc.MaxAge(cs.opts.MaxAge)
+	}
+
+	if cs.opts.PreserveForm > 0 {
+		cs.forms = newFormCodec(key, cs.opts.PreserveForm)
+	}
+
+	if cs.opts.GrantField != "" {
+		cs.grants = newShardedCache[bool](maxGrantTTL, 0)
+	}
+
+	if len(cs.opts.ExcludePatterns) > 0 {
+		pm, err := newPatternMatcher(cs.opts.ExcludePatterns)
+		if err != nil {
+			return err
 		}
+		cs.excluded = pm
+	}
 
-		// Create an authenticated securecookie instance.
-		if cs.sc == nil {
-			cs.sc = securecookie.New(authKey, nil)
-			// Use JSON serialization (faster than one-off gob encoding)
-			cs.sc.SetSerializer(securecookie.JSONEncoder{})
-			// Set the MaxAge of the underlying securecookie.
-			cs.sc.MaxAge(cs.opts.MaxAge)
+	if len(cs.opts.ReportOnlyFrom) > 0 {
+		prefixes, err := parsePrefixes(cs.opts.ReportOnlyFrom)
+		if err != nil {
+			return err
 		}
+		cs.reportPrefixes = prefixes
+	}
 
-		if cs.st == nil {
-			// Default to the cookieStore
-			cs.st = &cookieStore{
-				name:     cs.opts.CookieName,
-				maxAge:   cs.opts.MaxAge,
-				secure:   cs.opts.Secure,
-				httpOnly: cs.opts.HttpOnly,
-				sameSite: cs.opts.SameSite,
-				path:     cs.opts.Path,
-				domain:   cs.opts.Domain,
-				sc:       cs.sc,
+	external, err := parseExternalOrigin(cs.opts.ExternalOrigin)
+	if err != nil {
+		return err
+	}
+	cs.external = external
+
+	cs.trusted = compileOrigins(cs.opts.TrustedOrigins)
+	if parent := cs.opts.CrossSubdomain; parent != "" {
+		cs.trusted = append(cs.trusted, compileOrigins([]string{parent, "*." + parent})...)
+	}
+	cs.denied = compileOrigins(cs.opts.DeniedOrigins)
+
+	if cs.opts.AlertFunc != nil && cs.opts.AlertThreshold > 0 && cs.opts.AlertWindow > 0 {
+		cs.failures = newShardedCache[failureWindow](cs.opts.AlertWindow, maxAlertOrigins)
+	}
+
+	if cs.opts.ReportURI != "" {
+		cs.reporter = newReporter(cs.opts.ReportURI, cs.opts.ReportSampleRate, cs.warnf)
+	}
+
+	if cs.opts.OriginCacheTTL > 0 {
+		cs.origins = newOriginCache(cs.opts.OriginCacheTTL, cs.opts.OriginCacheSize)
+	}
+
+	if cs.opts.TrustedOriginsFile != "" {
+		fo, err := newFileOrigins(cs.opts.TrustedOriginsFile, cs.opts.TrustedOriginsReload, cs.warnf)
+		if err != nil {
+			cs.warnf("loading trusted origins: %v", err)
+		}
+		cs.originSources = append(cs.originSources, fo)
+	}
+
+	if cs.opts.TrustedOriginsURL != "" {
+		cs.originSources = append(cs.originSources, newRemoteOrigins(cs.opts.TrustedOriginsURL,
+			cs.opts.TrustedOriginsRefresh, cs.opts.TrustedOriginsMaxStale, cs.warnf))
+	}
+
+	if cs.st == nil {
+		// Default to the cookieStore
+		cookies := &cookieStore{
+			name:     cs.opts.CookieName,
+			maxAge:   cs.opts.MaxAge,
+			secure:   cs.opts.Secure,
+			httpOnly: cs.opts.HttpOnly,
+			sameSite: cs.opts.SameSite,
+			path:     cs.opts.Path,
+			pathFunc: cs.opts.PathFunc,
+			domain:   cs.opts.Domain,
+			sc:       cs.sc,
+
+			idleTimeout: cs.opts.IdleTimeout,
+			absTimeout:  cs.opts.AbsoluteTimeout,
+
+			duplicateDomains: cs.opts.DuplicateCookieDomains,
+		}
+
+		cs.st = cookies
+		if cs.opts.TokenBackend != nil {
+			cs.st = newOpaqueStore(cs.opts.TokenBackend, key, cookies)
+		}
+
+		if cs.opts.PlaintextCookie != nil {
+			plain := cs.plaintextCookies(cookies)
+			split := &splitStore{tls: cs.st, plain: plain}
+			if cs.opts.TokenBackend != nil {
+				split.plain = newOpaqueStore(cs.opts.TokenBackend, key, plain)
 			}
+			cs.st = split
 		}
+	}
 
-		return cs
+	// Guard the store if configured to, and always guard custom stores so
+	// that a slow backend call does not outlive the request context.
+	_, isCookieStore := cs.st.(*cookieStore)
+	if ss, ok := cs.st.(*splitStore); ok {
+		_, isCookieStore = ss.tls.(*cookieStore)
+	}
+	if !isCookieStore || cs.opts.StoreTimeout > 0 || cs.opts.StoreRetries > 0 || cs.opts.StoreBreakerThreshold > 0 {
+		cs.st = &guardedStore{
+			st:        cs.st,
+			timeout:   cs.opts.StoreTimeout,
+			retries:   cs.opts.StoreRetries,
+			threshold: cs.opts.StoreBreakerThreshold,
+			cooldown:  cs.opts.StoreBreakerCooldown,
+		}
+	}
+
+	return nil
+}
+
+// wrap returns a middleware for h that shares the configuration and state of
+// cs, i.e. the codec, store, matchers and caches.
+func (cs *csrf) wrap(h http.Handler) *csrf {
+	return &csrf{
+		h:              h,
+		sc:             cs.sc,
+		st:             cs.st,
+		opts:           cs.opts,
+		excluded:       cs.excluded,
+		trusted:        cs.trusted,
+		denied:         cs.denied,
+		origins:        cs.origins,
+		originSources:  cs.originSources,
+		reportPrefixes: cs.reportPrefixes,
+		external:       cs.external,
+		reporter:       cs.reporter,
+		warnedHosts:    cs.warnedHosts,
+		forms:          cs.forms,
+		grants:         cs.grants,
+		failures:       cs.failures,
+		stats:          cs.stats,
+		keyLen:         cs.keyLen,
+		id:             cs.id,
 	}
 }
 
 // Implements http.Handler for the csrf type.
 func (cs *csrf) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cs.stripVerified(r)
+
+	// Leave requests outside of the configured scope untouched.
+	if !cs.inScope(r) {
+		cs.skip(w, r, SkippedScope)
+		return
+	}
+
 	// Skip the check if directed to. This should always be a bool.
 	if val, err := contextGet(r, skipCheckKey); err == nil {
 		if skip, ok := val.(bool); ok {
 			if skip {
-				cs.h.ServeHTTP(w, r)
+				cs.skip(w, r, SkippedUnsafe)
 				return
 			}
 		}
 	}
 
-	// Skip the check if the path prefix is excluded.
-	for _, prefix := range cs.opts.ExcludePaths {
-		if strings.HasPrefix(r.URL.Path, prefix) {
-			cs.h.ServeHTTP(w, r)
+	// Accept tokens verified within the process, see WithToken.
+	if token, ok := injectedToken(r); ok {
+		cs.serveInjected(w, r, token)
+		return
+	}
+
+	// Verify the provider signature instead of a token on webhook paths.
+	if v := cs.webhookFor(r); v != nil && !contains(safeMethods, r.Method) {
+		if err := cs.verifyWebhook(w, r, v); err != nil {
+			cs.fail(w, r, err)
+			return
+		}
+		cs.skip(w, r, SkippedWebhook)
+		return
+	}
+
+	// Skip the check if the path is excluded.
+	for _, path := range cs.opts.ExcludePaths {
+		if matchPath(cs.opts.ExcludePathsMode, r.URL.Path, path) {
+			cs.skip(w, r, SkippedPath)
 			return
 		}
 	}
 
+	// Skip the check if the request matches an excluded pattern.
+	if cs.excluded != nil && cs.excluded.Match(r) {
+		cs.skip(w, r, SkippedPattern)
+		return
+	}
+
+	// Skip the check if the request is routed to an excluded mux route.
+	if cs.opts.ExcludeRoutes != nil && cs.opts.ExcludeRoutes.Match(r) {
+		cs.skip(w, r, SkippedRoute)
+		return
+	}
+
+	// Skip the check if the request matches an excluded matcher.
+	if matchAny(cs.opts.ExcludeMatchers, r) {
+		cs.skip(w, r, SkippedMatcher)
+		return
+	}
+
+	// Skip the check for requests authenticated by a header, e.g. a bearer
+	// token, or a client certificate, as these are immune to CSRF. The same
+	// applies to cookie-less clients, if configured.
+	switch {
+	case cs.skipAuthHeader(r):
+		cs.skip(w, r, SkippedAuthHeader)
+		return
+	case cs.skipClientCert(r):
+		cs.skip(w, r, SkippedClientCert)
+		return
+	case cs.skipCookieless(r):
+		cs.skip(w, r, SkippedCookieless)
+		return
+	}
+
+	// Warn if another instance of the middleware using the same cookie has
+	// already processed this request.
+	if name, err := contextGet(r, protectedKey); err == nil && name == cs.opts.CookieName {
+		cs.nestedOnce.Do(func() {
+			cs.logf("middleware applied more than once for cookie %q", cs.opts.CookieName)
+		})
+	}
+	r = contextSave(r, protectedKey, cs.opts.CookieName)
+	r = cs.prepareVary(w, r)
+
+	r, cancel := cs.withDeadline(r)
+	defer cancel()
+
 	// Retrieve the token from the session.
 	// An error represents either a cookie that failed HMAC validation
 	// or that doesn't exist.
-	realToken, err := cs.st.Get(r)
-	if err != nil || len(realToken) != tokenLength {
+	var (
+		realToken []byte
+		err       error
+	)
+	cs.profile(r, "decode", func(r *http.Request) {
+		realToken, err = cs.st.Get(boundedRequest(r))
+	})
+	storeErr := err
+	if err != nil && cs.deadlineExceeded(w, r) {
+		return
+	}
+	if errors.Is(err, ErrStoreUnavailable) {
+		cs.storeUnavailable(w, r, err)
+		return
+	}
+	badCookie := isBadCookie(realToken, err)
+	unsafe := !contains(safeMethods, r.Method)
+	if badCookie && unsafe && cs.opts.BadCookiePolicy == BadCookieReject {
+		cs.fail(w, r, ErrBadCookie)
+		return
+	}
+	// A valid token grant establishes a fresh token, e.g. on an SSO
+	// POST-back. It does not exempt the request from the check.
+	granted := cs.redeemGrant(w, r)
+	if granted || err != nil || len(realToken) != tokenLength {
 		// If there was an error retrieving the token, the token doesn't exist
 		// yet, or it's the wrong length, generate a new token.
 		// Note that the new token will (correctly) fail validation downstream
 		// as it will no longer match the request token.
-		realToken, err = generateRandomBytes(tokenLength)
+		realToken, err = cs.generateToken()
 		if err != nil {
-			r = envError(r, err)
-			cs.opts.ErrorHandler.ServeHTTP(w, r)
+			cs.fail(w, r, err)
 			return
 		}
 
 		// Save the new (real) token in the session store.
-		err = cs.st.Save(realToken, w)
+		err = cs.save(boundedRequest(r), realToken, w)
+		if err != nil && cs.deadlineExceeded(w, r) {
+			return
+		}
+		if errors.Is(err, ErrStoreUnavailable) {
+			cs.storeUnavailable(w, r, err)
+			return
+		}
 		if err != nil {
-			r = envError(r, err)
-			cs.opts.ErrorHandler.ServeHTTP(w, r)
+			cs.issueFailed(w, r, err)
 			return
 		}
+		cs.countIssue(r, badCookie)
+	} else {
+		cs.stats.issuance.reused.Add(1)
+
+		// Clean up stale duplicates of a valid session cookie.
+		if de, ok := cs.st.(duplicateExpirer); ok {
+			if err := de.ExpireDuplicates(r, w); err != nil {
+				cs.fail(w, r, err)
+				return
+			}
+		}
 	}
 
 	// Save the masked token to the request context
-	r = contextSave(r, tokenKey, mask(realToken, r))
+	r = cs.saveValue(r, tokenKey, cs.mask(realToken, r))
 	// Save the field name to the request context
-	r = contextSave(r, formKey, cs.opts.FieldName)
+	r = cs.saveValue(r, formKey, cs.opts.FieldName)
+	// Save the header name to the request context
+	r = cs.saveValue(r, headerKey, cs.opts.RequestHeader)
+	r = cs.loadForm(r)
 
 	// HTTP methods not defined as idempotent ("safe") under RFC7231 require
-	// inspection.
-	if !contains(safeMethods, r.Method) {
-		// Enforce an origin check for HTTPS connections. As per the Django CSRF
-		// implementation (https://goo.gl/vKA7GE) the Referer header is almost
-		// always present for same-domain HTTP requests.
-		if r.URL.Scheme == "https" {
-			// Fetch the Referer value. Call the error handler if it's empty or
-			// otherwise fails to parse.
-			referer, err := url.Parse(r.Referer())
-			if err != nil || referer.String() == "" {
-				r = envError(r, ErrNoReferer)
-				cs.opts.ErrorHandler.ServeHTTP(w, r)
-				return
-			}
+	// inspection. skipped is the reason a request is served unchecked.
+	var skipped string
+	if badCookie && unsafe {
+		switch cs.opts.BadCookiePolicy {
+		case BadCookieRotateReject:
+			cs.fail(w, r, ErrBadCookie)
+			return
+		case BadCookieReportOnly:
+			cs.logf("%s %s: %v", r.Method, r.URL.Path, ErrBadCookie)
+			skipped = SkippedBadCookieReport
+			unsafe = false
+		}
+	}
 
-			// Check exact match against the referer
-			valid := sameOrigin(r.URL, referer)
-
-			// Check exact match against trusted origins
-			if !valid {
-				for _, trustedOrigin := range cs.opts.TrustedOrigins {
-					if referer.Host == trustedOrigin {
-						valid = true
-						break
-					}
-				}
-			}
+	if !unsafe && skipped == "" {
+		skipped = SkippedSafeMethod
+	}
 
-			// Use a callback function to check the referer if the origin check
-			if !valid {
-				if cs.opts.TrustedOriginsCallback != nil {
-					valid = cs.opts.TrustedOriginsCallback(referer, r)
-				}
-			}
+	o := outcome(r)
+	if unsafe {
+		if o != nil {
+			o.Checked = true
+		}
 
-			if !valid {
-				r = envError(r, ErrBadReferer)
-				cs.opts.ErrorHandler.ServeHTTP(w, r)
-				return
+		var err error
+		if cs.opts.JSONPolicy == PolicyOrigin && isJSONRequest(r) {
+			err = cs.checkFetchOrigin(r)
+		} else {
+			// Verify the original request: the token check may parse the
+			// form, which the handler must see.
+			cs.profile(r, "verify", func(*http.Request) {
+				err = cs.verify(w, r, realToken)
+			})
+		}
+		if err != nil {
+			if !cs.deadlineExceeded(w, r) {
+				cs.fail(w, r, refineBadToken(err, storeErr))
 			}
+			return
+		}
+		cs.stats.passed.Add(1)
+		cs.clearForm(w, r)
+	}
+
+	if o != nil && skipped != "" {
+		o.Skipped = skipped
+	}
+	cs.annotateSkip(r, skipped)
+
+	// Set the Vary: Cookie header to protect clients from caching the response.
+	cs.vary(w, r)
+
+	// Call the wrapped handler/router on success, and write the cookie of a
+	// token it regenerated if it did not write a response.
+	rw, r := cs.wrapWriter(w, r, realToken)
+	cs.h.ServeHTTP(rw, r)
+	rw.commit()
+	rw.trailer()
+	// Clear the request context after the handler has completed.
+	contextClear(r)
+}
+
+// verify checks the Referer (for HTTPS requests) and the token of an unsafe
+// request against the real token.
+func (cs *csrf) verify(w http.ResponseWriter, r *http.Request, realToken []byte) error {
+	// Enforce an origin check for HTTPS connections. As per the Django CSRF
+	// implementation (https://goo.gl/vKA7GE) the Referer header is almost
+	// always present for same-domain HTTP requests.
+	if cs.requestURL(r).Scheme == "https" {
+		// Fetch the Referer value. Reject the request if it's empty or
+		// otherwise fails to parse.
+		referer, err := url.Parse(r.Referer())
+		if err != nil || referer.String() == "" {
+			return ErrNoReferer
 		}
 
-		// Retrieve the combined token (pad + masked) token...
-		maskedToken, err := cs.requestToken(r)
+		valid, err := cs.trustedOrigin(r, referer)
 		if err != nil {
-			r = envError(r, ErrBadToken)
-			cs.opts.ErrorHandler.ServeHTTP(w, r)
-			return
+			return err
 		}
 
-		if maskedToken == nil {
-			r = envError(r, ErrNoToken)
-			cs.opts.ErrorHandler.ServeHTTP(w, r)
-			return
+		if !valid {
+			return ErrBadReferer
 		}
+	}
 
-		// ... and unmask it.
-		requestToken := unmask(maskedToken)
+	if err := cs.checkRefererPath(r); err != nil {
+		return err
+	}
 
-		// Compare the request token against the real token
-		if !compareTokens(requestToken, realToken) {
-			r = envError(r, ErrBadToken)
-			cs.opts.ErrorHandler.ServeHTTP(w, r)
-			return
+	// Retrieve the combined token (pad + masked) token...
+	maskedToken, err := cs.requestToken(w, r)
+	if errors.Is(err, ErrBodyTooLarge) {
+		return ErrBodyTooLarge
+	}
+	if err != nil {
+		if cs.opts.LogMalformedTokens {
+			cs.logf("malformed token: %s %s: %v", r.Method, r.URL.Path, err)
 		}
+		return malformedToken{err}
+	}
 
+	if maskedToken == nil {
+		if cs.isLegacyPath(r) {
+			return cs.checkRequestedWith(r)
+		}
+		return ErrNoToken
 	}
 
-	// Set the Vary: Cookie header to protect clients from caching the response.
-	w.Header().Add("Vary", "Cookie")
+	// Bind the real token to the TLS connection, if configured.
+	realToken, err = cs.bindToken(r, realToken)
+	if err != nil {
+		return err
+	}
 
-	// Call the wrapped handler/router on success.
-	cs.h.ServeHTTP(w, r)
-	// Clear the request context after the handler has completed.
-	contextClear(r)
+	// ... and unmask it.
+	requestToken := unmask(maskedToken)
+
+	// Compare the request token against the real token
+	if !compareTokens(requestToken, realToken) {
+		return ErrBadToken
+	}
+
+	// Require the companion header to match as well, if configured.
+	if err := cs.checkCompanion(r, realToken); err != nil {
+		return err
+	}
+
+	cs.observeToken(r)
+
+	// Record the verified use of the token, e.g. to extend an idle
+	// timeout.
+	if t, ok := cs.st.(toucher); ok {
+		if err := t.Touch(r, w); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// unauthorizedhandler sets a HTTP 403 Forbidden status and writes the
-// CSRF failure reason to the response.
-func unauthorizedHandler(w http.ResponseWriter, r *http.Request) {
-	if isXHR(r) {
-		w.WriteHeader(http.StatusForbidden)
-		fmt.Fprintf(w, `{"code":%d,"message":%q}`, http.StatusForbidden, FailureReason(r))
+// fail handles a request that failed CSRF processing with err. The error is
+// stored in the request context and the error handler is called, unless the
+// middleware is in report-only mode: then the failure is logged and the
+// wrapped handler is called instead.
+func (cs *csrf) fail(w http.ResponseWriter, r *http.Request, err error) {
+	var malformed malformedToken
+	if errors.As(err, &malformed) {
+		r = cs.saveValue(r, defectKey, malformed.cause)
+		err = ErrBadToken
+	}
+	r = cs.saveValue(r, errorKey, err)
+	if o := outcome(r); o != nil {
+		o.Checked = true
+		o.Failure = err
+		o.Code = failureCode(r, err)
+	}
+	cs.countFailure(r)
+	e := cs.newEvent(r, err)
+	cs.stats.fail(e)
+	if cs.opts.EventSink != nil {
+		cs.opts.EventSink.Emit(e)
+	}
+	if cs.reporter != nil {
+		cs.reporter.report(e)
+	}
+	if cs.opts.DebugHeaders {
+		w.Header().Set(failureHeader, e.Code)
+	}
+
+	if cs.reportOnly(r) {
+		cs.logf("report-only: %s %s: %v", r.Method, r.URL.Path, err)
+		cs.annotate(r, "report:"+e.Code)
+		cs.vary(w, r)
+		cs.h.ServeHTTP(w, r)
 		return
 	}
-	http.Error(
-		w,
-		fmt.Sprintf("%s - %s", http.StatusText(http.StatusForbidden), FailureReason(r)),
-		http.StatusForbidden,
-	)
+
+	r = cs.saveForm(w, r, err)
+	r = cs.refreshToken(w, r)
+	cs.handleFailure(w, r, err)
+}
+
+// profile runs fn with the pprof label "gorilla.csrf" set to stage, if
+// enabled, so that CPU profiles attribute the time spent in fn to the CSRF
+// middleware. fn receives r with the labels added to its context.
+func (cs *csrf) profile(r *http.Request, stage string, fn func(r *http.Request)) {
+	if !cs.opts.ProfilerLabels {
+		fn(r)
+		return
+	}
+
+	pprof.Do(r.Context(), pprof.Labels("gorilla.csrf", stage), func(ctx context.Context) {
+		fn(r.WithContext(ctx))
+	})
+}
+
+// logf logs a message to the configured ErrorLog, if any.
+func (cs *csrf) logf(format string, args ...interface{}) {
+	if cs.opts.ErrorLog != nil {
+		cs.opts.ErrorLog.Printf(errorPrefix+format, args...)
+	}
+}
+
+// unauthorizedhandler sets a HTTP 403 Forbidden status and writes the
+// CSRF failure reason to the response, in the media type negotiated by the
+// default renderers (see RenderErrors).
+func unauthorizedHandler(w http.ResponseWriter, r *http.Request) {
+	errorHandler(defaultRenderers).ServeHTTP(w, r)
 }
 
 // isXHR returns true if r is an XHR request. It inspects the
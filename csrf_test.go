@@ -1,9 +1,13 @@
 package csrf
 
 import (
+	"bytes"
+	"html/template"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -219,6 +223,81 @@ func TestVaryHeader(t *testing.T) {
 	}
 }
 
+// With LazyVaryCookie, responses that neither set the cookie nor render the
+// token should not vary on it.
+func TestLazyVaryHeader(t *testing.T) {
+	static := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	page := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Token(r)
+		Token(r)
+	})
+
+	tests := []struct {
+		name string
+		h    http.Handler
+		opts []Option
+		want []string
+	}{
+		{"default", static, nil, []string{"Cookie"}},
+		{"static", static, []Option{LazyVaryCookie()}, nil},
+		{"token rendered", page, []Option{LazyVaryCookie()}, []string{"Cookie"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Protect(testKey, tt.opts...)(tt.h)
+
+			// The first response sets the cookie, and must vary on it.
+			rr := httptest.NewRecorder()
+			p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+			if got := rr.Header().Values("Vary"); !reflect.DeepEqual(got, []string{"Cookie"}) {
+				t.Fatalf("vary header not set with the cookie: got %q", got)
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			setCookie(rr, r)
+
+			rr = httptest.NewRecorder()
+			p.ServeHTTP(rr, r)
+			if got := rr.Header().Values("Vary"); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Responses that render the token through a template after the header is
+// written must vary on the cookie.
+func TestVaryHeaderTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("form").Funcs(TemplateFuncs()).Parse(
+		`<form method="post">{{ csrfField .R }}</form>`))
+	p := Protect(testKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		if err := tmpl.Execute(w, struct{ R *http.Request }{r}); err != nil {
+			t.Error(err)
+		}
+	}))
+
+	// The second request carries the cookie set by the first one.
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("GET", "/", nil)
+		if i > 0 {
+			setCookie(rr, r)
+		}
+
+		rr = httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+		if !strings.Contains(rr.Body.String(), fieldName) {
+			t.Fatalf("token not rendered: %s", rr.Body.String())
+		}
+		if got := rr.Result().Header.Values("Vary"); !reflect.DeepEqual(got, []string{"Cookie"}) {
+			t.Fatalf("response %d: got vary %q want %q", i, got, "Cookie")
+		}
+	}
+}
+
 // Requests with no Referer header should fail.
 func TestNoReferer(t *testing.T) {
 	s := http.NewServeMux()
@@ -285,20 +364,32 @@ func TestBadReferer(t *testing.T) {
 func TestTrustedReferer(t *testing.T) {
 	testTable := []struct {
 		trustedOrigin []string
+		referer       string
+		hostOnly      bool
 		shouldPass    bool
 	}{
-		{[]string{"golang.org"}, true},
-		{[]string{"api.example.com", "golang.org"}, true},
-		{[]string{"http://golang.org"}, false},
-		{[]string{"https://golang.org"}, false},
-		{[]string{"http://example.com"}, false},
-		{[]string{"example.com"}, false},
+		{[]string{"golang.org"}, "https://golang.org/", false, true},
+		{[]string{"api.example.com", "golang.org"}, "https://golang.org/", false, true},
+		{[]string{"https://golang.org"}, "https://golang.org/", false, true},
+		{[]string{"https://golang.org/"}, "https://golang.org/", false, true},
+		{[]string{"golang.org"}, "http://golang.org/", false, false},
+		{[]string{"https://golang.org"}, "http://golang.org/", false, false},
+		{[]string{"http://golang.org"}, "http://golang.org/", false, true},
+		{[]string{"golang.org:8443"}, "https://golang.org/", false, false},
+		{[]string{"http://example.com"}, "https://golang.org/", false, false},
+		{[]string{"example.com"}, "https://golang.org/", false, false},
+		// Legacy host-only matching.
+		{[]string{"golang.org"}, "http://golang.org/", true, true},
+		{[]string{"api.example.com", "golang.org"}, "http://golang.org/", true, true},
+		{[]string{"http://golang.org"}, "http://golang.org/", true, false},
+		{[]string{"https://golang.org"}, "http://golang.org/", true, false},
+		{[]string{"example.com"}, "http://golang.org/", true, false},
 	}
 
 	for _, item := range testTable {
 		s := http.NewServeMux()
 
-		p := Protect(testKey, TrustedOrigins(item.trustedOrigin))(s)
+		p := Protect(testKey, TrustedOrigins(item.trustedOrigin), HostOnlyOrigins(item.hostOnly))(s)
 
 		var token string
 		s.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -324,20 +415,20 @@ func TestTrustedReferer(t *testing.T) {
 		r.Header.Set("X-CSRF-Token", token)
 
 		// Set a non-matching Referer header.
-		r.Header.Set("Referer", "http://golang.org/")
+		r.Header.Set("Referer", item.referer)
 
 		rr = httptest.NewRecorder()
 		p.ServeHTTP(rr, r)
 
 		if item.shouldPass {
 			if rr.Code != http.StatusOK {
-				t.Fatalf("middleware failed to pass to the next handler: got %v want %v",
-					rr.Code, http.StatusOK)
+				t.Fatalf("%v, %s: middleware failed to pass to the next handler: got %v want %v",
+					item.trustedOrigin, item.referer, rr.Code, http.StatusOK)
 			}
 		} else {
 			if rr.Code != http.StatusForbidden {
-				t.Fatalf("middleware failed reject a non-matching Referer header: got %v want %v",
-					rr.Code, http.StatusForbidden)
+				t.Fatalf("%v, %s: middleware failed reject a non-matching Referer header: got %v want %v",
+					item.trustedOrigin, item.referer, rr.Code, http.StatusForbidden)
 			}
 		}
 	}
@@ -601,3 +692,48 @@ func TestRejectionWithExcludedPath(t *testing.T) {
 			rr.Code, http.StatusForbidden)
 	}
 }
+
+// TestNestedProtect checks that applying the middleware twice results in a
+// single, consistent CSRF cookie and a logged warning.
+func TestNestedProtect(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+
+	var token string
+	s := http.NewServeMux()
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	})
+	p := Protect(testKey)(Protect(testKey, ErrorLog(logger))(s))
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if n := len(rr.Header().Values("Set-Cookie")); n != 1 {
+		t.Fatalf("nested middleware set %d cookies: want 1", n)
+	}
+
+	if !strings.Contains(buf.String(), "more than once") {
+		t.Fatalf("nested middleware did not log a warning: got %q", buf.String())
+	}
+
+	r, err = http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setCookie(rr, r)
+	r.Header.Set("X-CSRF-Token", token)
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("nested middleware rejected a valid token: got %v want %v",
+			rr.Code, http.StatusOK)
+	}
+}
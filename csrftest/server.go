@@ -0,0 +1,126 @@
+// Package csrftest provides a test server for integration tests of handlers
+// protected by the CSRF middleware.
+//
+//	srv := csrftest.NewServer(api)
+//	defer srv.Close()
+//	resp, err := srv.Client().Post(srv.URL+"/items", "application/json", body)
+//
+// The client of the server keeps the CSRF cookie in a cookie jar and adds the
+// token to every unsafe request, as a browser front end would.
+package csrftest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+
+	"github.com/meplato/csrf"
+)
+
+// TokenPath is the path of the token endpoint of the test server, see
+// csrf.Middleware.TokenHandler. It is served before the handler under test.
+const TokenPath = "/.csrftest/token"
+
+// Server is an httptest.Server serving a handler protected by the CSRF
+// middleware.
+type Server struct {
+	*httptest.Server
+	// Middleware is the CSRF middleware protecting the handler.
+	Middleware *csrf.Middleware
+}
+
+// NewServer starts and returns a new Server serving h, protected by the CSRF
+// middleware with a random key and the given options. Cookies default to
+// Path "/", so that the cookie issued by TokenPath applies to all paths, and
+// are not Secure, as the server does not use TLS. NewServer panics if the
+// options are invalid. The caller should call Close when finished, to shut it
+// down.
+func NewServer(h http.Handler, opts ...csrf.Option) *Server {
+	key, err := csrf.GenerateKey()
+	if err != nil {
+		panic(fmt.Sprintf("csrftest: generating key: %v", err))
+	}
+
+	m, err := csrf.New(key, append([]csrf.Option{csrf.Path("/"), csrf.Secure(false)}, opts...)...)
+	if err != nil {
+		panic(fmt.Sprintf("csrftest: %v", err))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(TokenPath, m.TokenHandler())
+	mux.Handle("/", m.Wrap(h))
+
+	return &Server{Server: httptest.NewServer(mux), Middleware: m}
+}
+
+// Client returns a new HTTP client for the server with a cookie jar. Unsafe
+// requests made with it that carry no token in the request header get one
+// from TokenPath first, along with the CSRF cookie.
+func (s *Server) Client() *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		panic(fmt.Sprintf("csrftest: creating cookie jar: %v", err))
+	}
+
+	c := &http.Client{Jar: jar}
+	c.Transport = &tokenTransport{base: s.Server.Client().Transport, client: c, url: s.URL + TokenPath}
+
+	return c
+}
+
+// tokenTransport adds the CSRF token to unsafe requests.
+type tokenTransport struct {
+	base   http.RoundTripper
+	client *http.Client
+	url    string
+}
+
+func (t *tokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return t.base.RoundTrip(r)
+	}
+
+	token, header, err := t.token()
+	if err != nil {
+		return nil, err
+	}
+	if r.Header.Get(header) != "" {
+		return t.base.RoundTrip(r)
+	}
+
+	// The request was built before the token request set the cookie.
+	r = r.Clone(r.Context())
+	r.Header.Del("Cookie")
+	for _, c := range t.client.Jar.Cookies(r.URL) {
+		r.AddCookie(c)
+	}
+	r.Header.Set(header, token)
+
+	return t.base.RoundTrip(r)
+}
+
+// token fetches a token and the name of the request header to send it in.
+func (t *tokenTransport) token() (string, string, error) {
+	resp, err := t.client.Get(t.url)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("csrftest: fetching token: %s", resp.Status)
+	}
+
+	var body struct {
+		Token  string `json:"token"`
+		Header string `json:"header"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", "", fmt.Errorf("csrftest: decoding token: %v", err)
+	}
+
+	return body.Token, body.Header, nil
+}
@@ -0,0 +1,42 @@
+package csrftest
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/meplato/csrf"
+)
+
+// TestServer tests that the client of the server passes the CSRF check,
+// including with a custom request header, and that other clients do not.
+func TestServer(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Method)
+	})
+
+	for _, opts := range [][]csrf.Option{nil, {csrf.RequestHeader("X-Token")}} {
+		srv := NewServer(h, opts...)
+		defer srv.Close()
+
+		resp, err := srv.Client().Post(srv.URL+"/items", "text/plain", strings.NewReader("item"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "POST" {
+			t.Fatalf("client POST: got %v %q", resp.StatusCode, body)
+		}
+
+		resp, err = http.Post(srv.URL+"/items", "text/plain", strings.NewReader("item"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("plain POST: got %v want %v", resp.StatusCode, http.StatusForbidden)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrVerifyTimeout is returned if CSRF processing, i.e. store calls and
+// trusted origin callbacks, exceeds the VerifyDeadline.
+var ErrVerifyTimeout = errors.New("CSRF verification timed out")
+
+// withDeadline bounds the backend calls made while processing r by the
+// VerifyDeadline, if set. The deadline applies to verifyContext only, not to
+// the context of the request passed to the handler.
+func (cs *csrf) withDeadline(r *http.Request) (*http.Request, context.CancelFunc) {
+	if cs.opts.VerifyDeadline <= 0 {
+		return r, func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cs.opts.VerifyDeadline)
+	return contextSave(r, deadlineKey, ctx), cancel
+}
+
+// verifyContext returns the context for backend calls made while processing
+// r: the request context, bounded by the VerifyDeadline if set.
+func verifyContext(r *http.Request) context.Context {
+	if ctx, ok := r.Context().Value(deadlineKey).(context.Context); ok {
+		return ctx
+	}
+
+	return r.Context()
+}
+
+// boundedRequest returns r with its context bounded by the VerifyDeadline, for
+// store calls. It returns r itself if no deadline is set.
+func boundedRequest(r *http.Request) *http.Request {
+	if ctx, ok := r.Context().Value(deadlineKey).(context.Context); ok {
+		return r.WithContext(ctx)
+	}
+
+	return r
+}
+
+// deadlineExceeded handles r if its VerifyDeadline has passed, and reports
+// whether it did. Like an unavailable store, a timeout fails unsafe requests
+// unless StoreFailurePolicy is FailOpen; safe requests are served without a
+// token.
+func (cs *csrf) deadlineExceeded(w http.ResponseWriter, r *http.Request) bool {
+	if cs.opts.VerifyDeadline <= 0 || !errors.Is(verifyContext(r).Err(), context.DeadlineExceeded) {
+		return false
+	}
+
+	cs.stats.timeouts.Add(1)
+	cs.logf("%s %s: %v after %v", r.Method, r.URL.Path, ErrVerifyTimeout, cs.opts.VerifyDeadline)
+
+	if contains(safeMethods, r.Method) || cs.opts.StoreFailurePolicy == FailOpen {
+		cs.annotateSkip(r, skippedVerifyTimeout)
+		cs.h.ServeHTTP(w, r)
+		return true
+	}
+
+	cs.fail(w, r, ErrVerifyTimeout)
+	return true
+}
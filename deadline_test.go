@@ -0,0 +1,65 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestVerifyDeadline tests that slow store calls and origin callbacks are cut
+// off at the deadline and handled according to the failure policy, without
+// affecting the context of the handler.
+func TestVerifyDeadline(t *testing.T) {
+	slow := &flakyStore{delay: 200 * time.Millisecond, token: make([]byte, tokenLength)}
+
+	var reason error
+	var handlerErr error
+	errorHandler := ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason = FailureReason(r)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerErr = r.Context().Err()
+	})
+
+	m, err := New(testKey, TokenStore(slow), VerifyDeadline(20*time.Millisecond), errorHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	m.Wrap(handler).ServeHTTP(rr, httptest.NewRequest("POST", "/", nil))
+	if rr.Code != http.StatusForbidden || !errors.Is(reason, ErrVerifyTimeout) {
+		t.Fatalf("slow store: got %v, %v want %v", rr.Code, reason, ErrVerifyTimeout)
+	}
+	if !strings.Contains(m.cs.adminReport(), "Timeouts:                1\n") {
+		t.Fatalf("timeout not counted:\n%s", m.cs.adminReport())
+	}
+
+	rr = httptest.NewRecorder()
+	Protect(testKey, TokenStore(slow), VerifyDeadline(20*time.Millisecond), StoreFailurePolicy(FailOpen))(handler).
+		ServeHTTP(rr, httptest.NewRequest("POST", "/", nil))
+	if rr.Code != http.StatusOK || handlerErr != nil {
+		t.Fatalf("slow store with FailOpen: got %v, handler context %v", rr.Code, handlerErr)
+	}
+
+	// A slow callback is cut off, too.
+	reason = nil
+	p := Protect(testKey, VerifyDeadline(20*time.Millisecond), errorHandler,
+		TrustedOriginsContext(func(ctx context.Context, referer *url.URL, r *http.Request) (bool, error) {
+			<-ctx.Done()
+			return false, ctx.Err()
+		}))(handler)
+
+	r := httptest.NewRequest("POST", "https://example.com/", nil)
+	r.Header.Set("Referer", "https://other.example/")
+	p.ServeHTTP(httptest.NewRecorder(), r)
+	if !errors.Is(reason, ErrVerifyTimeout) {
+		t.Fatalf("slow callback: got %v want %v", reason, ErrVerifyTimeout)
+	}
+}
@@ -0,0 +1,191 @@
+package csrf
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Describe returns a human-readable dump of the middleware configuration for
+// startup logging and support bundles. Key material and token seeds are never
+// included. The handler returned by Protect implements
+// interface{ Describe() string }.
+func (cs *csrf) Describe() string {
+	var b strings.Builder
+
+	line := func(name string, value interface{}) {
+		fmt.Fprintf(&b, "%-24s %v\n", name+":", value)
+	}
+	set := func(v interface{}) string {
+		if v == nil || reflect.ValueOf(v).IsZero() {
+			return "unset"
+		}
+		return "set"
+	}
+
+	o := cs.opts
+	line("AuthKey", fmt.Sprintf("%d bytes (redacted)", cs.keyLen))
+	line("DeriveKey", o.DeriveKey)
+	line("CookieName", o.CookieName)
+	line("Domain", o.Domain)
+	line("CrossSubdomain", o.CrossSubdomain)
+	line("Path", o.Path)
+	line("PathFunc", set(o.PathFunc))
+	line("MaxAge", o.MaxAge)
+	line("Secure", o.Secure)
+	line("HttpOnly", o.HttpOnly)
+	line("SameSite", sameSiteNames[o.SameSite])
+	line("IdleTimeout", o.IdleTimeout)
+	line("AbsoluteTimeout", o.AbsoluteTimeout)
+	line("RequestHeader", o.RequestHeader)
+	line("CompanionHeader", o.CompanionHeader)
+	line("BindTLS", o.BindTLS)
+	line("LazyVaryCookie", o.LazyVaryCookie)
+	line("FieldName", o.FieldName)
+	line("MaxBodySize", o.MaxBodySize)
+	line("MaxTokenSize", fmt.Sprintf("header %d, field %d", o.MaxHeaderTokenSize, o.MaxFieldTokenSize))
+	line("ReportOnly", o.ReportOnly)
+	line("ReportOnlyFrom", o.ReportOnlyFrom)
+	line("LegacyRequestedWith", o.LegacyPaths)
+	line("LegacyReportOnly", o.LegacyReportOnly)
+	line("EnforcementCallback", set(o.EnforcementCallback))
+	line("RefreshOnFailure", o.RefreshOnFailure)
+	line("StreamTokenRefresh", o.StreamRefresh)
+	line("PreserveForm", o.PreserveForm)
+	line("VerifiedHeader", o.VerifiedHeader)
+	line("ErrorHandler", set(o.ErrorHandler))
+	if len(o.FailureHandlers) > 0 {
+		line("OnFailure", fmt.Sprintf("%d handlers", len(o.FailureHandlers)))
+	}
+	if len(o.ErrorRenderers) > 0 {
+		types := make([]string, 0, len(o.ErrorRenderers))
+		for mt := range o.ErrorRenderers {
+			types = append(types, mt)
+		}
+		sort.Strings(types)
+		line("RenderErrors", types)
+	}
+	line("ErrorLog", set(o.ErrorLog))
+	line("TokenMetrics", set(o.TokenMetrics))
+	line("AuditEvents", set(o.EventSink))
+	if o.ReportURI != "" {
+		line("ReportURI", fmt.Sprintf("%s (sample rate %g)", redactURL(o.ReportURI), o.ReportSampleRate))
+	}
+	if o.AlertFunc != nil {
+		line("OriginFailureAlert", fmt.Sprintf("%d failures in %v", o.AlertThreshold, o.AlertWindow))
+	}
+	line("ProfilerLabels", o.ProfilerLabels)
+	line("LogMalformedTokens", o.LogMalformedTokens)
+	line("DebugHeaders", o.DebugHeaders)
+	line("TrustedOrigins", o.TrustedOrigins)
+	line("DeniedOrigins", o.DeniedOrigins)
+	for _, rule := range o.RefererPaths {
+		line("RefererPaths", fmt.Sprintf("%s from %v", rule.path, rule.prefixes))
+	}
+	line("ExternalOrigin", o.ExternalOrigin)
+	line("HostOnlyOrigins", o.HostOnlyOrigins)
+	line("RefererMatch", refererMatchNames[o.RefererMatch])
+	line("AcceptGrants", o.GrantField)
+	line("GrantPaths", o.GrantPaths)
+	line("GrantBackend", set(o.GrantBackend))
+	line("JSONPolicy", contentPolicyNames[o.JSONPolicy])
+	line("TrustedOriginsCallback", set(o.TrustedOriginsCallback))
+	line("TrustedOriginsContext", set(o.TrustedOriginsContext))
+	if o.TrustedOriginsFile != "" {
+		line("TrustedOriginsFile", fmt.Sprintf("%s (reload %v)", o.TrustedOriginsFile, o.TrustedOriginsReload))
+	}
+	if o.TrustedOriginsURL != "" {
+		line("TrustedOriginsURL", fmt.Sprintf("%s (refresh %v, max stale %v)",
+			redactURL(o.TrustedOriginsURL), o.TrustedOriginsRefresh, o.TrustedOriginsMaxStale))
+	}
+	line("CacheTrustedOrigins", fmt.Sprintf("%v ttl, %d entries", o.OriginCacheTTL, o.OriginCacheSize))
+	line("ExcludePaths", o.ExcludePaths)
+	line("ExcludePathsMode", o.ExcludePathsMode)
+	for _, wh := range o.Webhooks {
+		line("Webhook", fmt.Sprintf("%s (%T)", wh.path, wh.verifier))
+	}
+	line("ExcludePatterns", o.ExcludePatterns)
+	if o.ExcludeRoutes != nil {
+		line("ExcludeRoutes", o.ExcludeRoutes.names)
+	}
+	line("ExcludeMatchers", matcherNames(o.ExcludeMatchers))
+	line("OnlyUnder", o.OnlyUnder)
+	line("OnlyHosts", o.OnlyHosts)
+	line("OnlyMatching", matcherNames(o.OnlyMatchers))
+	line("ExpireDuplicateCookies", o.DuplicateCookieDomains)
+	line("Store", fmt.Sprintf("%T", cs.st))
+	line("OpaqueTokens", set(o.TokenBackend))
+	line("StoreTimeout", o.StoreTimeout)
+	line("StoreRetries", o.StoreRetries)
+	line("StoreCircuitBreaker", fmt.Sprintf("%d failures, %v cooldown", o.StoreBreakerThreshold, o.StoreBreakerCooldown))
+	line("StoreFailurePolicy", failurePolicyNames[o.StoreFailurePolicy])
+	line("VerifyDeadline", o.VerifyDeadline)
+	line("SkipAuthHeader", strings.TrimSpace(o.AuthHeader+" "+strings.Join(o.AuthSchemes, ",")))
+	line("SkipClientCerts", o.ClientCertSANs)
+	line("Cookieless", cookielessNames[o.CookielessPolicy])
+	line("BadCookie", badCookieNames[o.BadCookiePolicy])
+	line("IssueFailure", issueFailureNames[o.IssueFailurePolicy])
+	line("StrictDomain", o.StrictDomain)
+	if p := o.PlaintextCookie; p != nil {
+		line("PlaintextCookie", fmt.Sprintf("name %q, SameSite %s, domain %q", p.Name, sameSiteNames[p.SameSite], p.Domain))
+	}
+	if o.InsecureSeed != nil {
+		line("INSECURE", "deterministic tokens enabled - CSRF protection is disabled")
+	}
+
+	return b.String()
+}
+
+var sameSiteNames = map[SameSiteMode]string{
+	0:                   "unset",
+	SameSiteDefaultMode: "Default",
+	SameSiteLaxMode:     "Lax",
+	SameSiteStrictMode:  "Strict",
+	SameSiteNoneMode:    "None",
+}
+
+var failurePolicyNames = map[FailurePolicy]string{
+	FailClosed: "FailClosed",
+	FailOpen:   "FailOpen",
+}
+
+var refererMatchNames = map[RefererMatchMode]string{
+	RefererExactOrigin: "ExactOrigin",
+	RefererSameSite:    "SameSite",
+}
+
+var cookielessNames = map[CookielessPolicy]string{
+	CookielessReject:             "Reject",
+	CookielessSkip:               "Skip",
+	CookielessSkipUncredentialed: "SkipUncredentialed",
+}
+
+var badCookieNames = map[BadCookiePolicy]string{
+	BadCookieRotate:       "Rotate",
+	BadCookieReject:       "Reject",
+	BadCookieRotateReject: "RotateReject",
+	BadCookieReportOnly:   "ReportOnly",
+}
+
+var issueFailureNames = map[IssueFailurePolicy]string{
+	IssueFailureReject: "Reject",
+	IssueFailureServe:  "Serve",
+	IssueFailureError:  "Error",
+}
+
+var contentPolicyNames = map[ContentPolicy]string{
+	PolicyToken:  "Token",
+	PolicyOrigin: "Origin",
+}
+
+// redactURL replaces the password in rawURL, if any, with "xxxxx".
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	return u.Redacted()
+}
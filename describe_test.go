@@ -0,0 +1,28 @@
+package csrf
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDescribe tests that the configuration dump includes the options but no
+// key material.
+func TestDescribe(t *testing.T) {
+	h := Protect(testKey, CookieName("_describe"), ExcludePaths("/hooks"))(nil)
+
+	d, ok := h.(interface{ Describe() string })
+	if !ok {
+		t.Fatal("handler does not implement Describe")
+	}
+
+	desc := d.Describe()
+	for _, want := range []string{"_describe", "/hooks", "SameSite:", "Lax", "32 bytes"} {
+		if !strings.Contains(desc, want) {
+			t.Errorf("description does not contain %q:\n%s", want, desc)
+		}
+	}
+
+	if strings.Contains(desc, string(testKey)) {
+		t.Fatalf("description leaks the key:\n%s", desc)
+	}
+}
@@ -0,0 +1,39 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/http"
+)
+
+// generateToken returns a new real token.
+func (cs *csrf) generateToken() ([]byte, error) {
+	if cs.opts.InsecureSeed != nil {
+		return deterministicBytes(cs.opts.InsecureSeed, "token"), nil
+	}
+
+	return generateRandomBytes(tokenLength)
+}
+
+// mask masks realToken for r, see the mask function. It returns an empty
+// token if the token cannot be bound to the connection of r, see BindTLS.
+func (cs *csrf) mask(realToken []byte, r *http.Request) string {
+	realToken, err := cs.bindToken(r, realToken)
+	if err != nil {
+		return ""
+	}
+
+	if cs.opts.InsecureSeed != nil {
+		return maskWith(deterministicBytes(cs.opts.InsecureSeed, "pad"), realToken)
+	}
+
+	return mask(realToken, r)
+}
+
+// deterministicBytes derives tokenLength bytes from seed for the given
+// purpose. It must only be used for InsecureDeterministicTokens.
+func deterministicBytes(seed []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)[:tokenLength]
+}
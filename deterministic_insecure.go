@@ -0,0 +1,24 @@
+//go:build csrf_insecure_deterministic_tokens
+// +build csrf_insecure_deterministic_tokens
+
+package csrf
+
+// InsecureDeterministicTokens makes every real and masked token a fixed
+// function of seed, so that browser-automation suites can pre-compute tokens
+// with InsecureDeterministicToken instead of scraping them from pages.
+//
+// This COMPLETELY DISABLES CSRF protection: anyone who knows the seed can forge
+// tokens, and the masked token no longer changes per request. It is only
+// available in builds with the csrf_insecure_deterministic_tokens build tag and
+// must never be used in production.
+func InsecureDeterministicTokens(seed []byte) Option {
+	return func(cs *csrf) {
+		cs.opts.InsecureSeed = seed
+	}
+}
+
+// InsecureDeterministicToken returns the masked token that the middleware
+// issues when configured with InsecureDeterministicTokens(seed).
+func InsecureDeterministicToken(seed []byte) string {
+	return maskWith(deterministicBytes(seed, "pad"), deterministicBytes(seed, "token"))
+}
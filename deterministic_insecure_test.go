@@ -0,0 +1,50 @@
+//go:build csrf_insecure_deterministic_tokens
+// +build csrf_insecure_deterministic_tokens
+
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestInsecureDeterministicTokens tests that a pre-computed token passes
+// validation against a cookie issued by the middleware.
+func TestInsecureDeterministicTokens(t *testing.T) {
+	seed := []byte("fixture-seed")
+
+	var token string
+	s := http.NewServeMux()
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	})
+	p := Protect(testKey, InsecureDeterministicTokens(seed))(s)
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	want := InsecureDeterministicToken(seed)
+	if token != want {
+		t.Fatalf("token not deterministic: got %q want %q", token, want)
+	}
+
+	r, err = http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setCookie(rr, r)
+	r.Header.Set("X-CSRF-Token", want)
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("pre-computed token rejected: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,81 @@
+package csrf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrDomainMismatch is returned with StrictDomain if the cookie Domain does
+// not cover the host of the request, so that the browser would reject the
+// cookie, e.g. Domain("example.com") on "staging.example.net".
+var ErrDomainMismatch = errors.New("cookie domain does not cover the request host")
+
+// maxWarnedHosts bounds the number of hosts a domain mismatch is logged for.
+const maxWarnedHosts = 100
+
+// StrictDomain refuses to issue cookies whose Domain does not cover the host
+// of the request, handling the request as set with IssueFailure. By default,
+// such a mismatch is only logged, once per host.
+func StrictDomain() Option {
+	return func(cs *csrf) {
+		cs.opts.StrictDomain = true
+	}
+}
+
+// domainCovers reports whether a cookie for domain is accepted by browsers on
+// host, i.e. host is domain or one of its subdomains.
+func domainCovers(domain, host string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	host = strings.ToLower(host)
+
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// checkCookieDomain checks that the cookie Domain covers the host of r. A
+// mismatch is an error with StrictDomain, and logged otherwise.
+func (cs *csrf) checkCookieDomain(r *http.Request) error {
+	domain := cs.cookieDomain(r)
+	if domain == "" || r.Host == "" {
+		return nil
+	}
+
+	host, _ := splitHost(r.Host)
+	if domainCovers(domain, host) {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: %q on %q", ErrDomainMismatch, domain, host)
+	if cs.opts.StrictDomain {
+		return err
+	}
+	if cs.warnedHosts.add(host) {
+		cs.warnf("%v, browsers reject the cookie", err)
+	}
+
+	return nil
+}
+
+// hostSet is a set of up to maxWarnedHosts hosts.
+type hostSet struct {
+	mu    sync.Mutex
+	hosts map[string]struct{}
+}
+
+// add adds host to the set, and reports whether it was added.
+func (hs *hostSet) add(host string) bool {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	if _, ok := hs.hosts[host]; ok || len(hs.hosts) >= maxWarnedHosts {
+		return false
+	}
+	if hs.hosts == nil {
+		hs.hosts = make(map[string]struct{})
+	}
+	hs.hosts[host] = struct{}{}
+
+	return true
+}
@@ -0,0 +1,49 @@
+package csrf
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCookieDomainMismatch tests that cookies for a domain not covering the
+// request host are logged once per host, and refused with StrictDomain.
+func TestCookieDomainMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	p := Protect(testKey, Domain("example.com"), ErrorLog(log.New(&buf, "", 0)))(testHandler)
+
+	for _, host := range []string{"www.example.com", "example.com:8080", "staging.example.net", "staging.example.net"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = host
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != http.StatusOK || rr.Header().Get("Set-Cookie") == "" {
+			t.Fatalf("%s: got %v, cookie %q", host, rr.Code, rr.Header().Get("Set-Cookie"))
+		}
+	}
+
+	if n := strings.Count(buf.String(), ErrDomainMismatch.Error()); n != 1 || !strings.Contains(buf.String(), "staging.example.net") {
+		t.Fatalf("expected one warning for staging.example.net, got %q", buf.String())
+	}
+
+	var reason error
+	p = Protect(testKey, Domain("example.com"), StrictDomain(), ErrorLog(log.New(&buf, "", 0)),
+		ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reason = FailureReason(r)
+			w.WriteHeader(http.StatusForbidden)
+		})))(testHandler)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "staging.example.net"
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusForbidden || !errors.Is(reason, ErrDomainMismatch) || rr.Header().Get("Set-Cookie") != "" {
+		t.Fatalf("strict: got %v, reason %v, cookie %q", rr.Code, reason, rr.Header().Get("Set-Cookie"))
+	}
+}
@@ -0,0 +1,200 @@
+package csrf
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxRewriteSize is the maximum size of HTML responses ReverseProxy injects
+// tokens into, and of request bodies it buffers. Larger responses are passed
+// on unmodified.
+const maxRewriteSize = 8 << 20
+
+// Context keys of proxied requests: the buffered body, and the URL of the
+// page the client requested, which form actions are resolved against.
+var (
+	edgeBodyKey = contextKey{"gorilla.csrf.EdgeBody"}
+	edgePageKey = contextKey{"gorilla.csrf.EdgePage"}
+)
+
+var (
+	// formTag matches the start tags of forms.
+	formTag = regexp.MustCompile(`(?i)<form\b[^>]*>`)
+	// postMethod matches the method attribute of a form that posts.
+	postMethod = regexp.MustCompile(`(?i)\bmethod\s*=\s*["']?post\b`)
+	// formAction matches the action attribute of a form, capturing its
+	// double-quoted, single-quoted or unquoted value.
+	formAction = regexp.MustCompile(`(?i)\saction\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	// headEnd matches the end tag of the document head.
+	headEnd = regexp.MustCompile(`(?i)</head\s*>`)
+)
+
+// ReverseProxy returns a handler that protects the backend at target, e.g. a
+// legacy application that cannot be changed, as an edge layer in front of it:
+// unsafe requests are verified before they are forwarded, and the token is
+// injected into the HTML pages of the backend, as a hidden field into each
+// form that posts to the origin of the page, and as meta tags (see MetaTags)
+// into the document head. Forms that post to other origins, e.g. a payment
+// provider, do not receive the token.
+//
+// The CSRF cookies and the request header are removed from forwarded requests,
+// and cookies of the backend with the same names are dropped from responses,
+// so the backend never sees or overrides the cookies managed by the edge.
+// Responses are requested without compression so they can be rewritten; pages
+// larger than 8 MiB are passed on unmodified. The bodies of unsafe requests
+// are buffered, as verifying a form token consumes them; clients of larger
+// bodies must send the token in the request header.
+func (m *Middleware) ReverseProxy(target *url.URL) http.Handler {
+	cs := m.cs
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		cs.edgeRequest(r)
+	}
+	proxy.ModifyResponse = cs.edgeResponse
+	h := m.Wrap(proxy)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := cs.originURL(r)
+		page.RawQuery = ""
+		r = contextSave(r, edgePageKey, page)
+
+		if r.Body == nil || r.Body == http.NoBody || contains(safeMethods, r.Method) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRewriteSize+1))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxRewriteSize {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.ServeHTTP(w, contextSave(r, edgeBodyKey, body))
+	})
+}
+
+// edgeCookieNames returns the names of the cookies managed by the middleware.
+func (cs *csrf) edgeCookieNames() []string {
+	names := cs.tokenCookieNames()
+	if cs.forms != nil {
+		names = append(names, cs.formCookieName())
+	}
+
+	return names
+}
+
+// edgeRequest removes the CSRF cookies and token header from a request
+// forwarded to the backend, restores its buffered body, and asks for an
+// uncompressed response.
+func (cs *csrf) edgeRequest(r *http.Request) {
+	if body, ok := r.Context().Value(edgeBodyKey).([]byte); ok {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+
+	names := cs.edgeCookieNames()
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if !contains(names, c.Name) {
+			r.AddCookie(c)
+		}
+	}
+
+	r.Header.Del(cs.opts.RequestHeader)
+	r.Header.Del("Accept-Encoding")
+}
+
+// edgeResponse drops backend cookies colliding with the CSRF cookies from a
+// backend response, and injects the token into HTML pages.
+func (cs *csrf) edgeResponse(resp *http.Response) error {
+	dropSetCookies(resp.Header, cs.edgeCookieNames())
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") ||
+		resp.Header.Get("Content-Encoding") != "" || resp.ContentLength > maxRewriteSize {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRewriteSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxRewriteSize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	page, _ := resp.Request.Context().Value(edgePageKey).(*url.URL)
+	if page == nil {
+		return nil
+	}
+
+	body = injectToken(body, resp.Request, page)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return nil
+}
+
+// injectToken adds the token of r to the forms that post to the origin of
+// the HTML page at pageURL, and to the head of the page.
+func injectToken(page []byte, r *http.Request, pageURL *url.URL) []byte {
+	field := []byte(TemplateFieldString(r))
+	if len(field) == 0 {
+		return page
+	}
+
+	page = formTag.ReplaceAllFunc(page, func(tag []byte) []byte {
+		if !postMethod.Match(tag) || !sameOriginAction(tag, pageURL) {
+			return tag
+		}
+		return append(append([]byte{}, tag...), field...)
+	})
+
+	if loc := headEnd.FindIndex(page); loc != nil {
+		meta := []byte(MetaTags(r) + "\n")
+		page = append(page[:loc[0]:loc[0]], append(meta, page[loc[0]:]...)...)
+	}
+
+	return page
+}
+
+// sameOriginAction reports whether the form start tag has no action, or one
+// that resolves to the origin of the page at pageURL.
+func sameOriginAction(tag []byte, pageURL *url.URL) bool {
+	m := formAction.FindSubmatch(tag)
+	if m == nil {
+		return true
+	}
+
+	action := string(m[1]) + string(m[2]) + string(m[3])
+	u, err := pageURL.Parse(strings.TrimSpace(html.UnescapeString(action)))
+	if err != nil {
+		return false
+	}
+
+	return sameOrigin(u, pageURL)
+}
@@ -0,0 +1,98 @@
+package csrf
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestReverseProxy tests that the edge injects tokens into backend pages,
+// hides its cookies from the backend, and forwards only verified unsafe
+// requests with their body intact.
+func TestReverseProxy(t *testing.T) {
+	var backendCookies []*http.Cookie
+	var backendBody string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendCookies = r.Cookies()
+		if r.Method == "POST" {
+			r.ParseForm()
+			backendBody = r.PostForm.Get("comment")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: cookieName, Value: "backend"})
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, `<html><head><title>Legacy</title></head><body>`+
+			`<form method="POST" action="/comments"><input name="comment"></form>`+
+			`<form action="/search"><input name="q"></form>`+
+			`<form method=post action='http://example.com/profile'></form>`+
+			`<form method="post" action="https://pay.example.net/checkout"></form>`+
+			`<form method="post" action="//evil.example/collect"></form></body></html>`)
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+	m, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edge := m.ReverseProxy(target)
+
+	rr := httptest.NewRecorder()
+	get := httptest.NewRequest("GET", "/", nil)
+	get.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+	edge.ServeHTTP(rr, get)
+
+	page := rr.Body.String()
+	// Only the forms posting to the origin of the page receive the token.
+	if n := strings.Count(page, `type="hidden"`); n != 2 {
+		t.Fatalf("got %d hidden fields in %s", n, page)
+	}
+	for _, action := range []string{"https://pay.example.net/checkout", "//evil.example/collect"} {
+		form := page[strings.Index(page, action):]
+		if strings.Contains(form[:strings.Index(form, "</form>")], "hidden") {
+			t.Fatalf("token injected into the form posting to %s: %s", action, page)
+		}
+	}
+	if !strings.Contains(page, `<meta name="csrf-token"`) {
+		t.Fatalf("no meta tags in %s", page)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == "backend" {
+		t.Fatalf("got cookies %v, want the CSRF cookie only", cookies)
+	}
+
+	token := regexp.MustCompile(`value="([^"]+)"`).FindStringSubmatch(page)[1]
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusForbidden},
+		{token, http.StatusOK},
+	} {
+		backendBody = ""
+		form := url.Values{"comment": {"hello"}, fieldName: {tc.token}}
+		r := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+
+		res := httptest.NewRecorder()
+		edge.ServeHTTP(res, r)
+		if res.Code != tc.want {
+			t.Fatalf("POST with token %q: got %v want %v", tc.token, res.Code, tc.want)
+		}
+		if tc.want == http.StatusOK && backendBody != "hello" {
+			t.Fatalf("backend got form value %q", backendBody)
+		}
+	}
+
+	if len(backendCookies) != 1 || backendCookies[0].Name != "session" {
+		t.Fatalf("backend got cookies %v, want the session cookie only", backendCookies)
+	}
+}
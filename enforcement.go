@@ -0,0 +1,54 @@
+package csrf
+
+import "net/http"
+
+// Enforcement is the decision of an EnforcementFunc on how validation failures
+// of a request are handled.
+type Enforcement int
+
+// Enforcement decisions
+const (
+	// EnforceDefault applies the configured options, e.g. ReportOnly and
+	// ReportOnlyFrom.
+	EnforceDefault Enforcement = iota
+	// EnforceReject rejects failed requests, even if the options would only
+	// report them.
+	EnforceReject
+	// EnforceReportOnly reports failed requests and serves them, as with
+	// ReportOnly.
+	EnforceReportOnly
+)
+
+// EnforcementFunc decides how validation failures of r are handled, e.g. by
+// the principal an authentication middleware stored in the request context.
+// It is only called for requests that fail validation, possibly more than
+// once, so it should be cheap.
+type EnforcementFunc func(r *http.Request) Enforcement
+
+// EnforcementCallback varies enforcement by request, e.g. to report failures
+// of internal admin users testing new flows while rejecting those of everyone
+// else:
+//
+//	csrf.EnforcementCallback(func(r *http.Request) csrf.Enforcement {
+//		if u, ok := auth.UserFrom(r.Context()); ok && u.Role == "admin" {
+//			return csrf.EnforceReportOnly
+//		}
+//		return csrf.EnforceDefault
+//	})
+//
+// The authentication middleware must run before the CSRF middleware, so that
+// the principal is in the request context.
+func EnforcementCallback(f EnforcementFunc) Option {
+	return func(cs *csrf) {
+		cs.opts.EnforcementCallback = f
+	}
+}
+
+// enforcement returns the decision of the EnforcementCallback for r, if set.
+func (cs *csrf) enforcement(r *http.Request) Enforcement {
+	if cs.opts.EnforcementCallback == nil {
+		return EnforceDefault
+	}
+
+	return cs.opts.EnforcementCallback(r)
+}
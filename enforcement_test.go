@@ -0,0 +1,47 @@
+package csrf
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestEnforcementCallback tests that failures are reported or rejected as
+// decided by the callback from the principal in the request context.
+func TestEnforcementCallback(t *testing.T) {
+	type roleKey struct{}
+	decide := func(r *http.Request) Enforcement {
+		switch r.Context().Value(roleKey{}) {
+		case "admin":
+			return EnforceReportOnly
+		case "auditor":
+			return EnforceReject
+		}
+		return EnforceDefault
+	}
+
+	for _, tc := range []struct {
+		role string
+		opts []Option
+		want int
+	}{
+		{"", nil, http.StatusForbidden},
+		{"admin", nil, http.StatusOK},
+		{"user", nil, http.StatusForbidden},
+		{"user", []Option{ReportOnly()}, http.StatusOK},
+		{"auditor", []Option{ReportOnly()}, http.StatusForbidden},
+	} {
+		opts := append([]Option{EnforcementCallback(decide)}, tc.opts...)
+		p := Protect(testKey, opts...)(testHandler)
+
+		r := httptest.NewRequest("POST", "/", nil)
+		r = r.WithContext(context.WithValue(r.Context(), roleKey{}, tc.role))
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != tc.want {
+			t.Errorf("role %q: got %v want %v", tc.role, rr.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package csrf
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// PathMatchMode controls how ExcludePaths entries are matched against the
+// request path. Modes are flags and can be combined, e.g.
+// PathMatchExact|PathMatchCaseInsensitive.
+type PathMatchMode int
+
+// Path match modes
+const (
+	// PathMatchPrefix matches paths that start with an excluded path. This is
+	// the default.
+	PathMatchPrefix PathMatchMode = 0
+	// PathMatchExact only matches paths that are equal to an excluded path.
+	PathMatchExact PathMatchMode = 1 << iota
+	// PathMatchCaseInsensitive ignores case when comparing paths, e.g. to
+	// align with a case-insensitive router configuration.
+	PathMatchCaseInsensitive
+	// PathMatchIgnoreTrailingSlash ignores a trailing slash on both the
+	// request path and the excluded path, so "/hook" and "/hook/" match.
+	PathMatchIgnoreTrailingSlash
+)
+
+// String returns the names of the flags set in m, e.g. "Exact|CaseInsensitive".
+func (m PathMatchMode) String() string {
+	if m == PathMatchPrefix {
+		return "Prefix"
+	}
+
+	var names []string
+	if m&PathMatchExact != 0 {
+		names = append(names, "Exact")
+	} else {
+		names = append(names, "Prefix")
+	}
+	if m&PathMatchCaseInsensitive != 0 {
+		names = append(names, "CaseInsensitive")
+	}
+	if m&PathMatchIgnoreTrailingSlash != 0 {
+		names = append(names, "IgnoreTrailingSlash")
+	}
+
+	return strings.Join(names, "|")
+}
+
+// matchPath reports whether path matches the excluded path according to mode.
+func matchPath(mode PathMatchMode, path, excluded string) bool {
+	if mode&PathMatchCaseInsensitive != 0 {
+		path = strings.ToLower(path)
+		excluded = strings.ToLower(excluded)
+	}
+
+	if mode&PathMatchExact != 0 {
+		if mode&PathMatchIgnoreTrailingSlash != 0 {
+			return trimTrailingSlash(path) == trimTrailingSlash(excluded)
+		}
+		return path == excluded
+	}
+
+	if mode&PathMatchIgnoreTrailingSlash != 0 && !strings.HasSuffix(path, "/") {
+		// Treat "/hook" as being under an excluded "/hook/" prefix.
+		path += "/"
+	}
+
+	return strings.HasPrefix(path, excluded)
+}
+
+// trimTrailingSlash removes a trailing slash from all paths but the root.
+func trimTrailingSlash(path string) string {
+	if len(path) > 1 {
+		return strings.TrimSuffix(path, "/")
+	}
+
+	return path
+}
+
+// PathMatcher matches requests for ExcludeMatchers and OnlyMatching, so that
+// exclusions and scopes can be expressed with any matcher, e.g. a radix tree
+// or the route table of a router, beyond the built-in matching options.
+type PathMatcher interface {
+	// Match reports whether r matches. It is called for every request and
+	// must be safe for concurrent use.
+	Match(r *http.Request) bool
+}
+
+// PathMatcherFunc is an adapter to use an ordinary function as a PathMatcher.
+type PathMatcherFunc func(r *http.Request) bool
+
+// Match returns f(r).
+func (f PathMatcherFunc) Match(r *http.Request) bool {
+	return f(r)
+}
+
+// matchAny reports whether r matches one of matchers.
+func matchAny(matchers []PathMatcher, r *http.Request) bool {
+	for _, m := range matchers {
+		if m.Match(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matcherNames returns the types of matchers, for Describe.
+func matcherNames(matchers []PathMatcher) []string {
+	names := make([]string, len(matchers))
+	for i, m := range matchers {
+		names[i] = fmt.Sprintf("%T", m)
+	}
+
+	return names
+}
+
+// excludeMarker is the handler registered for every exclusion pattern. It is
+// used to tell a pattern match apart from the redirect and "not found"
+// handlers that http.ServeMux hands out for requests it cannot route.
+type excludeMarker struct{}
+
+func (*excludeMarker) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// patternMatcher matches requests against a set of Go 1.22 http.ServeMux
+// patterns, e.g. "POST /api/webhooks/{id}".
+type patternMatcher struct {
+	mux    *http.ServeMux
+	marker *excludeMarker
+}
+
+// newPatternMatcher compiles the given patterns into a patternMatcher. It
+// returns an error if a pattern is invalid or conflicts with another pattern,
+// i.e. in the cases where http.ServeMux would panic on registration.
+func newPatternMatcher(patterns []string) (pm *patternMatcher, err error) {
+	pm = &patternMatcher{
+		mux:    http.NewServeMux(),
+		marker: &excludeMarker{},
+	}
+
+	for _, pattern := range patterns {
+		if err := pm.add(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return pm, nil
+}
+
+// add registers a single pattern, turning a registration panic into an error.
+func (pm *patternMatcher) add(pattern string) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%sinvalid exclude pattern %q: %v", errorPrefix, pattern, v)
+		}
+	}()
+
+	pm.mux.Handle(pattern, pm.marker)
+
+	return nil
+}
+
+// Match returns true if r matches one of the registered patterns, using the
+// same host, method and wildcard semantics as http.ServeMux.
+func (pm *patternMatcher) Match(r *http.Request) bool {
+	h, _ := pm.mux.Handler(r)
+	return h == http.Handler(pm.marker)
+}
+
+// routeMatcher matches requests against a set of gorilla/mux route names.
+type routeMatcher struct {
+	router *mux.Router
+	names  []string
+}
+
+// Match returns true if the route handling r has one of the excluded names.
+// The route is taken from mux.CurrentRoute when the middleware runs inside the
+// router (e.g. via Router.Use) and is otherwise resolved by matching r against
+// the router.
+func (rm *routeMatcher) Match(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil && rm.router != nil {
+		var match mux.RouteMatch
+		if rm.router.Match(r, &match) {
+			route = match.Route
+		}
+	}
+
+	if route == nil {
+		return false
+	}
+
+	return contains(rm.names, route.GetName())
+}
@@ -0,0 +1,159 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestExcludePatterns checks that unsafe requests matching a http.ServeMux
+// pattern skip CSRF validation, while all other requests are still rejected.
+func TestExcludePatterns(t *testing.T) {
+	s := http.NewServeMux()
+	s.HandleFunc("/", testHandler)
+	p := Protect(testKey, ExcludePatterns("POST /api/webhooks/{id}", "/health"))(s)
+
+	testTable := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"POST", "/api/webhooks/42", http.StatusOK},
+		{"PUT", "/api/webhooks/42", http.StatusForbidden},
+		{"POST", "/api/webhooks/42/extra", http.StatusForbidden},
+		{"POST", "/api/webhooks", http.StatusForbidden},
+		{"DELETE", "/health", http.StatusOK},
+		{"POST", "/included", http.StatusForbidden},
+	}
+
+	for _, item := range testTable {
+		r, err := http.NewRequest(item.method, "http://www.gorillatoolkit.org"+item.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("%s %s: got %v want %v", item.method, item.path, rr.Code, item.code)
+		}
+	}
+}
+
+// TestInvalidExcludePattern checks that an invalid pattern is reported.
+func TestInvalidExcludePattern(t *testing.T) {
+	if _, err := newPatternMatcher([]string{"/a/{id"}); err == nil {
+		t.Fatal("newPatternMatcher did not report an invalid pattern")
+	}
+
+	if _, err := newPatternMatcher([]string{"/a/{x}", "/a/{y}"}); err == nil {
+		t.Fatal("newPatternMatcher did not report conflicting patterns")
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	testTable := []struct {
+		mode     PathMatchMode
+		path     string
+		excluded string
+		match    bool
+	}{
+		{PathMatchPrefix, "/hook/a", "/hook", true},
+		{PathMatchPrefix, "/Hook/a", "/hook", false},
+		{PathMatchPrefix, "/hook", "/hook/", false},
+		{PathMatchExact, "/hook", "/hook", true},
+		{PathMatchExact, "/hook/a", "/hook", false},
+		{PathMatchExact, "/hook/", "/hook", false},
+		{PathMatchCaseInsensitive, "/HOOK/a", "/hook", true},
+		{PathMatchExact | PathMatchCaseInsensitive, "/Hook", "/hOOk", true},
+		{PathMatchIgnoreTrailingSlash, "/hook", "/hook/", true},
+		{PathMatchIgnoreTrailingSlash, "/hookers", "/hook/", false},
+		{PathMatchExact | PathMatchIgnoreTrailingSlash, "/hook/", "/hook", true},
+		{PathMatchExact | PathMatchIgnoreTrailingSlash, "/", "/", true},
+	}
+
+	for _, item := range testTable {
+		if got := matchPath(item.mode, item.path, item.excluded); got != item.match {
+			t.Errorf("matchPath(%v, %q, %q): got %v want %v",
+				item.mode, item.path, item.excluded, got, item.match)
+		}
+	}
+}
+
+// TestExcludeRoutes checks that requests routed to a named gorilla/mux route
+// skip CSRF validation, whether the middleware wraps the router or is
+// installed via Router.Use.
+func TestExcludeRoutes(t *testing.T) {
+	newRouter := func() *mux.Router {
+		router := mux.NewRouter()
+		router.HandleFunc("/hooks/{id}", testHandler).Methods("POST").Name("webhook")
+		router.HandleFunc("/submit", testHandler).Methods("POST").Name("submit")
+		return router
+	}
+
+	wrapped := newRouter()
+	inner := newRouter()
+	inner.Use(Protect(testKey, ExcludeRoutes(inner, "webhook")))
+
+	handlers := map[string]http.Handler{
+		"wrapped": Protect(testKey, ExcludeRoutes(wrapped, "webhook"))(wrapped),
+		"use":     inner,
+	}
+
+	for name, h := range handlers {
+		for path, code := range map[string]int{
+			"/hooks/1": http.StatusOK,
+			"/submit":  http.StatusForbidden,
+		} {
+			r, err := http.NewRequest("POST", path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+
+			if rr.Code != code {
+				t.Errorf("%s: POST %s: got %v want %v", name, path, rr.Code, code)
+			}
+		}
+	}
+}
+
+// TestExcludeMatchers checks that requests matching a PathMatcher skip CSRF
+// validation and report the reason in the outcome.
+func TestExcludeMatchers(t *testing.T) {
+	callback := PathMatcherFunc(func(r *http.Request) bool {
+		return strings.HasSuffix(r.URL.Path, "/callback")
+	})
+	p := Protect(testKey, ExcludeMatchers(callback))(testHandler)
+
+	testTable := []struct {
+		path    string
+		code    int
+		skipped string
+	}{
+		{"/oauth/callback", http.StatusOK, SkippedMatcher},
+		{"/oauth/start", http.StatusForbidden, ""},
+	}
+
+	for _, item := range testTable {
+		r, err := http.NewRequest("POST", item.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, o := WithOutcome(r)
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code || o.Skipped != item.skipped {
+			t.Errorf("POST %s: got %v skipped %q want %v skipped %q",
+				item.path, rr.Code, o.Skipped, item.code, item.skipped)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package csrf
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ExternalOrigin sets the canonical origin users reach the application at,
+// e.g. "https://www.example.com", as the target of Referer and Origin checks
+// instead of the scheme and Host of the request. Set it when a proxy rewrites
+// the Host header or the application listens on a nonstandard port, so that
+// same-origin requests would otherwise fail with ErrBadReferer.
+//
+// Referer checks apply if the external origin uses https, regardless of the
+// scheme the proxy connects with. New returns an error, and Protect panics, if
+// origin is not a http or https origin without path, query or fragment.
+func ExternalOrigin(origin string) Option {
+	return func(cs *csrf) {
+		cs.opts.ExternalOrigin = origin
+	}
+}
+
+// parseExternalOrigin parses the ExternalOrigin option. It returns nil for an
+// empty origin.
+func parseExternalOrigin(origin string) (*url.URL, error) {
+	if origin == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, fmt.Errorf("%sinvalid external origin %q: %v", errorPrefix, origin, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("%sinvalid external origin %q: must be http(s)://host[:port]", errorPrefix, origin)
+	}
+
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// requestURL returns the URL of r to compare origins against: r.URL, with the
+// scheme and host of the external origin if one is configured.
+func (cs *csrf) requestURL(r *http.Request) *url.URL {
+	if cs.external == nil {
+		return r.URL
+	}
+
+	u := *r.URL
+	u.Scheme = cs.external.Scheme
+	u.Host = cs.external.Host
+
+	return &u
+}
+
+// originURL returns the URL of r with the origin it was made to: that of
+// requestURL, with the scheme of the connection and the Host header where
+// they are not set, as on requests received by a server.
+func (cs *csrf) originURL(r *http.Request) *url.URL {
+	u := *cs.requestURL(r)
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+
+	return &u
+}
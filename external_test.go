@@ -0,0 +1,67 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestExternalOrigin tests that Referers are compared against the external
+// origin instead of the request URL.
+func TestExternalOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		url     string
+		referer string
+		code    int
+	}{
+		{"internal port", nil, "https://www.example.com:8443/", "https://www.example.com/form", http.StatusForbidden},
+		{"external", []Option{ExternalOrigin("https://www.example.com")}, "https://www.example.com:8443/", "https://www.example.com/form", http.StatusOK},
+		{"rewritten host", []Option{ExternalOrigin("https://www.example.com/")}, "http://app.internal:8080/", "https://www.example.com/form", http.StatusOK},
+		{"cross-origin", []Option{ExternalOrigin("https://www.example.com")}, "http://app.internal:8080/", "https://evil.example/form", http.StatusForbidden},
+		{"no referer", []Option{ExternalOrigin("https://www.example.com")}, "http://app.internal:8080/", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var token string
+			s := http.NewServeMux()
+			s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				token = Token(r)
+			})
+			p := Protect(testKey, tt.opts...)(s)
+
+			rr := httptest.NewRecorder()
+			p.ServeHTTP(rr, httptest.NewRequest("GET", tt.url, nil))
+
+			r := httptest.NewRequest("POST", tt.url, nil)
+			setCookie(rr, r)
+			r.Header.Set("X-CSRF-Token", token)
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+
+			rr = httptest.NewRecorder()
+			p.ServeHTTP(rr, r)
+
+			if rr.Code != tt.code {
+				t.Fatalf("got %v want %v (%v)", rr.Code, tt.code, FailureReason(r))
+			}
+		})
+	}
+}
+
+// TestNewExternalOrigin tests that New rejects external origins that are not
+// a bare http(s) origin.
+func TestNewExternalOrigin(t *testing.T) {
+	for _, origin := range []string{"www.example.com", "ftp://example.com", "https://example.com/app", "https://example.com?x=1", "https://"} {
+		if _, err := New(testKey, ExternalOrigin(origin)); err == nil {
+			t.Errorf("%q: expected an error", origin)
+		}
+	}
+
+	if _, err := New(testKey, ExternalOrigin("https://www.example.com:8443")); err != nil {
+		t.Fatal(err)
+	}
+}
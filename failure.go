@@ -0,0 +1,35 @@
+package csrf
+
+import (
+	"net/http"
+	"strings"
+)
+
+// FailureHandler handles a request rejected with err, see OnFailure. It
+// returns false, without writing a response, to fall through to the next
+// handler, and eventually to the ErrorHandler.
+type FailureHandler func(w http.ResponseWriter, r *http.Request, err error) bool
+
+// PathFailureHandler returns a FailureHandler that serves rejected requests
+// whose path starts with prefix with h, and falls through for others.
+func PathFailureHandler(prefix string, h http.Handler) FailureHandler {
+	return func(w http.ResponseWriter, r *http.Request, err error) bool {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			return false
+		}
+		h.ServeHTTP(w, r)
+		return true
+	}
+}
+
+// handleFailure serves a rejected request with the first failure handler that
+// accepts it, or the ErrorHandler.
+func (cs *csrf) handleFailure(w http.ResponseWriter, r *http.Request, err error) {
+	for _, h := range cs.opts.FailureHandlers {
+		if h(w, r, err) {
+			return
+		}
+	}
+
+	cs.opts.ErrorHandler.ServeHTTP(w, r)
+}
@@ -0,0 +1,59 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestOnFailure tests that failure handlers are evaluated in order with the
+// failure reason, and fall through to the ErrorHandler.
+func TestOnFailure(t *testing.T) {
+	var reasons []error
+	record := func(name string, handle bool) FailureHandler {
+		return func(w http.ResponseWriter, r *http.Request, err error) bool {
+			reasons = append(reasons, err)
+			if !handle {
+				return false
+			}
+			w.Header().Set("X-Handler", name)
+			w.WriteHeader(http.StatusTeapot)
+			return true
+		}
+	}
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "api")
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	p := Protect(testKey,
+		OnFailure(record("first", false), PathFailureHandler("/api/", api)),
+		OnFailure(func(w http.ResponseWriter, r *http.Request, err error) bool {
+			return r.URL.Path == "/form" && record("form", true)(w, r, err)
+		}),
+	)(testHandler)
+
+	tests := []struct {
+		path    string
+		code    int
+		handler string
+	}{
+		{"/api/orders", http.StatusUnauthorized, "api"},
+		{"/form", http.StatusTeapot, "form"},
+		{"/other", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		reasons = nil
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest("POST", tt.path, nil))
+
+		if rr.Code != tt.code || rr.Header().Get("X-Handler") != tt.handler {
+			t.Errorf("%s: got %v, %q want %v, %q", tt.path, rr.Code, rr.Header().Get("X-Handler"), tt.code, tt.handler)
+		}
+		if len(reasons) == 0 || !errors.Is(reasons[0], ErrNoToken) {
+			t.Errorf("%s: reason not passed: got %v", tt.path, reasons)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package csrf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// fuzzCookieStore returns a cookie store encoding cookies as the middleware
+// does, with timeouts if timed is set.
+func fuzzCookieStore(timed bool) *cookieStore {
+	sc := securecookie.New(testKey, nil)
+	sc.SetSerializer(securecookie.JSONEncoder{})
+	sc.MaxAge(defaultAge)
+
+	st := &cookieStore{name: cookieName, maxAge: defaultAge, sc: sc}
+	if timed {
+		st.idleTimeout = time.Hour
+	}
+
+	return st
+}
+
+// FuzzTokenRoundTrip tests that a real token masked with any pad decodes and
+// unmasks to the real token, and that any issued token that decodes is the
+// canonical encoding of its pad and masked token.
+func FuzzTokenRoundTrip(f *testing.F) {
+	f.Add(make([]byte, tokenLength), make([]byte, tokenLength), "")
+	f.Add(bytes.Repeat([]byte{0xff}, tokenLength), []byte("real"), strings.Repeat("A", encodedTokenLength))
+	f.Add([]byte("pad"), bytes.Repeat([]byte{0x5a}, tokenLength), strings.Repeat("A", encodedTokenLength-1)+"=")
+	f.Add([]byte{}, []byte{}, "not\na+token")
+
+	f.Fuzz(func(t *testing.T, pad, real []byte, issued string) {
+		// Pad and real token are always tokenLength bytes in the middleware.
+		otp := make([]byte, tokenLength)
+		copy(otp, pad)
+		realToken := make([]byte, tokenLength)
+		copy(realToken, real)
+
+		masked := maskWith(otp, realToken)
+		decoded, err := decodeToken(masked, encodedTokenLength)
+		if err != nil {
+			t.Fatalf("decodeToken(%q): %v", masked, err)
+		}
+		if got := unmask(decoded); !bytes.Equal(got, realToken) {
+			t.Fatalf("unmask(maskWith(%x, %x)) = %x", otp, realToken, got)
+		}
+
+		decoded, err = decodeToken(issued, encodedTokenLength)
+		if err != nil {
+			return
+		}
+		if len(decoded) != tokenLength*2 {
+			t.Fatalf("decodeToken(%q): got %d bytes", issued, len(decoded))
+		}
+		if got := maskWith(decoded[:tokenLength], unmask(decoded)); got != issued {
+			t.Fatalf("re-masking %q: got %q", issued, got)
+		}
+	})
+}
+
+// FuzzCookieDecode tests that decoding any cookie value does not panic, that
+// a value that decodes re-encodes to the same token, and that a token of any
+// content survives an encode and decode.
+func FuzzCookieDecode(f *testing.F) {
+	for _, timed := range []bool{false, true} {
+		value, err := fuzzCookieStore(timed).encode(make([]byte, tokenLength))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(value, []byte("token"), timed)
+	}
+	f.Add("", []byte{}, false)
+	f.Add("notacookie", bytes.Repeat([]byte{0}, tokenLength), true)
+	f.Add("MTIzNDU2Nzg5MHxhYmN8ZGVm", []byte("\xff\xfe"), false)
+
+	f.Fuzz(func(t *testing.T, value string, token []byte, timed bool) {
+		st := fuzzCookieStore(timed)
+
+		if decoded, err := st.decode(value); err == nil {
+			encoded, err := st.encode(decoded)
+			if err != nil {
+				t.Fatalf("encode(%x): %v", decoded, err)
+			}
+			if again, err := st.decode(encoded); err != nil || !bytes.Equal(again, decoded) {
+				t.Fatalf("decode(encode(%x)) = %x, %v", decoded, again, err)
+			}
+		}
+
+		encoded, err := st.encode(token)
+		if err != nil {
+			t.Fatalf("encode(%x): %v", token, err)
+		}
+		decoded, err := st.decode(encoded)
+		if err != nil {
+			t.Fatalf("decode(%q): %v", encoded, err)
+		}
+		if !bytes.Equal(decoded, token) && !(len(decoded) == 0 && len(token) == 0) {
+			t.Fatalf("decode(encode(%x)) = %x", token, decoded)
+		}
+	})
+}
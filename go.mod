@@ -1,8 +1,12 @@
 module github.com/meplato/csrf
 
-go 1.19
+go 1.22
 
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/securecookie v1.1.1
+	github.com/gorilla/sessions v1.2.1
+	golang.org/x/net v0.30.0
 )
+
+require golang.org/x/text v0.19.0 // indirect
@@ -0,0 +1,121 @@
+package csrf
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// grantName is the securecookie name token grants are encoded with, so that a
+// grant cannot be passed off as a cookie value and vice versa.
+const grantName = "gorilla.csrf.grant"
+
+// maxGrantTTL is the longest lifetime of a token grant. Redeemed grants are
+// remembered for as long, so that each one is accepted once.
+const maxGrantTTL = 10 * time.Minute
+
+// grantNonceLength is the length of the random grant nonces in bytes.
+const grantNonceLength = 16
+
+// GrantBackend records the redeemed token grants of AcceptGrants for all
+// instances that accept grants. Implementations must be safe for concurrent
+// use.
+type GrantBackend interface {
+	// Claim records id until ttl elapses, and reports whether it was not
+	// recorded yet. Checking and recording must be a single atomic
+	// operation across all instances, e.g. Redis SET with NX and PX, or an
+	// INSERT into a table keyed by id, so that only one claim of an id
+	// succeeds.
+	Claim(ctx context.Context, id string, ttl time.Duration) (bool, error)
+}
+
+// tokenGrant is a signed, short-lived, single-use permission to establish a
+// CSRF cookie for a single host and path.
+type tokenGrant struct {
+	Audience string `json:"a"`
+	Path     string `json:"p"`
+	Nonce    string `json:"n"`
+	Expires  int64  `json:"e"`
+}
+
+// Grant returns a signed token grant that lets the host audience (e.g.
+// "app.example.com") establish its own CSRF cookie on a request to path (e.g.
+// "/sso/done") within ttl, at most 10 minutes, e.g. on the return from an SSO
+// redirect, which arrives cross-site and without a CSRF cookie.
+//
+// The grant is typically passed through the identity provider, e.g. in the
+// SAML RelayState or OIDC state parameter. The peer must be configured with
+// the same authentication key and AcceptGrants. Each grant is accepted once.
+//
+// Grants are redeemed on unsafe requests to the paths given to AcceptGrants,
+// e.g. a SAML POST-back. Redeeming a grant does not exempt the request from
+// the CSRF check: it only issues a fresh CSRF cookie, so that the requests
+// that follow carry a valid token.
+func (m *Middleware) Grant(audience, path string, ttl time.Duration) (string, error) {
+	if audience == "" || path == "" || ttl <= 0 || ttl > maxGrantTTL {
+		return "", errors.New(errorPrefix + "grant requires an audience, a path and a ttl of up to 10 minutes")
+	}
+
+	nonce, err := generateRandomBytes(grantNonceLength)
+	if err != nil {
+		return "", err
+	}
+
+	return m.cs.sc.Encode(grantName, tokenGrant{
+		Audience: normalizeHost("", audience),
+		Path:     path,
+		Nonce:    base64.RawURLEncoding.EncodeToString(nonce),
+		Expires:  timeNow().Add(ttl).Unix(),
+	})
+}
+
+// redeemGrant reports whether r carries a valid, unused token grant for its
+// host and path in the form field configured with AcceptGrants, and marks the
+// grant as used. Only unsafe requests to the configured paths are parsed.
+func (cs *csrf) redeemGrant(w http.ResponseWriter, r *http.Request) bool {
+	if cs.opts.GrantField == "" || contains(safeMethods, r.Method) ||
+		!contains(cs.opts.GrantPaths, r.URL.Path) {
+		return false
+	}
+
+	if err := cs.parseForm(w, r); err != nil {
+		return false
+	}
+
+	value := r.PostFormValue(cs.opts.GrantField)
+	if value == "" {
+		return false
+	}
+
+	var grant tokenGrant
+	if err := cs.sc.Decode(grantName, value, &grant); err != nil {
+		return false
+	}
+
+	now := timeNow()
+	if grant.Audience != normalizeHost("", requestHost(r)) || grant.Path != r.URL.Path ||
+		grant.Nonce == "" || now.Unix() >= grant.Expires ||
+		time.Unix(grant.Expires, 0).After(now.Add(maxGrantTTL)) {
+		return false
+	}
+
+	return cs.claimGrant(r, grant.Nonce)
+}
+
+// claimGrant records the nonce of a redeemed grant and reports whether it was
+// not used before, on this instance or, with a backend, on any instance.
+func (cs *csrf) claimGrant(r *http.Request, nonce string) bool {
+	claimed := false
+	cs.grants.update(nonce, func(_ bool, found bool) bool {
+		claimed = !found
+		return true
+	})
+	if !claimed || cs.opts.GrantBackend == nil {
+		return claimed
+	}
+
+	claimed, err := cs.opts.GrantBackend.Claim(r.Context(), "grant:"+nonce, maxGrantTTL)
+	return err == nil && claimed
+}
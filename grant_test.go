@@ -0,0 +1,215 @@
+package csrf
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryGrants is a GrantBackend for tests.
+type memoryGrants struct {
+	mu      sync.Mutex
+	claimed map[string]bool
+}
+
+func (mg *memoryGrants) Claim(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	if mg.claimed[id] {
+		return false, nil
+	}
+	mg.claimed[id] = true
+	return true, nil
+}
+
+// TestGrant tests that a token grant establishes a fresh CSRF cookie once, on
+// unsafe requests to the grant paths for its audience and path only, without
+// exempting the request from the check.
+func TestGrant(t *testing.T) {
+	start := time.Now()
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	m, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newGrant := func(audience, path string) string {
+		grant, err := m.Grant(audience, path, 5*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return grant
+	}
+	replayed := newGrant("b.example.com", "/sso/done")
+
+	backend := &memoryGrants{claimed: map[string]bool{}}
+	p := Protect(testKey, AcceptGrants("RelayState", backend, "/sso/done"))(testHandler)
+
+	// A request with a valid cookie is only issued a new one for a grant.
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "https://b.example.com/", nil))
+	cookies := rr.Result().Cookies()
+
+	testTable := []struct {
+		name    string
+		method  string
+		grant   string
+		path    string
+		elapsed time.Duration
+		issued  bool
+	}{
+		{"valid", "POST", replayed, "/sso/done", time.Minute, true},
+		{"replayed", "POST", replayed, "/sso/done", time.Minute, false},
+		{"missing", "POST", "", "/sso/done", time.Minute, false},
+		{"safe", "GET", newGrant("b.example.com", "/sso/done"), "/sso/done", time.Minute, false},
+		{"audience", "POST", newGrant("c.example.com", "/sso/done"), "/sso/done", time.Minute, false},
+		{"path", "POST", newGrant("b.example.com", "/sso/done"), "/account", time.Minute, false},
+		{"other path", "POST", newGrant("b.example.com", "/account"), "/account", time.Minute, false},
+		{"expired", "POST", newGrant("b.example.com", "/sso/done"), "/sso/done", 10 * time.Minute, false},
+		{"tampered", "POST", replayed[:len(replayed)-2], "/sso/done", time.Minute, false},
+	}
+
+	for _, item := range testTable {
+		timeNow = func() time.Time { return start.Add(item.elapsed) }
+
+		r := grantRequest(item.method, "https://b.example.com"+item.path, item.grant)
+		r.Header.Set("Referer", "https://idp.example.org/")
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		want := http.StatusForbidden
+		if item.method == "GET" {
+			want = http.StatusOK
+		}
+		if rr.Code != want {
+			t.Errorf("%s: got %v want %v", item.name, rr.Code, want)
+		}
+		if issued := rr.Header().Get("Set-Cookie") != ""; issued != item.issued {
+			t.Errorf("%s: CSRF cookie issued %v want %v", item.name, issued, item.issued)
+		}
+	}
+
+	// A grant is accepted once across instances sharing the backend.
+	timeNow = func() time.Time { return start }
+	other := Protect(testKey, AcceptGrants("RelayState", backend, "/sso/done"))(testHandler)
+	r := grantRequest("POST", "https://b.example.com/sso/done", replayed)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	rr = httptest.NewRecorder()
+	other.ServeHTTP(rr, r)
+	if rr.Header().Get("Set-Cookie") != "" {
+		t.Error("grant redeemed on another instance accepted again")
+	}
+
+	if _, err := m.Grant("", "/sso/done", time.Minute); err == nil {
+		t.Error("grant without audience not rejected")
+	}
+	if _, err := m.Grant("b.example.com", "/sso/done", time.Hour); err == nil {
+		t.Error("grant with a ttl above the maximum not rejected")
+	}
+}
+
+// grantRequest returns a request carrying grant in the RelayState form field:
+// in the body for unsafe methods, and in the query otherwise.
+func grantRequest(method, target, grant string) *http.Request {
+	form := url.Values{"RelayState": {grant}}
+	if method == "GET" {
+		return httptest.NewRequest(method, target+"?"+form.Encode(), nil)
+	}
+
+	r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// TestGrantPOST tests that an unsafe request carrying a valid grant is still
+// subject to the CSRF check.
+func TestGrantPOST(t *testing.T) {
+	m, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant, err := m.Grant("b.example.com", "/saml/acs", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Protect(testKey, AcceptGrants("RelayState", nil, "/saml/acs"))(testHandler)
+
+	r := grantRequest("POST", "https://b.example.com/saml/acs", grant)
+	r.Header.Set("Referer", "https://idp.example.org/")
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("POST with a grant: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+// TestGrantConcurrent tests that a grant redeemed concurrently on two
+// instances sharing a backend is accepted once.
+func TestGrantConcurrent(t *testing.T) {
+	backend := &memoryGrants{claimed: map[string]bool{}}
+	var instances []http.Handler
+	for i := 0; i < 2; i++ {
+		m, err := New(testKey, AcceptGrants("RelayState", backend, "/sso/done"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances = append(instances, m.Wrap(testHandler))
+	}
+
+	m, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant, err := m.Grant("b.example.com", "/sso/done", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	instances[0].ServeHTTP(rr, httptest.NewRequest("GET", "https://b.example.com/", nil))
+	cookies := rr.Result().Cookies()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		issued int
+	)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(h http.Handler) {
+			defer wg.Done()
+			r := grantRequest("POST", "https://b.example.com/sso/done", grant)
+			for _, c := range cookies {
+				r.AddCookie(c)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+			if rr.Header().Get("Set-Cookie") != "" {
+				mu.Lock()
+				issued++
+				mu.Unlock()
+			}
+		}(instances[i%2])
+	}
+	wg.Wait()
+
+	if issued != 1 {
+		t.Fatalf("grant accepted %d times want 1", issued)
+	}
+}
@@ -0,0 +1,84 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// HealthChecker is implemented by stores and token backends that can report
+// whether their backend is reachable, e.g. by pinging a database. See
+// Middleware.Health.
+type HealthChecker interface {
+	// Health returns an error if the backend cannot serve requests.
+	Health(ctx context.Context) error
+}
+
+// errStaleOrigins is returned by Health if the remote trusted origins list
+// has not been refreshed within its maximum staleness.
+var errStaleOrigins = errors.New("trusted origins list is stale")
+
+// Health reports whether the configured backends can serve requests: it
+// returns ErrStoreUnavailable while the store's circuit breaker is open or if
+// a store or TokenBackend implementing HealthChecker reports an error, and an
+// error if the TrustedOriginsURL list is older than its maximum staleness.
+// Use it in a readiness probe, so that traffic is kept away from instances
+// that would reject every unsafe request.
+func (m *Middleware) Health(ctx context.Context) error {
+	return m.cs.health(ctx)
+}
+
+// HealthHandler returns a handler for readiness probes, which responds with
+// 200 OK if Health returns nil and with 503 Service Unavailable otherwise.
+func (m *Middleware) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.Health(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// health checks the store and the dynamic sources of trusted origins.
+func (cs *csrf) health(ctx context.Context) error {
+	st := cs.st
+	if gs, ok := st.(*guardedStore); ok {
+		if !gs.allow() {
+			return fmt.Errorf("%w: circuit breaker open", ErrStoreUnavailable)
+		}
+		if gs.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, gs.timeout)
+			defer cancel()
+		}
+		st = gs.st
+	}
+
+	var hc HealthChecker
+	switch st := st.(type) {
+	case *opaqueStore:
+		hc, _ = st.backend.(HealthChecker)
+	case HealthChecker:
+		hc = st
+	}
+	if hc != nil {
+		if err := hc.Health(ctx); err != nil {
+			if errors.Is(err, ErrStoreUnavailable) {
+				return err
+			}
+			return fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+		}
+	}
+
+	for _, src := range cs.originSources {
+		if ro, ok := src.(*remoteOrigins); ok && ro.stale() {
+			return fmt.Errorf("%w: %s", errStaleOrigins, redactURL(ro.url))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// pingStore is a flakyStore whose backend can be pinged.
+type pingStore struct {
+	flakyStore
+	err error
+}
+
+func (ps *pingStore) Health(ctx context.Context) error {
+	return ps.err
+}
+
+// TestHealth tests that Health reports unreachable backends, including an
+// open circuit breaker, and that the handler maps them to 503.
+func TestHealth(t *testing.T) {
+	ps := &pingStore{}
+	m, err := New(testKey, TokenStore(ps), StoreCircuitBreaker(1, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	probe := func() int {
+		rr := httptest.NewRecorder()
+		m.HealthHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/ready", nil))
+		return rr.Code
+	}
+
+	if err := m.Health(context.Background()); err != nil {
+		t.Fatalf("healthy store reported: got %v", err)
+	}
+	if code := probe(); code != http.StatusOK {
+		t.Fatalf("healthy store probed: got %v want %v", code, http.StatusOK)
+	}
+
+	ps.err = errors.New("connection refused")
+	if err := m.Health(context.Background()); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("unreachable store not reported: got %v want %v", err, ErrStoreUnavailable)
+	}
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Fatalf("unreachable store probed: got %v want %v", code, http.StatusServiceUnavailable)
+	}
+
+	// Trip the circuit breaker with a failing request.
+	ps.err = nil
+	ps.failures = 1
+	m.Wrap(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if err := m.Health(context.Background()); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("open circuit breaker not reported: got %v want %v", err, ErrStoreUnavailable)
+	}
+}
+
+// TestHealthBackend tests that Health checks the TokenBackend of OpaqueTokens.
+func TestHealthBackend(t *testing.T) {
+	backend := &pingBackend{memoryBackend: memoryBackend{tokens: map[string][]byte{}}}
+	m, err := New(testKey, OpaqueTokens(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Health(context.Background()); err != nil {
+		t.Fatalf("healthy backend reported: got %v", err)
+	}
+
+	backend.err = errors.New("connection refused")
+	if err := m.Health(context.Background()); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("unreachable backend not reported: got %v want %v", err, ErrStoreUnavailable)
+	}
+}
+
+// pingBackend is a memoryBackend that can be pinged.
+type pingBackend struct {
+	memoryBackend
+	err error
+}
+
+func (pb *pingBackend) Health(ctx context.Context) error {
+	return pb.err
+}
@@ -1,33 +1,80 @@
 package csrf
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"html"
 	"html/template"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	texttemplate "text/template"
 )
 
 // Token returns a masked CSRF token ready for passing into HTML template or
 // a JSON response body. An empty token will be returned if the middleware
 // has not been applied (which will fail subsequent validation).
+//
+// Reading the token adds "Vary: Cookie" to the response, as it now depends on
+// the cookie; read it before writing the response headers.
 func Token(r *http.Request) string {
+	token, _ := TokenOK(r)
+	return token
+}
+
+// TokenOK is like Token, but also reports whether the middleware has set a
+// token for r, to tell a missing middleware apart from an empty token.
+func TokenOK(r *http.Request) (string, bool) {
+	if rw, ok := r.Context().Value(writerKey).(*responseWriter); ok {
+		if token := rw.token(); token != "" {
+			markVary(r)
+			return token, true
+		}
+	}
 	if val, err := contextGet(r, tokenKey); err == nil {
 		if maskedToken, ok := val.(string); ok {
-			return maskedToken
+			markVary(r)
+			return maskedToken, true
 		}
 	}
 
-	return ""
+	return "", false
 }
 
 // FailureReason makes CSRF validation errors available in the request context.
 // This is useful when you want to log the cause of the error or report it to
 // client.
 func FailureReason(r *http.Request) error {
+	err, _ := FailureReasonOK(r)
+	return err
+}
+
+// FailureReasonOK is like FailureReason, but also reports whether the
+// middleware has processed r at all. A nil error with ok set means that r
+// has not failed validation (yet); ok is false if the middleware is not
+// installed, or skipped r.
+func FailureReasonOK(r *http.Request) (error, bool) {
 	if val, err := contextGet(r, errorKey); err == nil {
+		if err, ok := val.(error); ok {
+			return err, true
+		}
+	}
+
+	_, err := contextGet(r, protectedKey)
+	return nil, err == nil
+}
+
+// MalformedToken returns the reason the CSRF token of r could not be decoded,
+// i.e. ErrTokenTooLong, ErrTokenEncoding or ErrTokenLength, if r failed
+// validation with ErrBadToken because of it, and nil otherwise. FailureReason
+// reports ErrBadToken itself for malformed tokens.
+func MalformedToken(r *http.Request) error {
+	if val, err := contextGet(r, defectKey); err == nil {
 		if err, ok := val.(error); ok {
 			return err
 		}
@@ -36,6 +83,33 @@ func FailureReason(r *http.Request) error {
 	return nil
 }
 
+// HeaderName returns the name of the request header the middleware reads the
+// CSRF token from, as configured with RequestHeader, so that front-end code
+// does not need to hard-code "X-CSRF-Token". An empty string is returned if
+// the middleware has not been applied.
+func HeaderName(r *http.Request) string {
+	if name, err := contextGet(r, headerKey); err == nil {
+		if name, ok := name.(string); ok {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// FieldNameOf returns the name of the form field the middleware reads the
+// CSRF token from, as configured with FieldName. An empty string is returned
+// if the middleware has not been applied.
+func FieldNameOf(r *http.Request) string {
+	if name, err := contextGet(r, formKey); err == nil {
+		if name, ok := name.(string); ok {
+			return name
+		}
+	}
+
+	return ""
+}
+
 // UnsafeSkipCheck will skip the CSRF check for any requests.  This must be
 // called before the CSRF middleware.
 //
@@ -57,14 +131,97 @@ func UnsafeSkipCheck(r *http.Request) *http.Request {
 //	// ... becomes:
 //	<input type="hidden" name="gorilla.csrf.Token" value="<token>">
 func TemplateField(r *http.Request) template.HTML {
-	if name, err := contextGet(r, formKey); err == nil {
-		fragment := fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
-			name, Token(r))
+	return template.HTML(TemplateFieldString(r))
+}
+
+// TemplateFieldString returns the <input> field populated with a CSRF token as
+// a string, with the field name and token HTML-escaped. It is meant for
+// text/template and other non-html/template pipelines - e.g. HTML emails -
+// where the template.HTML type returned by TemplateField is meaningless.
+func TemplateFieldString(r *http.Request) string {
+	if name := FieldNameOf(r); name != "" {
+		return hiddenField(name, Token(r))
+	}
+
+	return ""
+}
+
+// hiddenField returns a hidden <input> field, with name and value
+// HTML-escaped.
+func hiddenField(name, value string) string {
+	return fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		html.EscapeString(name), html.EscapeString(value))
+}
+
+// TextFuncMap returns a text/template FuncMap providing the CSRF field of r as
+// {{ csrfField }}.
+func TextFuncMap(r *http.Request) texttemplate.FuncMap {
+	return texttemplate.FuncMap{
+		TemplateTag: func() string {
+			return TemplateFieldString(r)
+		},
+	}
+}
+
+// TemplateFuncs returns a html/template FuncMap with csrfField, csrfToken and
+// csrfMetaTags functions. All take the current *http.Request or its context.Context as
+// their argument, so templates can render the CSRF field without it being
+// injected into every data map:
+//
+//	tmpl := template.Must(template.New("form").Funcs(csrf.TemplateFuncs()).Parse(form))
+//
+//	// ... and in the template, given the request as .Request:
+//	{{ csrfField .Request }}
+//	<meta name="csrf-token" content="{{ csrfToken .Request }}">
+//	{{ csrfMetaTags .Request }}
+//
+// An additional "fresh" argument masks the token anew for this call, see
+// FreshMask: {{ csrfField .Request "fresh" }}.
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		TemplateTag: func(v interface{}, args ...string) (template.HTML, error) {
+			r, err := templateRequest(v)
+			if err != nil {
+				return "", err
+			}
+			opts, err := templateTokenOptions(args)
+			if err != nil {
+				return "", err
+			}
+			return TemplateFieldWith(r, opts...), nil
+		},
+		"csrfToken": func(v interface{}, args ...string) (string, error) {
+			r, err := templateRequest(v)
+			if err != nil {
+				return "", err
+			}
+			opts, err := templateTokenOptions(args)
+			if err != nil {
+				return "", err
+			}
+			return TokenWith(r, opts...), nil
+		},
+		"csrfMetaTags": func(v interface{}) (template.HTML, error) {
+			r, err := templateRequest(v)
+			if err != nil {
+				return "", err
+			}
+			return MetaTags(r), nil
+		},
+	}
+}
 
-		return template.HTML(fragment)
+// templateRequest returns a request carrying the CSRF context values of v,
+// which is either a *http.Request or a context.Context.
+func templateRequest(v interface{}) (*http.Request, error) {
+	switch v := v.(type) {
+	case *http.Request:
+		return v, nil
+	case context.Context:
+		return (&http.Request{}).WithContext(v), nil
 	}
 
-	return template.HTML("")
+	return nil, fmt.Errorf("%sexpected *http.Request or context.Context, got %T", errorPrefix, v)
 }
 
 // mask returns a unique-per-request token to mitigate the BREACH attack
@@ -80,6 +237,11 @@ func mask(realToken []byte, r *http.Request) string {
 		return ""
 	}
 
+	return maskWith(otp, realToken)
+}
+
+// maskWith masks realToken with the given one-time-pad.
+func maskWith(otp, realToken []byte) string {
 	// XOR the OTP with the real token to generate a masked token. Append the
 	// OTP to the front of the masked token to allow unmasking in the subsequent
 	// request.
@@ -102,11 +264,50 @@ func unmask(issued []byte) []byte {
 	return xorToken(otp, masked)
 }
 
+// VerifyTokens reports for each candidate whether it is a masked token for the
+// same real token as real, which is itself a masked token, e.g. as returned by
+// Token or Mint. It is intended for batch processing, e.g. replaying queued
+// form submissions: real is decoded once, and each candidate is compared in
+// constant time. Candidates that fail to decode are reported as false, as are
+// all candidates if real fails to decode.
+func VerifyTokens(real string, candidates []string) []bool {
+	results := make([]bool, len(candidates))
+
+	decoded, err := decodeToken(real, encodedTokenLength)
+	if err != nil {
+		return results
+	}
+	realToken := unmask(decoded)
+
+	for i, candidate := range candidates {
+		decoded, err := decodeToken(candidate, encodedTokenLength)
+		if err != nil {
+			continue
+		}
+		results[i] = compareTokens(unmask(decoded), realToken)
+	}
+
+	return results
+}
+
 // requestToken returns the issued token (pad + masked token) from the HTTP POST
 // body or HTTP header. It will return nil if the token fails to decode.
-func (cs *csrf) requestToken(r *http.Request) ([]byte, error) {
+func (cs *csrf) requestToken(w http.ResponseWriter, r *http.Request) ([]byte, error) {
 	// 1. Check the HTTP header first.
 	issued := r.Header.Get(cs.opts.RequestHeader)
+	if issued == "" {
+		issued = cs.turboToken(r)
+	}
+	limit := cs.opts.MaxHeaderTokenSize
+
+	// Parse the form within the configured limit before the application's own
+	// limits apply.
+	if issued == "" {
+		if err := cs.parseForm(w, r); err != nil {
+			return nil, err
+		}
+		limit = cs.opts.MaxFieldTokenSize
+	}
 
 	// 2. Fall back to the POST (form) value.
 	if issued == "" {
@@ -129,20 +330,83 @@ func (cs *csrf) requestToken(r *http.Request) ([]byte, error) {
 
 	// Decode the "issued" (pad + masked) token sent in the request. Return a
 	// nil byte slice on a decoding error (this will fail upstream).
-	decoded, err := base64.StdEncoding.DecodeString(issued)
+	return decodeToken(issued, limit)
+}
+
+// malformedToken is returned by verify for a token that fails to decode. fail
+// reports it as ErrBadToken and saves the cause for MalformedToken.
+type malformedToken struct {
+	cause error
+}
+
+func (e malformedToken) Error() string {
+	return ErrBadToken.Error() + ": " + e.cause.Error()
+}
+
+// encodedTokenLength is the length of an issued (pad + masked) token encoded
+// as base64.
+var encodedTokenLength = base64.StdEncoding.EncodedLen(tokenLength * 2)
+
+// decodeToken strictly decodes an issued (pad + masked) token. Values longer
+// than limit bytes are rejected before decoding, and the encoding must be
+// canonical base64 without whitespace.
+func decodeToken(issued string, limit int) ([]byte, error) {
+	if len(issued) > limit {
+		return nil, ErrTokenTooLong
+	}
+
+	// The decoder skips line breaks, which never occur in a valid token.
+	if strings.ContainsAny(issued, "\r\n") {
+		return nil, ErrTokenEncoding
+	}
+
+	decoded, err := base64.StdEncoding.Strict().DecodeString(issued)
 	if err != nil {
-		return nil, err
+		return nil, ErrTokenEncoding
+	}
+
+	if len(decoded) != tokenLength*2 {
+		return nil, ErrTokenLength
 	}
 
 	return decoded, nil
 }
 
+// defaultMaxMemory is the maximum amount of memory used for a multipart form,
+// matching net/http.
+const defaultMaxMemory = 32 << 20
+
+// parseForm parses the form in the request body, reading at most MaxBodySize
+// bytes. It is a no-op if no limit is configured or the form has already been
+// parsed. The body is only limited while parsing, so that other content types
+// are passed to the handler unaffected.
+func (cs *csrf) parseForm(w http.ResponseWriter, r *http.Request) error {
+	if cs.opts.MaxBodySize <= 0 || r.Body == nil || r.PostForm != nil {
+		return nil
+	}
+
+	body := r.Body
+	r.Body = http.MaxBytesReader(w, body, cs.opts.MaxBodySize)
+	err := r.ParseForm()
+	if err == nil {
+		err = r.ParseMultipartForm(defaultMaxMemory)
+	}
+	r.Body = body
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrBodyTooLarge
+	}
+
+	return nil
+}
+
 // generateRandomBytes returns securely generated random bytes.
 // It will return an error if the system's secure random number generator
 // fails to function correctly.
 func generateRandomBytes(n int) ([]byte, error) {
 	b := make([]byte, n)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(rand.Reader, b)
 	// err == nil only if len(b) == n
 	if err != nil {
 		return nil, err
@@ -153,9 +417,12 @@ func generateRandomBytes(n int) ([]byte, error) {
 }
 
 // sameOrigin returns true if URLs a and b share the same origin. The same
-// origin is defined as host (which includes the port) and scheme.
+// origin is defined as host (which includes the port) and scheme. An explicit
+// default port is the same as no port, e.g. https://example.com:443 and
+// https://example.com are the same origin.
 func sameOrigin(a, b *url.URL) bool {
-	return (a.Scheme == b.Scheme && a.Host == b.Host)
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		normalizeHost(a.Scheme, a.Host) == normalizeHost(b.Scheme, b.Host)
 }
 
 // compare securely (constant-time) compares the unmasked token from the request
@@ -200,8 +467,3 @@ func contains(vals []string, s string) bool {
 
 	return false
 }
-
-// envError stores a CSRF error in the request context.
-func envError(r *http.Request, err error) *http.Request {
-	return contextSave(r, errorKey, err)
-}
@@ -5,11 +5,14 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"html"
+	htmltemplate "html/template"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
 	"text/template"
@@ -25,6 +28,61 @@ var testTemplate = `
 </html>
 `
 
+// Test that TokenOK and FailureReasonOK tell a missing middleware apart from
+// an empty token or a request without a failure.
+func TestContextAccessorsOK(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if token, ok := TokenOK(r); ok || token != "" {
+		t.Fatalf("token without middleware: got %q, %v", token, ok)
+	}
+	if err, ok := FailureReasonOK(r); ok || err != nil {
+		t.Fatalf("failure reason without middleware: got %v, %v", err, ok)
+	}
+
+	var tokenOK, reasonOK bool
+	var reason error
+	p := Protect(testKey, ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason, reasonOK = FailureReasonOK(r)
+	})))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, tokenOK = TokenOK(r)
+		reason, reasonOK = FailureReasonOK(r)
+	}))
+
+	p.ServeHTTP(httptest.NewRecorder(), r)
+	if !tokenOK || !reasonOK || reason != nil {
+		t.Fatalf("safe request: got token %v, reason %v, %v", tokenOK, reason, reasonOK)
+	}
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+	if !reasonOK || reason != ErrNoToken {
+		t.Fatalf("failed request: got %v, %v", reason, reasonOK)
+	}
+}
+
+// Test that HeaderName and FieldNameOf reflect the configured names.
+func TestHeaderFieldNames(t *testing.T) {
+	var header, field string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, field = HeaderName(r), FieldNameOf(r)
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if header != "" || field != "" {
+		t.Fatalf("names without middleware: got %q, %q", header, field)
+	}
+
+	Protect(testKey)(h).ServeHTTP(httptest.NewRecorder(), r)
+	if header != headerName || field != fieldName {
+		t.Fatalf("default names: got %q, %q want %q, %q", header, field, headerName, fieldName)
+	}
+
+	Protect(testKey, RequestHeader("X-Token"), FieldName("token"))(h).ServeHTTP(httptest.NewRecorder(), r)
+	if header != "X-Token" || field != "token" {
+		t.Fatalf("configured names: got %q, %q want %q, %q", header, field, "X-Token", "token")
+	}
+}
+
 // Test that our form helpers correctly inject a token into the response body.
 func TestFormToken(t *testing.T) {
 	s := http.NewServeMux()
@@ -152,6 +210,10 @@ func TestSameOrigin(t *testing.T) {
 		{"http://golang.org/", "http://golang.org/pkg/net/http", true},
 		{"https://gorillatoolkit.org/", "http://gorillatoolkit.org", false},
 		{"https://gorillatoolkit.org:3333/", "http://gorillatoolkit.org:4444", false},
+		{"https://gorillatoolkit.org:443/", "https://gorillatoolkit.org", true},
+		{"http://gorillatoolkit.org/", "http://gorillatoolkit.org:80/", true},
+		{"https://gorillatoolkit.org:80/", "https://gorillatoolkit.org", false},
+		{"https://gorillatoolkit.org:8443/", "https://gorillatoolkit.org", false},
 	}
 
 	for _, origins := range originTests {
@@ -300,3 +362,264 @@ func TestUnsafeSkipCSRFCheck(t *testing.T) {
 			status, teapot)
 	}
 }
+
+// Test that form bodies exceeding MaxBodySize are rejected while small forms
+// still pass.
+func TestMaxBodySize(t *testing.T) {
+	var token string
+	var reason error
+	s := http.NewServeMux()
+	s.HandleFunc("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	}))
+	p := Protect(testKey, MaxBodySize(1024), ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason = FailureReason(r)
+		w.WriteHeader(http.StatusForbidden)
+	})))(s)
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	for _, item := range []struct {
+		padding int
+		code    int
+	}{
+		{10, http.StatusOK},
+		{4096, http.StatusForbidden},
+	} {
+		form := url.Values{}
+		form.Set(fieldName, token)
+		form.Set("padding", strings.Repeat("x", item.padding))
+
+		req, err := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		setCookie(rr, req)
+
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+
+		if res.Code != item.code {
+			t.Fatalf("padding %d: got %v want %v", item.padding, res.Code, item.code)
+		}
+	}
+
+	if reason != ErrBodyTooLarge {
+		t.Fatalf("oversized body not reported: got %v want %v", reason, ErrBodyTooLarge)
+	}
+}
+
+// Test that oversized header and form field values are rejected before they
+// are decoded, with separate limits for each.
+func TestMaxTokenSize(t *testing.T) {
+	var token string
+	var reason, cause error
+	s := http.NewServeMux()
+	s.HandleFunc("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	}))
+	p := Protect(testKey, MaxTokenSize(40, 0), ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason = FailureReason(r)
+		cause = MalformedToken(r)
+		w.WriteHeader(http.StatusForbidden)
+	})))(s)
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	for _, item := range []struct {
+		header string
+		field  string
+		code   int
+	}{
+		{header: token, code: http.StatusForbidden},
+		{header: strings.Repeat("A", 1<<20), code: http.StatusForbidden},
+		{field: token, code: http.StatusOK},
+		{field: token + "AAAA", code: http.StatusForbidden},
+	} {
+		reason, cause = nil, nil
+		form := url.Values{}
+		form.Set(fieldName, item.field)
+
+		req, err := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if item.header != "" {
+			req.Header.Set(headerName, item.header)
+		}
+		setCookie(rr, req)
+
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+
+		if res.Code != item.code {
+			t.Fatalf("header %.20q, field %.20q: got %v want %v", item.header, item.field, res.Code, item.code)
+		}
+		if item.code != http.StatusOK && (reason != ErrBadToken || cause != ErrTokenTooLong) {
+			t.Fatalf("header %.20q, field %.20q: got %v (%v) want %v (%v)", item.header, item.field, reason, cause, ErrBadToken, ErrTokenTooLong)
+		}
+	}
+}
+
+// Test that the templ component and quicktemplate writer render the same field
+// as TemplateField.
+func TestFieldComponents(t *testing.T) {
+	var field, component, written string
+	s := http.NewServeMux()
+	s.HandleFunc("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		field = string(TemplateField(r))
+
+		var b strings.Builder
+		if err := TemplComponent(r).Render(r.Context(), &b); err != nil {
+			t.Fatal(err)
+		}
+		component = b.String()
+
+		b.Reset()
+		WriteTemplateField(&b, r)
+		written = b.String()
+	}))
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Protect(testKey)(s).ServeHTTP(httptest.NewRecorder(), r)
+
+	if field == "" || component != field || written != field {
+		t.Fatalf("components do not render the field: got %q and %q want %q", component, written, field)
+	}
+}
+
+// Test that TextFuncMap provides the escaped CSRF field to text/template.
+func TestTextFuncMap(t *testing.T) {
+	var field, rendered string
+	s := http.NewServeMux()
+	s.HandleFunc("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		field = TemplateFieldString(r)
+
+		var b strings.Builder
+		tmpl := template.Must(template.New("email").Funcs(TextFuncMap(r)).Parse(`{{ csrfField }}`))
+		if err := tmpl.Execute(&b, nil); err != nil {
+			t.Fatal(err)
+		}
+		rendered = b.String()
+	}))
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Protect(testKey, FieldName(`a"b`))(s).ServeHTTP(httptest.NewRecorder(), r)
+
+	if !strings.Contains(field, `name="a&#34;b"`) {
+		t.Fatalf("field name not escaped: got %q", field)
+	}
+
+	if rendered != field {
+		t.Fatalf("FuncMap did not render the field: got %q want %q", rendered, field)
+	}
+}
+
+// Test that TemplateFuncs renders the field and token from the request or its
+// context.
+func TestTemplateFuncs(t *testing.T) {
+	var field, rendered string
+	s := http.NewServeMux()
+	s.HandleFunc("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		field = string(TemplateField(r)) + Token(r)
+
+		var b strings.Builder
+		tmpl := htmltemplate.Must(htmltemplate.New("form").Funcs(TemplateFuncs()).Parse(
+			`{{ csrfField .Request }}{{ csrfToken .Ctx }}`))
+		err := tmpl.Execute(&b, map[string]interface{}{
+			"Request": r,
+			"Ctx":     r.Context(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// html/template escapes the '+' characters of the token.
+		rendered = html.UnescapeString(b.String())
+	}))
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Protect(testKey)(s).ServeHTTP(httptest.NewRecorder(), r)
+
+	if field == "" || rendered != field {
+		t.Fatalf("FuncMap did not render the field and token: got %q want %q", rendered, field)
+	}
+}
+
+func TestVerifyTokens(t *testing.T) {
+	realToken, err := generateRandomBytes(tokenLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := generateRandomBytes(tokenLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	real := mask(realToken, nil)
+	candidates := []string{
+		mask(realToken, nil),
+		mask(otherToken, nil),
+		"not base64!",
+		real,
+		"",
+	}
+
+	got := VerifyTokens(real, candidates)
+	want := []bool{true, false, false, true, false}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("VerifyTokens: got %v want %v", got, want)
+	}
+
+	if got := VerifyTokens("invalid", candidates); !reflect.DeepEqual(got, make([]bool, len(candidates))) {
+		t.Fatalf("VerifyTokens with an invalid real token: got %v", got)
+	}
+}
+
+func TestDecodeToken(t *testing.T) {
+	valid := mask(make([]byte, tokenLength), nil)
+
+	testTable := []struct {
+		token string
+		err   error
+	}{
+		{valid, nil},
+		{valid + "AAAA", ErrTokenTooLong},
+		{strings.Repeat("A", 1<<20), ErrTokenTooLong},
+		{valid[:40] + "\n" + valid[41:], ErrTokenEncoding},
+		{valid[:40] + " " + valid[41:], ErrTokenEncoding},
+		{valid[:85] + "B==", ErrTokenEncoding},
+		{"dG9rZW4=", ErrTokenLength},
+	}
+
+	for _, item := range testTable {
+		if _, err := decodeToken(item.token, encodedTokenLength); err != item.err {
+			t.Errorf("decodeToken(%.20q): got %v want %v", item.token, err, item.err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package csrf
+
+import (
+	"net/http"
+)
+
+// WithToken returns a shallow copy of r that carries token, a masked token
+// verified within the process, e.g. the token of a request that has already
+// passed an outer Protect layer, as returned by Token. Middleware chains such
+// as a backend-for-frontend use it to pass requests they build in the process
+// through a second Protect layer without fetching a cookie for them.
+//
+// A Protect layer accepts such a request without a token check, and returns
+// token from Token. If the request also carries a valid CSRF cookie of the
+// layer, token must match it. Clients cannot set the token this way, but
+// only pass tokens to WithToken that have been verified.
+func WithToken(r *http.Request, token string) *http.Request {
+	return contextSave(r, injectedKey, token)
+}
+
+// injectedToken returns the token set with WithToken, if any.
+func injectedToken(r *http.Request) (string, bool) {
+	val, err := contextGet(r, injectedKey)
+	if err != nil {
+		return "", false
+	}
+
+	token, ok := val.(string)
+	return token, ok
+}
+
+// serveInjected serves a request carrying a token set with WithToken. The
+// token is checked against the cookie of the request, if any, and made
+// available to the handler.
+func (cs *csrf) serveInjected(w http.ResponseWriter, r *http.Request, token string) {
+	if realToken, err := cs.st.Get(boundedRequest(r)); err == nil && len(realToken) == tokenLength {
+		issued, err := decodeToken(token, encodedTokenLength)
+		if err != nil {
+			cs.fail(w, r, ErrBadToken)
+			return
+		}
+		if realToken, err = cs.bindToken(r, realToken); err != nil {
+			cs.fail(w, r, err)
+			return
+		}
+		if !compareTokens(unmask(issued), realToken) {
+			cs.fail(w, r, ErrBadToken)
+			return
+		}
+	}
+
+	r = cs.saveValue(r, tokenKey, token)
+	r = cs.saveValue(r, formKey, cs.opts.FieldName)
+	r = cs.saveValue(r, headerKey, cs.opts.RequestHeader)
+
+	cs.skip(w, r, SkippedInjected)
+}
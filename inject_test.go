@@ -0,0 +1,70 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWithToken tests that a request built within the process passes a second
+// Protect layer with a verified token, which the layer exposes to its handler.
+func TestWithToken(t *testing.T) {
+	var got string
+	inner := Protect(testKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = Token(r)
+	}))
+
+	var token string
+	outer := Protect(testKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+		if r.Method != "POST" {
+			return
+		}
+
+		// Build a request for the second layer, without the cookies.
+		upstream := httptest.NewRequest("POST", "/internal", nil).WithContext(r.Context())
+		inner.ServeHTTP(w, WithToken(upstream, token))
+	}))
+
+	rr := httptest.NewRecorder()
+	outer.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	r := httptest.NewRequest("POST", "/", nil)
+	setCookie(rr, r)
+	r.Header.Set("X-CSRF-Token", token)
+
+	rr = httptest.NewRecorder()
+	outer.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK || got != token {
+		t.Fatalf("injected token not accepted: got %v, %q want %q", rr.Code, got, token)
+	}
+}
+
+// TestWithTokenMismatch tests that an injected token must match the cookie of
+// the request, if it carries one.
+func TestWithTokenMismatch(t *testing.T) {
+	p := Protect(testKey)(testHandler)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	m, err := New(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := m.Mint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	setCookie(rr, r)
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, WithToken(r, other))
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("mismatched injected token accepted: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
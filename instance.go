@@ -0,0 +1,78 @@
+package csrf
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+)
+
+// instanceID identifies a middleware instance - the handlers created by one
+// call of Protect, New or NewServeMux - in the request context.
+type instanceID struct{ _ int }
+
+// instanceKey is a context key of a single middleware instance.
+type instanceKey struct {
+	id  *instanceID
+	key contextKey
+}
+
+// saveValue saves val in the request context under key, both for the package
+// accessors such as Token, which return the values of the innermost
+// middleware, and for the accessors of this instance such as Middleware.Token.
+func (cs *csrf) saveValue(r *http.Request, key contextKey, val interface{}) *http.Request {
+	ctx := context.WithValue(r.Context(), key, val)
+	ctx = context.WithValue(ctx, instanceKey{cs.id, key}, val)
+	return r.WithContext(ctx)
+}
+
+// value returns the value saved under key by the middleware m, or nil.
+func (m *Middleware) value(r *http.Request, key contextKey) interface{} {
+	return r.Context().Value(instanceKey{m.cs.id, key})
+}
+
+// Token is like the Token function, but returns the token issued by m, even
+// if r has passed other CSRF middleware with a different configuration, e.g.
+// nested routers protected by separate instances.
+func (m *Middleware) Token(r *http.Request) string {
+	token, ok := m.value(r, tokenKey).(string)
+	if rw, regenerated := m.value(r, writerKey).(*responseWriter); regenerated && rw.token() != "" {
+		token, ok = rw.token(), true
+	}
+	if ok {
+		markVary(r)
+	}
+
+	return token
+}
+
+// FailureReason is like the FailureReason function, but returns the reason r
+// failed validation by m.
+func (m *Middleware) FailureReason(r *http.Request) error {
+	err, _ := m.value(r, errorKey).(error)
+	return err
+}
+
+// HeaderName is like the HeaderName function, but returns the request header
+// m reads the token from if r has passed m, and an empty string otherwise.
+func (m *Middleware) HeaderName(r *http.Request) string {
+	name, _ := m.value(r, headerKey).(string)
+	return name
+}
+
+// FieldName is like FieldNameOf, but returns the form field m reads the token
+// from if r has passed m, and an empty string otherwise.
+func (m *Middleware) FieldName(r *http.Request) string {
+	name, _ := m.value(r, formKey).(string)
+	return name
+}
+
+// TemplateField is like the TemplateField function, but renders the field
+// name and token of m.
+func (m *Middleware) TemplateField(r *http.Request) template.HTML {
+	name := m.FieldName(r)
+	if name == "" {
+		return ""
+	}
+
+	return template.HTML(hiddenField(name, m.Token(r)))
+}
@@ -0,0 +1,66 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestInstanceAccessors tests that nested middleware instances keep their own
+// tokens and failure reasons in the request context.
+func TestInstanceAccessors(t *testing.T) {
+	outer, err := New(testKey, CookieName("outer"), RequestHeader("X-Outer"), FieldName("outer_token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var innerReason, outerReason error
+	var inner *Middleware
+	inner, err = New(testKey, CookieName("inner"), RequestHeader("X-Inner"),
+		ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			innerReason, outerReason = inner.FailureReason(r), outer.FailureReason(r)
+			w.WriteHeader(http.StatusForbidden)
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outerToken, innerToken, token, field string
+	h := outer.Wrap(inner.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outerToken, innerToken, token = outer.Token(r), inner.Token(r), Token(r)
+		field = string(outer.TemplateField(r))
+	})))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if outerToken == "" || outerToken == innerToken || token != innerToken {
+		t.Fatalf("tokens collide: outer %q, inner %q, innermost %q", outerToken, innerToken, token)
+	}
+	if !strings.Contains(field, `name="outer_token"`) || !strings.Contains(field, outerToken) {
+		t.Fatalf("template field not of the outer instance: got %q", field)
+	}
+
+	post := func(outerToken, innerToken string) int {
+		r := httptest.NewRequest("POST", "/", nil)
+		for _, c := range rr.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		r.Header.Set("X-Outer", outerToken)
+		r.Header.Set("X-Inner", innerToken)
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	if code := post(outerToken, innerToken); code != http.StatusOK {
+		t.Fatalf("nested tokens rejected: got %v", code)
+	}
+
+	if code := post(outerToken, outerToken); code != http.StatusForbidden || innerReason == nil || outerReason != nil {
+		t.Fatalf("failure reasons collide: got %v, inner %v, outer %v", code, innerReason, outerReason)
+	}
+}
@@ -0,0 +1,119 @@
+package csrf
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// maxReissuePaths is the maximum number of paths tracked for repeated
+	// re-issuance.
+	maxReissuePaths = 100
+	// reissueThreshold is the number of re-issued cookies at which a path is
+	// logged as likely misconfigured.
+	reissueThreshold = 10
+)
+
+// IssuanceStats counts how often the middleware issued a new CSRF cookie, and
+// how often it reused the cookie sent with the request.
+//
+// A new cookie issued to a request that carries other cookies, or a CSRF
+// cookie that cannot be decoded, is counted as re-issued: the client returns
+// cookies, but not the CSRF cookie it was given. Many re-issues on a path
+// indicate a misconfigured cookie Domain, Path or SameSite attribute.
+type IssuanceStats struct {
+	// Issued is the number of responses that set a new CSRF cookie.
+	Issued int64
+	// Reused is the number of requests whose CSRF cookie was reused.
+	Reused int64
+	// Reissued is the number of new cookies issued to clients that returned
+	// other cookies.
+	Reissued int64
+	// Paths holds the number of re-issued cookies per request path, for up to
+	// 100 paths.
+	Paths map[string]int64
+}
+
+// issuance tracks cookie issuance for IssuanceStats.
+type issuance struct {
+	issued   atomic.Int64
+	reused   atomic.Int64
+	reissued atomic.Int64
+
+	mu    sync.Mutex
+	paths map[string]int64
+}
+
+// Issuance returns the cookie issuance counters of the middleware.
+func (m *Middleware) Issuance() IssuanceStats {
+	return m.cs.stats.issuance.snapshot()
+}
+
+// issue counts a new cookie issued for r. It returns the number of cookies
+// re-issued on the path of r so far, or 0 if the cookie is not re-issued.
+func (is *issuance) issue(r *http.Request, badCookie bool) int64 {
+	is.issued.Add(1)
+	if !badCookie && r.Header.Get("Cookie") == "" {
+		return 0
+	}
+	is.reissued.Add(1)
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	if is.paths == nil {
+		is.paths = make(map[string]int64)
+	}
+	if _, ok := is.paths[r.URL.Path]; !ok && len(is.paths) >= maxReissuePaths {
+		return 0
+	}
+	is.paths[r.URL.Path]++
+
+	return is.paths[r.URL.Path]
+}
+
+// snapshot returns a copy of the counters.
+func (is *issuance) snapshot() IssuanceStats {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	s := IssuanceStats{
+		Issued:   is.issued.Load(),
+		Reused:   is.reused.Load(),
+		Reissued: is.reissued.Load(),
+		Paths:    make(map[string]int64, len(is.paths)),
+	}
+	for path, n := range is.paths {
+		s.Paths[path] = n
+	}
+
+	return s
+}
+
+// reissuePaths returns the paths of s by the number of re-issued cookies,
+// the most first.
+func (s IssuanceStats) reissuePaths() []string {
+	paths := make([]string, 0, len(s.Paths))
+	for path := range s.Paths {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if s.Paths[paths[i]] != s.Paths[paths[j]] {
+			return s.Paths[paths[i]] > s.Paths[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+
+	return paths
+}
+
+// countIssue counts a new cookie issued for r, and logs paths that keep
+// re-issuing cookies.
+func (cs *csrf) countIssue(r *http.Request, badCookie bool) {
+	if n := cs.stats.issuance.issue(r, badCookie); n == reissueThreshold {
+		cs.logf("%s: issued %d new CSRF cookies to clients that did not return theirs; check the Domain, Path and SameSite options",
+			r.URL.Path, n)
+	}
+}
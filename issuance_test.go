@@ -0,0 +1,54 @@
+package csrf
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestIssuance tests that new and reused cookies are counted, and that paths
+// whose clients do not return the cookie are flagged.
+func TestIssuance(t *testing.T) {
+	var buf bytes.Buffer
+	m, err := New(testKey, ErrorLog(log.New(&buf, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Wrap(testHandler)
+
+	// A new client gets a cookie, and returns it.
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	setCookie(rr, r)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	// A client that only returns its session cookie, e.g. because the CSRF
+	// cookie is scoped to another path.
+	for i := 0; i < reissueThreshold; i++ {
+		r := httptest.NewRequest("GET", "/account", nil)
+		r.Header.Set("Cookie", "session=abc")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	s := m.Issuance()
+	if s.Issued != reissueThreshold+1 || s.Reused != 1 || s.Reissued != reissueThreshold {
+		t.Fatalf("unexpected counters: got %+v", s)
+	}
+	if s.Paths["/account"] != reissueThreshold || len(s.Paths) != 1 {
+		t.Fatalf("re-issuing path not tracked: got %v", s.Paths)
+	}
+
+	if !strings.Contains(buf.String(), "/account: issued 10 new CSRF cookies") {
+		t.Fatalf("re-issuing path not logged: got %q", buf.String())
+	}
+
+	rr = httptest.NewRecorder()
+	m.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/admin", nil))
+	if !strings.Contains(rr.Body.String(), "Re-issuing paths:\n/account") {
+		t.Fatalf("re-issuing path not reported: got %q", rr.Body.String())
+	}
+}
@@ -0,0 +1,56 @@
+package csrf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrCookieWrite is returned if the CSRF cookie (or session) holding a new
+// token cannot be written, e.g. because the token fails to encode. It wraps
+// the error of the store.
+var ErrCookieWrite = errors.New("CSRF cookie could not be written")
+
+// IssueFailurePolicy governs how requests are handled if the cookie with a
+// new token cannot be written. The failure is always logged with
+// ErrCookieWrite, see ErrorLog.
+type IssueFailurePolicy int
+
+// Issue failure policies
+const (
+	// IssueFailureReject hands all requests to the error handler with
+	// ErrCookieWrite, as failed validations. This is the default.
+	IssueFailureReject IssueFailurePolicy = iota
+	// IssueFailureServe serves safe requests without a token, so pages
+	// without forms keep working; Token returns an empty string. Unsafe
+	// requests, which cannot carry a matching token, are rejected.
+	IssueFailureServe
+	// IssueFailureError responds with 500 Internal Server Error to all
+	// requests, as for any other server fault.
+	IssueFailureError
+)
+
+// IssueFailure sets how requests are handled if the cookie with a new token
+// cannot be written: IssueFailureReject (the default), IssueFailureServe or
+// IssueFailureError.
+func IssueFailure(p IssueFailurePolicy) Option {
+	return func(cs *csrf) {
+		cs.opts.IssueFailurePolicy = p
+	}
+}
+
+// issueFailed handles r after saving a new token failed with err.
+func (cs *csrf) issueFailed(w http.ResponseWriter, r *http.Request, err error) {
+	err = fmt.Errorf("%w: %w", ErrCookieWrite, err)
+	cs.warnf("%s %s: %v", r.Method, r.URL.Path, err)
+
+	switch {
+	case cs.opts.IssueFailurePolicy == IssueFailureServe && contains(safeMethods, r.Method):
+		cs.annotateSkip(r, SkippedSafeMethod)
+		cs.h.ServeHTTP(w, r)
+	case cs.opts.IssueFailurePolicy == IssueFailureError:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	default:
+		cs.fail(w, r, err)
+	}
+}
@@ -0,0 +1,57 @@
+package csrf
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestIssueFailure tests the handling of requests whose new cookie cannot be
+// written under each policy, and that the failure is logged.
+func TestIssueFailure(t *testing.T) {
+	tests := []struct {
+		policy IssueFailurePolicy
+		method string
+		code   int
+	}{
+		{IssueFailureReject, "GET", http.StatusForbidden},
+		{IssueFailureServe, "GET", http.StatusOK},
+		{IssueFailureServe, "POST", http.StatusForbidden},
+		{IssueFailureError, "GET", http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		var reason error
+		var token string
+		tokenOK := false
+		p := Protect(testKey, setStore(&brokenSaveStore{}), IssueFailure(tt.policy),
+			ErrorLog(log.New(&buf, "", 0)),
+			ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reason = FailureReason(r)
+				w.WriteHeader(http.StatusForbidden)
+			})))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, tokenOK = TokenOK(r)
+		}))
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(tt.method, "/", nil))
+
+		if rr.Code != tt.code {
+			t.Errorf("policy %d %s: got %v want %v", tt.policy, tt.method, rr.Code, tt.code)
+		}
+		if rr.Code == http.StatusForbidden && !errors.Is(reason, ErrCookieWrite) {
+			t.Errorf("policy %d %s: got reason %v want %v", tt.policy, tt.method, reason, ErrCookieWrite)
+		}
+		if rr.Code == http.StatusOK && (token != "" || tokenOK) {
+			t.Errorf("policy %d %s: served with token %q", tt.policy, tt.method, token)
+		}
+		if !strings.Contains(buf.String(), ErrCookieWrite.Error()) {
+			t.Errorf("policy %d %s: failure not logged: %q", tt.policy, tt.method, buf.String())
+		}
+	}
+}
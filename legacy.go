@@ -0,0 +1,33 @@
+package csrf
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isLegacyPath reports whether r is for one of the paths listed with
+// LegacyRequestedWith.
+func (cs *csrf) isLegacyPath(r *http.Request) bool {
+	for _, path := range cs.opts.LegacyPaths {
+		if matchPath(cs.opts.ExcludePathsMode, r.URL.Path, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkRequestedWith accepts a request to a legacy path without a token if
+// it carries "X-Requested-With: XMLHttpRequest", see LegacyRequestedWith.
+func (cs *csrf) checkRequestedWith(r *http.Request) error {
+	if !strings.EqualFold(r.Header.Get("X-Requested-With"), "XMLHttpRequest") {
+		return ErrNoToken
+	}
+
+	cs.logf("transitional X-Requested-With check passed: %s %s", r.Method, r.URL.Path)
+	if o := outcome(r); o != nil {
+		o.Legacy = true
+	}
+
+	return nil
+}
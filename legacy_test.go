@@ -0,0 +1,50 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestLegacyRequestedWith tests that the X-Requested-With header replaces the
+// token on legacy paths only, and that failures there can be report-only.
+func TestLegacyRequestedWith(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		path   string
+		xrw    string
+		code   int
+		legacy bool
+	}{
+		{"legacy with header", nil, "/legacy/save", "XMLHttpRequest", http.StatusOK, true},
+		{"legacy without header", nil, "/legacy/save", "", http.StatusForbidden, false},
+		{"legacy wrong header", nil, "/legacy/save", "fetch", http.StatusForbidden, false},
+		{"other path", nil, "/save", "XMLHttpRequest", http.StatusForbidden, false},
+		{"report-only", []Option{LegacyReportOnly()}, "/legacy/save", "", http.StatusOK, false},
+		{"report-only other path", []Option{LegacyReportOnly()}, "/save", "", http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Protect(testKey, append([]Option{LegacyRequestedWith("/legacy/")}, tt.opts...)...)(testHandler)
+
+			rr := httptest.NewRecorder()
+			p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+			r := httptest.NewRequest("POST", tt.path, nil)
+			setCookie(rr, r)
+			if tt.xrw != "" {
+				r.Header.Set("X-Requested-With", tt.xrw)
+			}
+			r, o := WithOutcome(r)
+
+			rr = httptest.NewRecorder()
+			p.ServeHTTP(rr, r)
+
+			if rr.Code != tt.code || o.Legacy != tt.legacy {
+				t.Fatalf("got %v, legacy %v want %v, legacy %v", rr.Code, o.Legacy, tt.code, tt.legacy)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package csrf
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultAgeBuckets are the upper bounds of the token age buckets used by
+// NewTokenMetrics if none are given.
+var DefaultAgeBuckets = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	4 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+}
+
+// errNotTimed is returned by a store that does not record when tokens were
+// issued.
+var errNotTimed = errors.New("token issue time not recorded")
+
+// issuer is implemented by stores that record when a token was issued.
+type issuer interface {
+	// Issued returns the time the token in the session was issued.
+	Issued(r *http.Request) (time.Time, error)
+}
+
+// TokenMetrics collects metrics on successfully verified tokens: the
+// distribution of their ages, i.e. how long users keep forms open. It is safe
+// for concurrent use, and implements expvar.Var so it can be published with
+// expvar.Publish.
+//
+// Token ages are only known if the store embeds the issue time in the token,
+// i.e. the cookie store with IdleTimeout or AbsoluteTimeout set; other tokens
+// are counted as of unknown age.
+type TokenMetrics struct {
+	buckets []time.Duration
+
+	mu      sync.Mutex
+	counts  []uint64
+	unknown uint64
+}
+
+// NewTokenMetrics returns TokenMetrics with the given age bucket upper bounds,
+// or DefaultAgeBuckets if none are given.
+func NewTokenMetrics(buckets ...time.Duration) *TokenMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultAgeBuckets
+	}
+	buckets = append([]time.Duration(nil), buckets...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	return &TokenMetrics{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// TokenMetricsSnapshot is a point-in-time copy of TokenMetrics.
+type TokenMetricsSnapshot struct {
+	// Buckets are the upper bounds of the age buckets.
+	Buckets []time.Duration
+	// Counts holds the number of tokens per age bucket, with an additional
+	// last entry for tokens older than the last bucket.
+	Counts []uint64
+	// Unknown is the number of tokens of unknown age.
+	Unknown uint64
+}
+
+// Snapshot returns a copy of the current metrics.
+func (m *TokenMetrics) Snapshot() TokenMetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return TokenMetricsSnapshot{
+		Buckets: append([]time.Duration(nil), m.buckets...),
+		Counts:  append([]uint64(nil), m.counts...),
+		Unknown: m.unknown,
+	}
+}
+
+// String returns the metrics as JSON, with the age buckets keyed by their
+// upper bound in seconds, e.g. {"age":{"60":3,...,"+Inf":0},...}.
+func (m *TokenMetrics) String() string {
+	s := m.Snapshot()
+
+	age := make(map[string]uint64, len(s.Counts))
+	for i, n := range s.Counts {
+		le := "+Inf"
+		if i < len(s.Buckets) {
+			le = strconv.FormatFloat(s.Buckets[i].Seconds(), 'f', -1, 64)
+		}
+		age[le] = n
+	}
+
+	b, _ := json.Marshal(struct {
+		Age     map[string]uint64 `json:"age"`
+		Unknown uint64            `json:"age_unknown"`
+	}{age, s.Unknown})
+
+	return string(b)
+}
+
+// observe records a verified token of the given age.
+func (m *TokenMetrics) observe(age time.Duration, known bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !known {
+		m.unknown++
+		return
+	}
+
+	i := sort.Search(len(m.buckets), func(i int) bool { return age <= m.buckets[i] })
+	m.counts[i]++
+}
+
+// observeToken records the age of the verified token of r in the configured
+// metrics and the Outcome of r, if any.
+func (cs *csrf) observeToken(r *http.Request) {
+	o := outcome(r)
+	if cs.opts.TokenMetrics == nil && o == nil {
+		return
+	}
+
+	age, known := cs.tokenAge(r)
+	if o != nil && known {
+		o.TokenAge = age
+	}
+	if cs.opts.TokenMetrics != nil {
+		cs.opts.TokenMetrics.observe(age, known)
+	}
+}
+
+// tokenAge returns the age of the token of r, if the store records it.
+func (cs *csrf) tokenAge(r *http.Request) (time.Duration, bool) {
+	is, ok := cs.st.(issuer)
+	if !ok {
+		return 0, false
+	}
+
+	issued, err := is.Issued(r)
+	if err != nil {
+		return 0, false
+	}
+
+	return timeNow().Sub(issued), true
+}
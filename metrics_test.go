@@ -0,0 +1,96 @@
+package csrf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+// TestTokenMetrics tests that verified tokens are recorded by age.
+func TestTokenMetrics(t *testing.T) {
+	start := time.Now()
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	m := NewTokenMetrics(time.Minute, time.Hour)
+
+	var token string
+	p := Protect(testKey, IdleTimeout(24*time.Hour), CollectTokenMetrics(m))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token = Token(r)
+		}))
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := httptest.NewRecorder()
+	p.ServeHTTP(cookies, r)
+
+	for _, age := range []time.Duration{30 * time.Second, 10 * time.Minute, 2 * time.Hour} {
+		timeNow = func() time.Time { return start.Add(age) }
+
+		r, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setCookie(cookies, r)
+		r.Header.Set("X-CSRF-Token", token)
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("age %v: got %v want %v", age, rr.Code, http.StatusOK)
+		}
+	}
+
+	s := m.Snapshot()
+	if want := []uint64{1, 1, 1}; len(s.Counts) != 3 || s.Counts[0] != want[0] || s.Counts[1] != want[1] || s.Counts[2] != want[2] {
+		t.Errorf("age counts: got %v want %v", s.Counts, want)
+	}
+	if s.Unknown != 0 {
+		t.Errorf("got %d unknown want 0", s.Unknown)
+	}
+
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(m.String()), &v); err != nil {
+		t.Fatalf("String is not JSON: %q", m.String())
+	}
+}
+
+// labelStore records the pprof labels of the request passed to Get.
+type labelStore struct {
+	Store
+	label string
+}
+
+func (ls *labelStore) Get(r *http.Request) ([]byte, error) {
+	ls.label, _ = pprof.Label(r.Context(), "gorilla.csrf")
+	return ls.Store.Get(r)
+}
+
+// TestProfilerLabels tests that the cookie is decoded with pprof labels set.
+func TestProfilerLabels(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		opts := []Option{}
+		if enabled {
+			opts = append(opts, ProfilerLabels())
+		}
+		cs := Protect(testKey, opts...)(testHandler).(*csrf)
+		ls := &labelStore{Store: cs.st}
+		cs.st = ls
+
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs.ServeHTTP(httptest.NewRecorder(), r)
+
+		if want := map[bool]string{true: "decode"}[enabled]; ls.label != want {
+			t.Errorf("enabled %v: got label %q want %q", enabled, ls.label, want)
+		}
+	}
+}
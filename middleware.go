@@ -0,0 +1,138 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrInvalidKey is returned by New if the authentication key has the wrong
+// length.
+var ErrInvalidKey = errors.New("invalid authentication key")
+
+// ErrInsecureSameSite is returned by New if SameSite(SameSiteNoneMode) is set
+// without Secure, as browsers discard such cookies.
+var ErrInsecureSameSite = errors.New("SameSite=None requires Secure")
+
+// ErrPublicSuffixDomain is returned by New if the cookie Domain is a public
+// suffix, e.g. "co.uk" or "appspot.com", as browsers discard such cookies.
+var ErrPublicSuffixDomain = errors.New("cookie domain is a public suffix")
+
+// Middleware is a configured CSRF middleware, as returned by New. A single
+// Middleware can wrap any number of handlers, e.g. several independent routers
+// or muxes, which then share the same key, codec, store and caches. Its
+// VerifyRequest and IssueToken methods check and issue tokens directly, for
+// compositions that wrapping cannot express. It is safe for concurrent use.
+// Call Close on shutdown to flush reports and release the store.
+type Middleware struct {
+	// cs holds the state shared by all wrapped handlers.
+	cs *csrf
+	// closed is set by Close.
+	closed atomic.Bool
+}
+
+// New is like Protect, but validates the authentication key and options
+// up-front and returns an error describing the first problem found, e.g.
+// "gorilla/csrf: invalid authentication key: must be 32 or 64 bytes, got 20".
+func New(authKey []byte, opts ...Option) (*Middleware, error) {
+	cs := parseOptions(nil, opts...)
+	if err := cs.setup(authKey, true); err != nil {
+		return nil, err
+	}
+
+	m := &Middleware{cs: cs}
+	if err := m.cs.checkCookieSize(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Wrap returns h wrapped with CSRF protection. Wrap has the signature of a
+// middleware function, so m.Wrap can be passed to e.g. mux.Router.Use.
+func (m *Middleware) Wrap(h http.Handler) http.Handler {
+	return m.cs.wrap(h)
+}
+
+// Describe returns a redacted, human-readable dump of the configuration.
+func (m *Middleware) Describe() string {
+	return m.cs.Describe()
+}
+
+// GenerateKey returns a new random 32 byte authentication key for Protect and
+// New. Store it securely and load it on startup: a key that changes between
+// restarts (or differs between instances) invalidates all issued tokens.
+//
+// The csrfkeygen command prints such a key, encoded as base64 or hex.
+func GenerateKey() ([]byte, error) {
+	return generateRandomBytes(32)
+}
+
+// checkKey validates the length of the authentication key. Keys of the wrong
+// length are stretched into a 32 byte key if derive is set, and rejected
+// otherwise.
+func checkKey(authKey []byte, derive bool) ([]byte, error) {
+	switch {
+	case len(authKey) == 32 || len(authKey) == 64:
+		return authKey, nil
+	case derive && len(authKey) > 0:
+		return deriveKey(authKey), nil
+	}
+
+	return nil, fmt.Errorf("%s%w: must be 32 or 64 bytes, got %d", errorPrefix, ErrInvalidKey, len(authKey))
+}
+
+// checkSameSite validates that cookies with SameSite=None are Secure.
+func checkSameSite(o options) error {
+	if o.SameSite == SameSiteNoneMode && !o.Secure {
+		return fmt.Errorf("%s%w: set Secure(true) or another SameSite mode", errorPrefix, ErrInsecureSameSite)
+	}
+
+	return nil
+}
+
+// checkDomain validates that the cookie domain is not a public suffix.
+func checkDomain(o options) error {
+	if publicSuffixDomain(o.Domain) {
+		return fmt.Errorf("%s%w: %q, use a registrable domain such as \"example.%s\"",
+			errorPrefix, ErrPublicSuffixDomain, o.Domain, strings.TrimPrefix(o.Domain, "."))
+	}
+
+	return nil
+}
+
+// deriveKey derives a 32 byte key from secret with HKDF-SHA256 (RFC 5869).
+// Note that this does not add entropy: a weak secret remains weak.
+func deriveKey(secret []byte) []byte {
+	return hkdfSHA256(secret, "authentication key")
+}
+
+// hkdfSHA256 derives a 32 byte key for the given purpose from secret with
+// HKDF-SHA256 (RFC 5869).
+func hkdfSHA256(secret []byte, info string) []byte {
+	extract := hmac.New(sha256.New, []byte("gorilla/csrf"))
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write([]byte(info))
+	expand.Write([]byte{1})
+
+	return expand.Sum(nil)
+}
+
+// warnf logs a message to the configured ErrorLog, or the standard logger if
+// none is set. It is used for problems that must not go unnoticed.
+func (cs *csrf) warnf(format string, args ...interface{}) {
+	if cs.opts.ErrorLog != nil {
+		cs.opts.ErrorLog.Printf(errorPrefix+format, args...)
+		return
+	}
+
+	log.Printf(errorPrefix+format, args...)
+}
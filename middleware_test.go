@@ -0,0 +1,217 @@
+package csrf
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewKeyLength tests that New rejects keys of the wrong length with a
+// precise error, unless key derivation is allowed.
+func TestNewKeyLength(t *testing.T) {
+	_, err := New([]byte("32-byte-long-auth-key"))
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("short key not rejected: got %v want %v", err, ErrInvalidKey)
+	}
+	if !strings.Contains(err.Error(), "got 21") {
+		t.Fatalf("error does not report the key length: got %q", err)
+	}
+
+	if _, err := New(testKey); err != nil {
+		t.Fatalf("valid key rejected: got %v", err)
+	}
+
+	m, err := New([]byte("32-byte-long-auth-key"), DeriveKey())
+	if err != nil {
+		t.Fatalf("key derivation not applied: got %v", err)
+	}
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	m.Wrap(testHandler).ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Set-Cookie") == "" {
+		t.Fatalf("middleware with derived key failed: got %v", rr.Code)
+	}
+}
+
+// TestNewInvalidPattern tests that New returns an error for an invalid
+// exclusion pattern instead of panicking, and that Protect panics.
+func TestNewInvalidPattern(t *testing.T) {
+	if _, err := New(testKey, ExcludePatterns("/a/{id")); err == nil {
+		t.Fatal("invalid pattern not rejected")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Protect did not panic on an invalid pattern")
+		}
+	}()
+	Protect(testKey, ExcludePatterns("/a/{id"))(testHandler)
+}
+
+// TestNewCookieSize tests that New rejects options under which the CSRF cookie
+// would exceed the size limit of browsers, and that Protect logs it.
+func TestNewCookieSize(t *testing.T) {
+	long := Path("/" + strings.Repeat("a", maxCookieSize))
+
+	if _, err := New(testKey, long); !errors.Is(err, ErrCookieTooLarge) {
+		t.Fatalf("oversized cookie not rejected: got %v want %v", err, ErrCookieTooLarge)
+	}
+
+	if _, err := New(testKey, Path("/app"), Domain("example.com"), IdleTimeout(time.Hour)); err != nil {
+		t.Fatalf("regular cookie rejected: got %v", err)
+	}
+
+	var buf bytes.Buffer
+	Protect(testKey, long, ErrorLog(log.New(&buf, "", 0)))(testHandler)
+
+	if !strings.Contains(buf.String(), ErrCookieTooLarge.Error()) {
+		t.Fatalf("oversized cookie not logged: got %q", buf.String())
+	}
+}
+
+// TestNewSameSiteNone tests that New rejects SameSite=None without Secure, and
+// that Protect forces Secure instead.
+func TestNewSameSiteNone(t *testing.T) {
+	if _, err := New(testKey, SameSite(SameSiteNoneMode), Secure(false)); !errors.Is(err, ErrInsecureSameSite) {
+		t.Fatalf("insecure SameSite=None not rejected: got %v want %v", err, ErrInsecureSameSite)
+	}
+
+	if _, err := New(testKey, SameSite(SameSiteNoneMode)); err != nil {
+		t.Fatalf("secure SameSite=None rejected: got %v", err)
+	}
+
+	var buf bytes.Buffer
+	p := Protect(testKey, SameSite(SameSiteNoneMode), Secure(false), ErrorLog(log.New(&buf, "", 0)))(testHandler)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if cookie := rr.Header().Get("Set-Cookie"); !strings.Contains(cookie, "; Secure") {
+		t.Fatalf("Secure not forced: got %q", cookie)
+	}
+	if !strings.Contains(buf.String(), ErrInsecureSameSite.Error()) {
+		t.Fatalf("insecure SameSite=None not logged: got %q", buf.String())
+	}
+}
+
+// TestMiddlewareShared tests that handlers wrapped by the same Middleware share
+// its state, so that a token issued by one is accepted by the other.
+func TestMiddlewareShared(t *testing.T) {
+	m, err := New(testKey, CacheTrustedOrigins(time.Minute, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var token string
+	a := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	}))
+	b := m.Wrap(testHandler)
+
+	csA, csB := a.(*csrf), b.(*csrf)
+	if csA.sc != csB.sc || csA.st != csB.st || csA.origins != csB.origins {
+		t.Fatal("wrapped handlers do not share state")
+	}
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	a.ServeHTTP(rr, r)
+
+	r, err = http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setCookie(rr, r)
+	r.Header.Set("X-CSRF-Token", token)
+
+	rr = httptest.NewRecorder()
+	b.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("token not accepted by sibling handler: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+// TestMiddlewarePrecompute tests that origins and exclusion patterns are
+// compiled once by New, and that the wrapped handlers reuse them along with
+// the codec and store.
+func TestMiddlewarePrecompute(t *testing.T) {
+	m, err := New(testKey,
+		TrustedOrigins([]string{"https://München.example", "*.example.com:8443"}),
+		ExcludePatterns("POST /hooks/{id}"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []originPattern{
+		{scheme: "https", host: "xn--mnchen-3ya.example"},
+		{host: "example.com", port: "8443", wildcard: true},
+	}
+	if !reflect.DeepEqual(m.cs.trusted, want) {
+		t.Fatalf("trusted origins not compiled: got %+v want %+v", m.cs.trusted, want)
+	}
+
+	h := m.Wrap(testHandler).(*csrf)
+	if h.excluded != m.cs.excluded || &h.trusted[0] != &m.cs.trusted[0] {
+		t.Fatal("wrapped handler does not reuse the compiled matchers")
+	}
+	if h.sc != m.cs.sc || h.st != m.cs.st {
+		t.Fatal("wrapped handler does not reuse the codec and store")
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	a, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != 32 || bytes.Equal(a, b) {
+		t.Fatalf("GenerateKey: got %x, %x", a, b)
+	}
+
+	if _, err := New(a); err != nil {
+		t.Fatalf("generated key rejected: %v", err)
+	}
+}
+
+// TestNewPublicSuffixDomain tests that New rejects cookie domains that are
+// public suffixes, and accepts registrable domains below them.
+func TestNewPublicSuffixDomain(t *testing.T) {
+	for _, domain := range []string{"co.uk", ".CO.UK", "appspot.com", "com"} {
+		if _, err := New(testKey, Domain(domain)); !errors.Is(err, ErrPublicSuffixDomain) {
+			t.Errorf("public suffix %q not rejected: got %v", domain, err)
+		}
+	}
+
+	if _, err := New(testKey, CrossSubdomain("github.io")); !errors.Is(err, ErrPublicSuffixDomain) {
+		t.Errorf("public suffix parent not rejected: got %v", err)
+	}
+
+	for _, domain := range []string{"example.co.uk", "my-app.appspot.com", ".example.com", "localhost"} {
+		if _, err := New(testKey, Domain(domain)); err != nil {
+			t.Errorf("domain %q rejected: got %v", domain, err)
+		}
+	}
+}
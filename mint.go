@@ -0,0 +1,44 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Mint creates a new real token out-of-band and returns a masked token along
+// with the cookie that carries the real token, as the middleware would issue
+// them.
+//
+// It is intended for trusted internal jobs that replay user actions through
+// the web tier: send the cookie with the request and the masked token in the
+// request header or form field. Keep both secret; they are equivalent to a
+// browser's CSRF state.
+func (m *Middleware) Mint() (string, *http.Cookie, error) {
+	cs := m.cs
+
+	realToken, err := cs.generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Stores that need a request (e.g. SessionStore) start a new session.
+	// The request is marked as made up, so that the cookie gets Path rather
+	// than the path PathFunc would return for "/".
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		return "", nil, err
+	}
+	r = contextSave(r, syntheticKey, true)
+
+	hw := &headerWriter{header: http.Header{}}
+	if err := cs.save(r, realToken, hw); err != nil {
+		return "", nil, err
+	}
+
+	cookies := (&http.Response{Header: hw.header}).Cookies()
+	if len(cookies) == 0 {
+		return "", nil, errors.New(errorPrefix + "store did not issue a cookie")
+	}
+
+	return cs.mask(realToken, nil), cookies[len(cookies)-1], nil
+}
@@ -0,0 +1,87 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestMint tests that a minted token and cookie pair passes validation.
+func TestMint(t *testing.T) {
+	m, err := New(testKey, CookieName("_jobs_csrf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, cookie, err := m.Mint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cookie.Name != "_jobs_csrf" {
+		t.Fatalf("minted cookie ignores options: got %q want %q", cookie.Name, "_jobs_csrf")
+	}
+
+	p := m.Wrap(testHandler)
+
+	r, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(cookie)
+	r.Header.Set("X-CSRF-Token", token)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("minted token rejected: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+// TestMintPathFunc tests that a minted cookie gets Path, not the path PathFunc
+// returns for a made-up request.
+func TestMintPathFunc(t *testing.T) {
+	m, err := New(testKey, Path("/jobs"), PathFunc(func(r *http.Request) string {
+		return "/app" + r.URL.Path
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cookie, err := m.Mint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cookie.Path != "/jobs" {
+		t.Fatalf("minted cookie path: got %q want %q", cookie.Path, "/jobs")
+	}
+}
+
+// TestIssueToken tests that tokens issued outside of the middleware are
+// accepted by a middleware with the same key and options.
+func TestIssueToken(t *testing.T) {
+	m, err := New(testKey, CookieName("login"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	token, err := m.IssueToken(rr, httptest.NewRequest("GET", "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Set-Cookie"), "login=") {
+		t.Fatalf("cookie not issued: got %q", rr.Header().Get("Set-Cookie"))
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	setCookie(rr, r)
+	r.Header.Set("X-CSRF-Token", token)
+
+	post := httptest.NewRecorder()
+	Protect(testKey, CookieName("login"))(testHandler).ServeHTTP(post, r)
+	if post.Code != http.StatusOK {
+		t.Fatalf("issued token rejected: got %v", post.Code)
+	}
+}
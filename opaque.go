@@ -0,0 +1,250 @@
+package csrf
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenBackend persists real CSRF tokens server-side, keyed by an opaque
+// session ID, for use with OpaqueTokens. Implementations must be safe for
+// concurrent use, and should wrap connection and I/O errors with
+// ErrStoreUnavailable.
+type TokenBackend interface {
+	// Load returns the sealed token stored for id, or nil if there is none.
+	Load(ctx context.Context, id string) ([]byte, error)
+	// Store stores the sealed token for id. A positive ttl is the time after
+	// which the token may be discarded.
+	Store(ctx context.Context, id string, sealed []byte, ttl time.Duration) error
+}
+
+// errUnknownID is returned if the backend holds no token for the session ID.
+var errUnknownID = errors.New("CSRF session ID unknown")
+
+// opaqueIDLength is the length of the random session IDs in bytes.
+const opaqueIDLength = 32
+
+// opaqueMACLength is the length of the MAC of the session ID in the cookie in
+// bytes.
+const opaqueMACLength = 16
+
+// opaqueStore keeps the real token in a TokenBackend, encrypted with a key
+// derived from the authentication key, and only a random session ID in the
+// cookie, authenticated with a MAC.
+type opaqueStore struct {
+	backend TokenBackend
+	aead    cipher.AEAD
+	macKey  []byte
+	// cookies writes the ID cookie with the configured cookie options.
+	cookies *cookieStore
+}
+
+// newOpaqueStore returns an opaqueStore that encrypts tokens with AES-GCM
+// and authenticates session IDs with HMAC-SHA256 under keys derived from the
+// authentication key.
+func newOpaqueStore(backend TokenBackend, authKey []byte, cookies *cookieStore) *opaqueStore {
+	block, err := aes.NewCipher(hkdfSHA256(authKey, "token encryption"))
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &opaqueStore{
+		backend: backend,
+		aead:    aead,
+		macKey:  hkdfSHA256(authKey, "session id"),
+		cookies: cookies,
+	}
+}
+
+// Get loads and decrypts the token for the session ID in the cookie.
+func (ost *opaqueStore) Get(r *http.Request) ([]byte, error) {
+	id, err := ost.cookieID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return ost.load(r.Context(), id)
+}
+
+// load loads and decrypts the token stored for the session ID.
+func (ost *opaqueStore) load(ctx context.Context, id string) ([]byte, error) {
+	sealed, err := ost.backend.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sealed == nil {
+		return nil, errUnknownID
+	}
+
+	return ost.open(id, sealed)
+}
+
+// Save stores the token under a new session ID.
+func (ost *opaqueStore) Save(token []byte, w http.ResponseWriter) error {
+	return ost.SaveRequest(nil, token, w)
+}
+
+// SaveRequest stores the token under the session ID of r, if the backend
+// holds a token for it, so that the ID of a client remains stable, or under a
+// new session ID otherwise. IDs are only ever created server-side, so that a
+// client cannot choose its ID, e.g. one planted by another site.
+func (ost *opaqueStore) SaveRequest(r *http.Request, token []byte, w http.ResponseWriter) error {
+	ctx := context.Background()
+	var id string
+	if r != nil {
+		ctx = r.Context()
+		if cid, err := ost.cookieID(r); err == nil {
+			if _, err := ost.load(ctx, cid); err == nil {
+				id = cid
+			}
+		}
+	}
+
+	if id == "" {
+		b, err := generateRandomBytes(opaqueIDLength)
+		if err != nil {
+			return err
+		}
+		id = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	sealed, err := ost.seal(id, token)
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Duration(ost.cookies.maxAge) * time.Second
+	if err := ost.backend.Store(ctx, id, sealed, ttl); err != nil {
+		return err
+	}
+
+	ost.cookies.setCookie(r, ost.cookieValue(id), w)
+
+	return nil
+}
+
+// cookieValue returns the cookie value of the session ID: the ID and its MAC.
+func (ost *opaqueStore) cookieValue(id string) string {
+	return id + "." + base64.RawURLEncoding.EncodeToString(ost.mac(id))
+}
+
+// cookieID returns the session ID in the cookie of r, if its MAC is valid.
+func (ost *opaqueStore) cookieID(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(ost.cookies.name)
+	if err != nil {
+		return "", err
+	}
+
+	id, encodedMAC, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !validOpaqueID(id) {
+		return "", errUnknownID
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, ost.mac(id)) {
+		return "", errUnknownID
+	}
+
+	return id, nil
+}
+
+// mac returns the truncated HMAC-SHA256 of the session ID.
+func (ost *opaqueStore) mac(id string) []byte {
+	h := hmac.New(sha256.New, ost.macKey)
+	h.Write([]byte(id))
+	return h.Sum(nil)[:opaqueMACLength]
+}
+
+// seal encrypts token, binding it to the session ID.
+func (ost *opaqueStore) seal(id string, token []byte) ([]byte, error) {
+	nonce, err := generateRandomBytes(ost.aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	return ost.aead.Seal(nonce, nonce, token, []byte(id)), nil
+}
+
+// open decrypts a sealed token stored for the session ID.
+func (ost *opaqueStore) open(id string, sealed []byte) ([]byte, error) {
+	n := ost.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errUnknownID
+	}
+
+	token, err := ost.aead.Open(nil, sealed[:n], sealed[n:], []byte(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// validOpaqueID reports whether id has the form of a session ID issued by an
+// opaqueStore, so that arbitrary cookie values are never used as backend keys.
+func validOpaqueID(id string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(id)
+	return err == nil && len(b) == opaqueIDLength
+}
+
+// OpaqueID returns the session ID of the CSRF cookie of r with OpaqueTokens,
+// e.g. to record the IDs of a user at login, so that their tokens can be
+// invalidated with Invalidate. It returns false if r has no valid cookie or
+// OpaqueTokens is not set.
+func (m *Middleware) OpaqueID(r *http.Request) (string, bool) {
+	ost := m.cs.opaqueStore(r)
+	if ost == nil {
+		return "", false
+	}
+
+	id, err := ost.cookieID(r)
+	return id, err == nil
+}
+
+// Invalidate instantly invalidates the tokens stored for the session IDs with
+// OpaqueTokens, e.g. all IDs recorded for a user (see OpaqueID) on logout or
+// a password change. Unsafe requests with the tokens fail, and the clients
+// are issued a new ID and token with their next request.
+func (m *Middleware) Invalidate(ctx context.Context, ids ...string) error {
+	backend := m.cs.opts.TokenBackend
+	if backend == nil {
+		return errors.New(errorPrefix + "Invalidate requires OpaqueTokens")
+	}
+
+	// Overwrite the sealed tokens, as TokenBackend has no delete: an empty
+	// value does not decrypt, like an unknown ID.
+	ttl := time.Duration(m.cs.opts.MaxAge) * time.Second
+	var errs []error
+	for _, id := range ids {
+		if validOpaqueID(id) {
+			errs = append(errs, backend.Store(ctx, id, []byte{}, ttl))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// opaqueStore returns the opaqueStore for r, or nil if OpaqueTokens is not
+// set.
+func (cs *csrf) opaqueStore(r *http.Request) *opaqueStore {
+	st := cs.st
+	if gs, ok := st.(*guardedStore); ok {
+		st = gs.st
+	}
+	if ss, ok := st.(*splitStore); ok {
+		st = ss.store(r)
+	}
+
+	ost, _ := st.(*opaqueStore)
+	return ost
+}
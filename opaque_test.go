@@ -0,0 +1,131 @@
+package csrf
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryBackend is a TokenBackend for tests.
+type memoryBackend struct {
+	mu     sync.Mutex
+	tokens map[string][]byte
+}
+
+func (mb *memoryBackend) Load(ctx context.Context, id string) ([]byte, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.tokens[id], nil
+}
+
+func (mb *memoryBackend) Store(ctx context.Context, id string, sealed []byte, ttl time.Duration) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.tokens[id] = sealed
+	return nil
+}
+
+// TestOpaqueTokens tests that only a signed session ID is sent in the cookie,
+// that tokens are verified against the backend, and that invalidating the ID
+// invalidates the token.
+func TestOpaqueTokens(t *testing.T) {
+	backend := &memoryBackend{tokens: map[string][]byte{}}
+
+	m, err := New(testKey, OpaqueTokens(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var token string
+	p := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	}))
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || len(cookies[0].Value) != 66 {
+		t.Fatalf("cookie does not hold a signed session ID: got %v", cookies)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	id, ok := m.OpaqueID(r)
+	if !ok || !strings.HasPrefix(cookies[0].Value, id+".") {
+		t.Fatalf("got session ID %q, %v for cookie %q", id, ok, cookies[0].Value)
+	}
+
+	sealed := backend.tokens[id]
+	if sealed == nil {
+		t.Fatal("token not stored in the backend")
+	}
+
+	post := func() int {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set(headerName, token)
+		setCookie(rr, r)
+
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, r)
+		return res.Code
+	}
+
+	if code := post(); code != http.StatusOK {
+		t.Fatalf("valid token rejected: got %v", code)
+	}
+	if backend.tokens[id] == nil || len(backend.tokens) != 1 {
+		t.Fatalf("session ID not kept: got %d IDs", len(backend.tokens))
+	}
+
+	// A sealed token does not decrypt for another ID.
+	other := strings.Repeat("A", 43)
+	backend.tokens[other] = sealed
+	cs, err := newCSRF(testKey, nil, OpaqueTokens(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: cs.opaqueStore(r).cookieValue(other)})
+	if _, err := cs.st.Get(r); err == nil {
+		t.Fatal("token decrypted for another session ID")
+	}
+
+	if err := m.Invalidate(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if code := post(); code != http.StatusForbidden {
+		t.Fatalf("invalidated token accepted: got %v", code)
+	}
+}
+
+// TestOpaqueTokensFixation tests that a client cannot choose its session ID:
+// unsigned IDs and IDs unknown to the backend are replaced by new ones.
+func TestOpaqueTokensFixation(t *testing.T) {
+	backend := &memoryBackend{tokens: map[string][]byte{}}
+	p := Protect(testKey, OpaqueTokens(backend))(testHandler)
+	cs := p.(*csrf)
+
+	planted := strings.Repeat("B", 43)
+	for name, value := range map[string]string{
+		"unsigned":   planted,
+		"forged MAC": planted + ".AAAAAAAAAAAAAAAAAAAAAA",
+		"unknown":    cs.opaqueStore(httptest.NewRequest("GET", "/", nil)).cookieValue(planted),
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: cookieName, Value: value})
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 || strings.HasPrefix(cookies[0].Value, planted) {
+			t.Errorf("%s: planted session ID kept: got %v", name, cookies)
+		}
+		if backend.tokens[planted] != nil {
+			t.Errorf("%s: token stored for the planted session ID", name)
+		}
+	}
+}
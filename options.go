@@ -1,8 +1,14 @@
 package csrf
 
 import (
+	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
 )
 
 // Option describes a functional option for configuring the CSRF handler.
@@ -17,18 +23,58 @@ func MaxAge(age int) Option {
 	}
 }
 
+// IdleTimeout sets the maximum time a CSRF token may go unused. Each
+// successfully verified request refreshes the timeout. The timestamps are
+// embedded in the (authenticated) cookie. Defaults to 0 (no idle timeout).
+func IdleTimeout(d time.Duration) Option {
+	return func(cs *csrf) {
+		cs.opts.IdleTimeout = d
+	}
+}
+
+// AbsoluteTimeout sets the maximum lifetime of a CSRF token from the time it
+// was issued, regardless of use. Unlike MaxAge, it is not extended when the
+// cookie is re-issued to refresh an IdleTimeout. Defaults to 0 (no absolute
+// timeout beyond MaxAge).
+func AbsoluteTimeout(d time.Duration) Option {
+	return func(cs *csrf) {
+		cs.opts.AbsoluteTimeout = d
+	}
+}
+
 // Domain sets the cookie domain. Defaults to the current domain of the request
 // only (recommended).
 //
 // This should be a hostname and not a URL. If set, the domain is treated as
 // being prefixed with a '.' - e.g. "example.com" becomes ".example.com" and
 // matches "www.example.com" and "secure.example.com".
+//
+// Browsers discard cookies for a public suffix, e.g. "co.uk" or
+// "appspot.com", so that no token could ever be verified: New rejects such
+// domains with ErrPublicSuffixDomain, and Protect logs a warning. Likewise,
+// cookies for a domain that does not cover the request host are rejected:
+// such mismatches are logged once per host, see StrictDomain.
 func Domain(domain string) Option {
 	return func(cs *csrf) {
 		cs.opts.Domain = domain
 	}
 }
 
+// CrossSubdomain shares the CSRF token across all subdomains of the parent
+// domain, e.g. "example.com", for products that span several subdomains with a
+// shared login. The cookie Domain is set to the parent domain, and Referers
+// from the parent domain and any of its subdomains are trusted, so a form on
+// account.example.com can post to app.example.com. DeniedOrigins still apply.
+//
+// Only use this if you control every subdomain of the parent domain: any of
+// them can read the token and make requests on behalf of the user.
+func CrossSubdomain(parent string) Option {
+	return func(cs *csrf) {
+		cs.opts.Domain = parent
+		cs.opts.CrossSubdomain = parent
+	}
+}
+
 // Path sets the cookie path. Defaults to the path the cookie was issued from
 // (recommended).
 //
@@ -41,6 +87,24 @@ func Path(p string) Option {
 	}
 }
 
+// PathFunc sets the cookie path per request, e.g. to the sub-application
+// handling the request, so that several applications under one host (e.g.
+// "/app1" and "/app2") get their own CSRF cookie instead of sharing one:
+//
+//	csrf.PathFunc(func(r *http.Request) string {
+//		return "/" + strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+//	})
+//
+// It overrides Path for cookies issued while serving a request; cookies
+// issued without one, e.g. by Mint, use Path. The path must cover all pages
+// that submit to the sub-application, as browsers only send the cookie below
+// it. Defaults to nil.
+func PathFunc(fn func(r *http.Request) string) Option {
+	return func(cs *csrf) {
+		cs.opts.PathFunc = fn
+	}
+}
+
 // ExcludePaths sets the prefixes of paths that are excluded from CSRF protection.
 // Defaults to empty.
 func ExcludePaths(paths ...string) Option {
@@ -49,6 +113,181 @@ func ExcludePaths(paths ...string) Option {
 	}
 }
 
+// ExcludePathsMode sets how the ExcludePaths entries are matched against the
+// request path. Defaults to PathMatchPrefix.
+func ExcludePathsMode(mode PathMatchMode) Option {
+	return func(cs *csrf) {
+		cs.opts.ExcludePathsMode = mode
+	}
+}
+
+// Webhook verifies unsafe requests to path, matched like ExcludePaths, by
+// the provider signature checked by v instead of a CSRF token, e.g.
+// HMACSignature for GitHub or TimestampedSignature for Stripe. This keeps
+// webhook endpoints protected instead of excluding them altogether. Requests
+// with an invalid signature fail with ErrBadWebhookSignature. The body is
+// read for verification, up to MaxBodySize (1 MB if unset), and passed on to
+// the handler.
+func Webhook(path string, v WebhookVerifier) Option {
+	return func(cs *csrf) {
+		cs.opts.Webhooks = append(cs.opts.Webhooks[:len(cs.opts.Webhooks):len(cs.opts.Webhooks)], webhook{path: path, verifier: v})
+	}
+}
+
+// ExcludePatterns sets Go 1.22 http.ServeMux patterns - e.g.
+// "POST /api/webhooks/{id}" - of requests that are excluded from CSRF
+// protection. Patterns are matched with the same method, host and wildcard
+// semantics as http.ServeMux. Defaults to empty.
+//
+// Protect panics if a pattern is invalid or conflicts with another pattern,
+// just like http.ServeMux.Handle does; New returns an error instead.
+func ExcludePatterns(patterns ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.ExcludePatterns = patterns
+	}
+}
+
+// ExcludeRoutes sets the names of gorilla/mux routes that are excluded from
+// CSRF protection, so exclusions stay correct when the route paths change.
+// Defaults to empty.
+//
+// The route is resolved via mux.CurrentRoute if the middleware is installed
+// with router.Use, and by matching the request against router otherwise.
+func ExcludeRoutes(router *mux.Router, names ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.ExcludeRoutes = &routeMatcher{router: router, names: names}
+	}
+}
+
+// ExcludeMatchers excludes requests that match one of matchers from CSRF
+// protection, e.g. matchers of a router or a radix tree of paths:
+//
+//	csrf.ExcludeMatchers(csrf.PathMatcherFunc(func(r *http.Request) bool {
+//		return strings.HasSuffix(r.URL.Path, "/callback")
+//	}))
+//
+// Defaults to empty.
+func ExcludeMatchers(matchers ...PathMatcher) Option {
+	return func(cs *csrf) {
+		cs.opts.ExcludeMatchers = matchers
+	}
+}
+
+// OnlyUnder restricts all CSRF behaviour - token issuance and validation - to
+// the given path subtrees, e.g. OnlyUnder("/app") covers "/app" and "/app/..."
+// but not "/apple". Requests outside of these subtrees are passed to the
+// wrapped handler untouched. Defaults to empty, i.e. all paths.
+func OnlyUnder(prefixes ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.OnlyUnder = prefixes
+	}
+}
+
+// OnlyHosts restricts all CSRF behaviour - token issuance and validation - to
+// requests for the given hosts, e.g. OnlyHosts("www.example.com"). Hosts are
+// compared case-insensitively and without the port. Requests for other hosts
+// are passed to the wrapped handler untouched. Defaults to empty, i.e. all
+// hosts.
+func OnlyHosts(hosts ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.OnlyHosts = hosts
+	}
+}
+
+// OnlyMatching restricts all CSRF behaviour - token issuance and validation -
+// to requests that match one of matchers, like OnlyUnder. Requests that match
+// none of them are passed to the wrapped handler untouched. Defaults to empty,
+// i.e. all requests.
+func OnlyMatching(matchers ...PathMatcher) Option {
+	return func(cs *csrf) {
+		cs.opts.OnlyMatchers = matchers
+	}
+}
+
+// ExpireDuplicateCookies configures the cookie domains ("" for a host-only
+// cookie) to expire when a request carries more than one CSRF cookie, e.g. a
+// stale cookie left behind after changing the Domain option. The configured
+// Domain itself is never expired; the valid cookie is re-issued instead.
+//
+// Requests with duplicate cookies are always handled by trying each cookie in
+// order; this option only controls the clean up. Defaults to empty.
+func ExpireDuplicateCookies(domains ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.DuplicateCookieDomains = domains
+	}
+}
+
+// SkipAuthHeader skips CSRF protection for requests that carry the given
+// authentication header with one of the given schemes, e.g.
+// SkipAuthHeader("Authorization", "Bearer"). Browsers never attach such headers
+// to cross-site requests on their own, so these requests are immune to CSRF.
+// If no schemes are given, "Bearer" is used. Defaults to disabled.
+//
+// Do not accept "Basic" (or other schemes that browsers cache and send
+// automatically): those credentials are ambient and remain CSRF-able.
+func SkipAuthHeader(header string, schemes ...string) Option {
+	return func(cs *csrf) {
+		if len(schemes) == 0 {
+			schemes = []string{"Bearer"}
+		}
+		cs.opts.AuthHeader = header
+		cs.opts.AuthSchemes = schemes
+	}
+}
+
+// SkipClientCerts skips CSRF protection for requests that present a verified
+// TLS client certificate with one of the given subject alternative names (DNS
+// names, email addresses, IP addresses or URIs), e.g. for internal
+// service-to-service calls. The server must be configured to verify client
+// certificates (tls.Config.ClientAuth); unverified certificates are ignored.
+// Defaults to empty.
+func SkipClientCerts(sans ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.ClientCertSANs = sans
+	}
+}
+
+// Cookieless sets the policy for unsafe requests that carry no cookies at all:
+// CookielessReject (the default), CookielessSkip or
+// CookielessSkipUncredentialed. Skipping is safe for endpoints that never
+// authenticate via cookies - a request without cookies carries no ambient
+// credentials to abuse - and stops spurious 403s for API clients such as curl
+// on hybrid web and API endpoints.
+//
+// For pure API subtrees whose clients may also authenticate with Basic auth
+// or client certificates, use CookielessSkipUncredentialed, which only skips
+// requests without any credentials a browser could attach on its own, and
+// scope it with e.g. OnlyUnder("/api/").
+func Cookieless(p CookielessPolicy) Option {
+	return func(cs *csrf) {
+		cs.opts.CookielessPolicy = p
+	}
+}
+
+// BadCookie sets the policy for unsafe requests whose CSRF cookie cannot be
+// decoded: BadCookieRotate (the default), BadCookieReject,
+// BadCookieRotateReject or BadCookieReportOnly.
+func BadCookie(p BadCookiePolicy) Option {
+	return func(cs *csrf) {
+		cs.opts.BadCookiePolicy = p
+	}
+}
+
+// RefreshOnFailure attaches a fresh masked token to rejected requests, in the
+// response header named by RequestHeader and - for JSON requests handled by
+// the default error handler - in the "token" field of the response body. A
+// new cookie is issued if the request has no valid one. Defaults to false.
+//
+// This lets a front-end interceptor transparently retry a request that failed
+// because of a stale token. A rejected request never reaches the handler, so
+// it is safe to retry; the retry must set RetryHeader, and is not refreshed
+// again if it fails, so that a client retries at most once.
+func RefreshOnFailure() Option {
+	return func(cs *csrf) {
+		cs.opts.RefreshOnFailure = true
+	}
+}
+
 // Secure sets the 'Secure' flag on the cookie. Defaults to true (recommended).
 // Set this to 'false' in your development environment otherwise the cookie won't
 // be sent over an insecure channel. Setting this via the presence of a 'DEV'
@@ -83,6 +322,11 @@ func HttpOnly(h bool) Option {
 // when following a regular link from an external website while blocking it in
 // CSRF-prone request methods (e.g. POST).
 //
+// With SameSite(SameSiteNoneMode), the attribute is left out for user agents
+// known to reject cookies with SameSite=None, e.g. Safari on iOS 12 and
+// Chrome 51 to 66, so that they keep receiving the cookie. SameSite=None
+// requires Secure(true): New returns an error otherwise, and Protect forces it.
+//
 // This option is only available for go 1.11+.
 func SameSite(s SameSiteMode) Option {
 	return func(cs *csrf) {
@@ -90,6 +334,57 @@ func SameSite(s SameSiteMode) Option {
 	}
 }
 
+// ReportOnly turns validation failures into reports instead of rejections:
+// the failure is logged (see ErrorLog) and made available via FailureReason,
+// and the request is passed to the wrapped handler. This is useful to roll out
+// CSRF protection without breaking clients. Defaults to false.
+func ReportOnly() Option {
+	return func(cs *csrf) {
+		cs.opts.ReportOnly = true
+	}
+}
+
+// LegacyRequestedWith is a transitional aid for legacy endpoints that cannot
+// send a CSRF token yet: unsafe requests to the given paths, matched like
+// ExcludePaths, that carry no token are accepted if they carry the header
+// "X-Requested-With: XMLHttpRequest", which cross-site forms cannot set.
+// Requests with a token are verified as usual.
+//
+// This is a weak signal: it relies on browsers requiring a CORS preflight for
+// the header, and on no trusted origin allowing it in a preflight. Each
+// request accepted this way is logged to the ErrorLog and flagged in its
+// Outcome, so the remaining legacy clients can be found and tokenized. Use
+// LegacyReportOnly to roll the check out without rejections first.
+func LegacyRequestedWith(paths ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.LegacyPaths = paths
+	}
+}
+
+// LegacyReportOnly turns failures on the paths of LegacyRequestedWith into
+// reports, as with ReportOnly, to find the clients that send neither a token
+// nor the X-Requested-With header before enforcing the check.
+func LegacyReportOnly() Option {
+	return func(cs *csrf) {
+		cs.opts.LegacyReportOnly = true
+	}
+}
+
+// ReportOnlyFrom enables ReportOnly for requests from the given CIDR prefixes
+// (e.g. "10.0.0.0/8") or single addresses only, and enforces validation for
+// all other clients. This allows probing the behavior of the middleware in
+// production, e.g. from the office network, without affecting real users.
+// The client address is taken from http.Request.RemoteAddr, so behind a
+// reverse proxy it must be set from a trusted forwarding header first.
+// Defaults to empty.
+//
+// Protect panics if a prefix is invalid; New returns an error instead.
+func ReportOnlyFrom(cidrs ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.ReportOnlyFrom = cidrs
+	}
+}
+
 // ErrorHandler allows you to change the handler called when CSRF request
 // processing encounters an invalid token or request. A typical use would be to
 // provide a handler that returns a static HTML file with a HTTP 403 status. By
@@ -103,6 +398,46 @@ func ErrorHandler(h http.Handler) Option {
 	}
 }
 
+// OnFailure registers handlers for rejected requests, evaluated in the order
+// registered before the ErrorHandler, which handles requests all of them
+// fall through. Each handler receives the failure reason, and may decide by
+// the request or reason, e.g. to render JSON for an API and an HTML page
+// elsewhere:
+//
+//	csrf.OnFailure(
+//		csrf.PathFailureHandler("/api/", apiError),
+//		func(w http.ResponseWriter, r *http.Request, err error) bool {
+//			if !errors.Is(err, csrf.ErrNoReferer) {
+//				return false
+//			}
+//			http.Redirect(w, r, "/referrer-required", http.StatusSeeOther)
+//			return true
+//		},
+//	)
+func OnFailure(handlers ...FailureHandler) Option {
+	return func(cs *csrf) {
+		cs.opts.FailureHandlers = append(cs.opts.FailureHandlers[:len(cs.opts.FailureHandlers):len(cs.opts.FailureHandlers)], handlers...)
+	}
+}
+
+// RenderErrors registers a renderer for rejections requested in the given
+// media type, e.g. "application/problem+json", with the default error
+// handler. The default handler renders text/plain, application/json and
+// application/xml (or text/xml), choosing by the Accept header of the
+// request, and falls back to JSON for requests sending JSON and plain text
+// otherwise. A renderer registered for one of these types replaces the
+// built-in one. RenderErrors has no effect with a custom ErrorHandler.
+func RenderErrors(mediaType string, fn ErrorRenderer) Option {
+	return func(cs *csrf) {
+		renderers := make(map[string]ErrorRenderer, len(cs.opts.ErrorRenderers)+1)
+		for mt, fn := range cs.opts.ErrorRenderers {
+			renderers[mt] = fn
+		}
+		renderers[strings.ToLower(mediaType)] = fn
+		cs.opts.ErrorRenderers = renderers
+	}
+}
+
 // RequestHeader allows you to change the request header the CSRF middleware
 // inspects. The default is X-CSRF-Token.
 func RequestHeader(header string) Option {
@@ -111,6 +446,74 @@ func RequestHeader(header string) Option {
 	}
 }
 
+// MaxBodySize limits the number of request body bytes the middleware reads
+// when it parses a form to find the CSRF token, so that the CSRF layer cannot be
+// made to buffer huge form bodies. Requests exceeding the limit fail with
+// ErrBodyTooLarge. Tokens sent in the request header are not affected.
+// Defaults to 0 (no limit beyond those of net/http).
+func MaxBodySize(n int64) Option {
+	return func(cs *csrf) {
+		cs.opts.MaxBodySize = n
+	}
+}
+
+// MaxTokenSize limits the size in bytes of the request header and form field
+// values considered as CSRF tokens. Larger values are rejected with
+// ErrBadToken before they are decoded, so that clients cannot make the
+// middleware decode huge values; MalformedToken reports ErrTokenTooLong for
+// them. A zero or negative size selects the default,
+// the length of an encoded token (88 bytes).
+func MaxTokenSize(header, field int) Option {
+	return func(cs *csrf) {
+		cs.opts.MaxHeaderTokenSize = header
+		cs.opts.MaxFieldTokenSize = field
+	}
+}
+
+// CompanionHeader additionally requires unsafe requests to echo a CSRF token
+// in the named header, e.g. "X-CSRF-Companion", and accepts them only if the
+// submitted token (see RequestHeader and FieldName), the companion header and
+// the cookie all match. As cross-site forms cannot set custom headers, this
+// defeats attackers who plant a cookie with a known token from a sibling
+// subdomain. The name must differ from RequestHeader. Defaults to empty (not
+// required).
+func CompanionHeader(header string) Option {
+	return func(cs *csrf) {
+		cs.opts.CompanionHeader = header
+	}
+}
+
+// BindTLS binds tokens to the TLS connection they are issued on by mixing
+// keying material exported from the connection (RFC 5705) into them, so that
+// a token exfiltrated from one TLS connection cannot be replayed over another.
+// The cookie is not affected. Unsafe requests not made over TLS 1.3 (or TLS
+// 1.2 with extended master secret) fail with ErrTLSBinding.
+//
+// This is meant for high-assurance deployments that terminate TLS in the Go
+// server: a new connection - e.g. after the browser closed an idle one, or
+// through a load balancer - invalidates all tokens rendered before, and
+// tokens from Mint are never accepted. Defaults to false.
+func BindTLS() Option {
+	return func(cs *csrf) {
+		cs.opts.BindTLS = true
+	}
+}
+
+// LazyVaryCookie only adds "Vary: Cookie" to responses where the cookie
+// influences the response: if the middleware sets a cookie, or once the
+// handler reads the token (see Token), so that e.g. static assets remain
+// cacheable by shared caches. By default, it is added to every response.
+//
+// The header is added when the token is read, so handlers must read it before
+// they write the response header, e.g. by rendering templates into a buffer.
+// A token read while streaming the body leaves the response without the
+// header, and a shared cache may serve the token to other users.
+func LazyVaryCookie() Option {
+	return func(cs *csrf) {
+		cs.opts.LazyVaryCookie = true
+	}
+}
+
 // FieldName allows you to change the name attribute of the hidden <input> field
 // inspected by this package. The default is 'gorilla.csrf.Token'.
 func FieldName(name string) Option {
@@ -133,6 +536,13 @@ func CookieName(name string) Option {
 // This will allow cross-domain CSRF use-cases - e.g. where the front-end is served
 // from a different domain than the API server - to correctly pass a CSRF check.
 //
+// Origins are given either as a host, e.g. "example.com", which is trusted for
+// the scheme of the request, or with a scheme, e.g. "https://example.com". A
+// non-default port must be included in both cases, and IPv6 literals must be
+// bracketed when a port is given, e.g. "[2001:db8::1]:8443". A host starting
+// with "*." trusts all subdomains, e.g. "*.example.com" trusts
+// "app.example.com" but not "example.com" itself.
+//
 // You should only provide origins you own or have full control over.
 func TrustedOrigins(origins []string) Option {
 	return func(cs *csrf) {
@@ -140,7 +550,97 @@ func TrustedOrigins(origins []string) Option {
 	}
 }
 
+// DeniedOrigins configures a set of origins (Referers) that are never trusted,
+// in the same format as TrustedOrigins. Denied origins are checked before all
+// trusted origins and callbacks, so a single subdomain can be excluded from a
+// wildcard, e.g. "evil.example.com" from "*.example.com".
+func DeniedOrigins(origins []string) Option {
+	return func(cs *csrf) {
+		cs.opts.DeniedOrigins = origins
+	}
+}
+
+// RefererPaths additionally requires unsafe requests to path, matched like
+// ExcludePaths, to carry a Referer from a trusted origin whose path is one of
+// prefixes or below it, e.g. RefererPaths("/payments", "/checkout") only
+// accepts payments submitted from the checkout pages. This limits the damage
+// of a token leaked through other pages, e.g. via an open redirect. Requests
+// from other pages fail with ErrBadRefererPath, and requests without a
+// Referer with ErrNoReferer, also over plain HTTP. Query strings are ignored.
+//
+// Note that browsers strip the Referer path for cross-origin requests under
+// the default Referrer-Policy, so pages submitting from a TrustedOrigin need
+// a policy of "same-origin" or less strict.
+func RefererPaths(path string, prefixes ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.RefererPaths = append(cs.opts.RefererPaths[:len(cs.opts.RefererPaths):len(cs.opts.RefererPaths)], refererRule{path: path, prefixes: prefixes})
+	}
+}
+
+// AcceptGrants issues a fresh CSRF cookie for unsafe requests to one of paths,
+// e.g. "/saml/acs", that carry a valid token grant (see Middleware.Grant) for
+// the host and path of the request in the given form field, e.g.
+// "RelayState". Requests to other paths, and safe requests, are not searched
+// for grants, so that their bodies are left alone. The request itself is
+// checked as usual; the fresh cookie is sent with its response either way, so
+// that the requests that follow carry a valid token. Defaults to disabled.
+//
+// Redeemed grants are remembered until they expire, so each one is accepted
+// once: in memory, and in backend if it is not nil, which must be shared by
+// all instances that accept grants.
+func AcceptGrants(field string, backend GrantBackend, paths ...string) Option {
+	return func(cs *csrf) {
+		cs.opts.GrantField = field
+		cs.opts.GrantBackend = backend
+		cs.opts.GrantPaths = paths
+	}
+}
+
+// JSONPolicy sets the policy for unsafe JSON API calls, i.e. requests with a
+// JSON body (or, without a body, a JSON Accept header), so that one middleware
+// can serve both the web and the API of a host. Form posts are always checked
+// with PolicyToken. Defaults to PolicyToken.
+//
+// With PolicyOrigin, JSON API calls need no token: they pass if Sec-Fetch-Site
+// is same-origin or none, or if the Origin header is the request's own origin
+// or trusted (see TrustedOrigins). Calls from non-browser clients, which send
+// neither header, pass as well.
+func JSONPolicy(p ContentPolicy) Option {
+	return func(cs *csrf) {
+		cs.opts.JSONPolicy = p
+	}
+}
+
+// RefererMatch sets which Referers are accepted for HTTPS requests without
+// being listed in TrustedOrigins: RefererExactOrigin (the default) or
+// RefererSameSite, which accepts Referers from any subdomain of the
+// registrable domain of the request, e.g. forms on "app.example.com" posting
+// to "api.example.com", without enumerating them. DeniedOrigins still apply.
+//
+// Only use RefererSameSite if you control every subdomain of your registrable
+// domain, see CrossSubdomain.
+func RefererMatch(mode RefererMatchMode) Option {
+	return func(cs *csrf) {
+		cs.opts.RefererMatch = mode
+	}
+}
+
+// HostOnlyOrigins reverts to the legacy matching of trusted origins, which
+// only compares the host (and port) of the Referer with each trusted origin
+// and therefore accepts e.g. a http:// Referer for a https:// site.
+//
+// By default, origins are compared by scheme, host and port as per RFC 6454,
+// and a trusted origin given without a scheme only matches Referers using the
+// scheme of the request. Only use this option for backwards compatibility.
+func HostOnlyOrigins(legacy bool) Option {
+	return func(cs *csrf) {
+		cs.opts.HostOnlyOrigins = legacy
+	}
+}
+
 // TrustedOriginsCallbackFunc is a callback function that is used in TrustedOriginsCallback.
+// The request context carries the deadline and cancellation of the request and
+// should be passed on to any backend lookups.
 type TrustedOriginsCallbackFunc func(referer *url.URL, r *http.Request) bool
 
 // TrustedOriginsCallback configures a callback function that is called to
@@ -152,9 +652,220 @@ func TrustedOriginsCallback(f TrustedOriginsCallbackFunc) Option {
 	}
 }
 
+// TrustedOriginsContext configures a callback function that is called to
+// determine whether the origin (Referer) of the request is trusted, like
+// TrustedOriginsCallback. Unlike the latter, it receives the request context
+// and can return an error, e.g. when a database or remote policy lookup times
+// out; such requests are rejected with an error wrapping ErrOriginLookup.
+func TrustedOriginsContext(f TrustedOriginsContextFunc) Option {
+	return func(cs *csrf) {
+		cs.opts.TrustedOriginsContext = f
+	}
+}
+
+// TrustedOriginsFile loads additional trusted origins from the file at path,
+// in the same format as TrustedOrigins. The file lists one origin per line,
+// or as a YAML list; blank lines and "#" comments are ignored.
+//
+// The file is checked for changes at most once per reload interval and
+// re-read when it changed, so that the list can be updated without a restart.
+// A reload interval of 0 loads the file only once. If the file cannot be
+// read, the last loaded list is kept and the error is logged.
+func TrustedOriginsFile(path string, reload time.Duration) Option {
+	return func(cs *csrf) {
+		cs.opts.TrustedOriginsFile = path
+		cs.opts.TrustedOriginsReload = reload
+	}
+}
+
+// TrustedOriginsURL fetches additional trusted origins from an HTTP(S) URL, in
+// the same format as TrustedOriginsFile. Requests are never blocked on a
+// fetch: the list is fetched in the background when a request first needs it,
+// and refreshed likewise when a request needs it and the last attempt is
+// older than the refresh interval, sending the ETag of the last response in
+// If-None-Match. A refresh interval of 0 fetches the list only once. Until
+// the first fetch completes, no origins from the URL are trusted; call
+// Middleware.RefreshOrigins at startup to fetch the list up-front.
+//
+// If a refresh fails, the last list keeps being used for up to maxStale after
+// the last successful fetch, after which no origins from the URL are trusted
+// until a refresh succeeds. A maxStale of 0 keeps using the last list
+// indefinitely.
+func TrustedOriginsURL(url string, refresh, maxStale time.Duration) Option {
+	return func(cs *csrf) {
+		cs.opts.TrustedOriginsURL = url
+		cs.opts.TrustedOriginsRefresh = refresh
+		cs.opts.TrustedOriginsMaxStale = maxStale
+	}
+}
+
+// CacheTrustedOrigins caches the decisions of TrustedOriginsCallback and
+// TrustedOriginsContext for ttl, keeping at most maxEntries decisions (0 for
+// no limit). Decisions are cached per request host and Referer origin; errors
+// are never cached. Defaults to no caching.
+func CacheTrustedOrigins(ttl time.Duration, maxEntries int) Option {
+	return func(cs *csrf) {
+		cs.opts.OriginCacheTTL = ttl
+		cs.opts.OriginCacheSize = maxEntries
+	}
+}
+
+// CollectTokenMetrics records the age of every successfully verified token in
+// m. Set IdleTimeout or AbsoluteTimeout to have token ages recorded with the
+// default cookie store.
+func CollectTokenMetrics(m *TokenMetrics) Option {
+	return func(cs *csrf) {
+		cs.opts.TokenMetrics = m
+	}
+}
+
+// LogMalformedTokens logs requests whose CSRF token is malformed, i.e. too
+// long, not canonical base64 or of the wrong length, to ErrorLog. Malformed
+// tokens are never sent by legitimate clients, and may indicate probing.
+// Defaults to false.
+func LogMalformedTokens() Option {
+	return func(cs *csrf) {
+		cs.opts.LogMalformedTokens = true
+	}
+}
+
+// ProfilerLabels runs the cookie decoding and token verification of each
+// request with the pprof label "gorilla.csrf" set to "decode" and "verify"
+// respectively, so that CPU profiles attribute their cost to the CSRF
+// middleware rather than the application handlers. Defaults to false.
+func ProfilerLabels() Option {
+	return func(cs *csrf) {
+		cs.opts.ProfilerLabels = true
+	}
+}
+
+// OriginFailureAlert counts CSRF failures per origin (the Origin or Referer
+// of the request) and calls fn when the failures of a single origin reach
+// threshold within window, e.g. to alert a security team of an active CSRF or
+// automation campaign. fn is called at most once per origin and window, on
+// the request goroutine, and should return quickly. Defaults to disabled.
+func OriginFailureAlert(threshold int, window time.Duration, fn OriginAlertFunc) Option {
+	return func(cs *csrf) {
+		cs.opts.AlertThreshold = threshold
+		cs.opts.AlertWindow = window
+		cs.opts.AlertFunc = fn
+	}
+}
+
+// AuditEvents sends an audit Event to sink for every request failing CSRF
+// validation, whether rejected or reported (see ReportOnly). Use WriterSink
+// with FormatJSON, FormatCEF or FormatLEEF to feed a SIEM, and AsyncSink to
+// keep a slow SIEM from delaying requests. Defaults to nil.
+func AuditEvents(sink EventSink) Option {
+	return func(cs *csrf) {
+		cs.opts.EventSink = sink
+	}
+}
+
+// ErrorLog sets a logger for configuration and runtime warnings, such as the
+// middleware being applied more than once to the same request. Defaults to
+// nil, i.e. no logging.
+func ErrorLog(l *log.Logger) Option {
+	return func(cs *csrf) {
+		cs.opts.ErrorLog = l
+	}
+}
+
+// SessionStore stores the real CSRF token in the named session of an existing
+// gorilla/sessions store instead of a separate cookie, so the CSRF state
+// shares the session's lifecycle and backend. The cookie options of this
+// package (MaxAge, Domain, Path, ...) do not apply; configure the session
+// store instead.
+func SessionStore(s sessions.Store, name string) Option {
+	return func(cs *csrf) {
+		cs.st = &sessionStore{store: s, name: name}
+	}
+}
+
+// TokenStore sets the store used to persist the real CSRF token, e.g. a
+// server-side store backed by Redis or SQL. Defaults to a signed cookie.
+func TokenStore(s Store) Option {
+	return func(cs *csrf) {
+		cs.st = s
+	}
+}
+
+// OpaqueTokens keeps the real CSRF token server-side in backend, encrypted
+// with a key derived from the authentication key, and only puts a random
+// session ID, authenticated with a MAC, into the cookie. This shrinks the
+// cookie to 66 bytes, and allows the tokens of a user to be invalidated
+// instantly, see Middleware.Invalidate. IDs are created server-side only. The
+// cookie options of this package apply to the ID cookie; IdleTimeout and
+// AbsoluteTimeout do not apply.
+func OpaqueTokens(backend TokenBackend) Option {
+	return func(cs *csrf) {
+		cs.opts.TokenBackend = backend
+	}
+}
+
+// StoreTimeout bounds the duration of each call to the token store. A call
+// that does not complete in time fails with ErrStoreUnavailable; stores that
+// ignore the request context keep running in the background until they
+// return. Defaults to 0 (no timeout): store calls then run on the request
+// goroutine, and must honor the request context to end early.
+func StoreTimeout(d time.Duration) Option {
+	return func(cs *csrf) {
+		cs.opts.StoreTimeout = d
+	}
+}
+
+// StoreRetries sets the number of times a store call failing with
+// ErrStoreUnavailable is retried. Defaults to 0.
+func StoreRetries(n int) Option {
+	return func(cs *csrf) {
+		cs.opts.StoreRetries = n
+	}
+}
+
+// StoreCircuitBreaker opens a circuit breaker after threshold consecutive
+// store calls failed with ErrStoreUnavailable. While open, store calls fail
+// immediately; after cooldown, calls are attempted again. Defaults to
+// disabled.
+func StoreCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(cs *csrf) {
+		cs.opts.StoreBreakerThreshold = threshold
+		cs.opts.StoreBreakerCooldown = cooldown
+	}
+}
+
+// StoreFailurePolicy sets how unsafe requests are handled while the token
+// store is unavailable: FailClosed (the default) rejects them, FailOpen lets
+// them through unchecked. Safe requests are always served, without a token.
+func StoreFailurePolicy(p FailurePolicy) Option {
+	return func(cs *csrf) {
+		cs.opts.StoreFailurePolicy = p
+	}
+}
+
+// VerifyDeadline bounds the total time spent on the CSRF processing of a
+// request, i.e. token store calls and TrustedOriginsContext callbacks, which
+// receive the deadline in their context. The handler's request context is not
+// affected. A request exceeding the deadline is handled like one hitting an
+// unavailable store (see StoreFailurePolicy), and failed with
+// ErrVerifyTimeout unless FailOpen is set. Defaults to 0 (no deadline).
+func VerifyDeadline(d time.Duration) Option {
+	return func(cs *csrf) {
+		cs.opts.VerifyDeadline = d
+	}
+}
+
+// DeriveKey allows an authentication key of the wrong length, deriving a 32
+// byte key from it with HKDF-SHA256 instead of rejecting it. Derivation does
+// not make a weak key strong; prefer generating a random 32 byte key.
+func DeriveKey() Option {
+	return func(cs *csrf) {
+		cs.opts.DeriveKey = true
+	}
+}
+
 // setStore sets the store used by the CSRF middleware.
 // Note: this is private (for now) to allow for internal API changes.
-func setStore(s store) Option {
+func setStore(s Store) Option {
 	return func(cs *csrf) {
 		cs.st = s
 	}
@@ -0,0 +1,318 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// ErrOriginLookup is returned if a TrustedOriginsContext callback fails to
+// decide whether an origin is trusted, e.g. because a database lookup timed
+// out. Requests failing this way are rejected.
+var ErrOriginLookup = errors.New("trusted origin lookup failed")
+
+// TrustedOriginsContextFunc is a callback function that is used in
+// TrustedOriginsContext. It returns an error if it cannot decide whether the
+// referer is trusted; ctx carries the deadline and cancellation of the
+// request.
+type TrustedOriginsContextFunc func(ctx context.Context, referer *url.URL, r *http.Request) (bool, error)
+
+// trustedOrigin reports whether referer is a valid origin for r: either the
+// same origin, a trusted origin or one accepted by a callback.
+func (cs *csrf) trustedOrigin(r *http.Request, referer *url.URL) (bool, error) {
+	// Check exact match against the referer
+	u := cs.originURL(r)
+	if sameOrigin(u, referer) {
+		return true, nil
+	}
+
+	o := parseOrigin(referer)
+	legacy := cs.opts.HostOnlyOrigins
+
+	// Denied origins take precedence over all trusted origins
+	for _, p := range cs.denied {
+		if p.match(o, u.Scheme, legacy) {
+			return false, nil
+		}
+	}
+
+	// Accept Referers from the same site, if configured
+	if cs.opts.RefererMatch == RefererSameSite && sameSite(r, u, referer) {
+		return true, nil
+	}
+
+	// Check exact match against trusted origins, including the parent domain
+	// in cross-subdomain mode
+	for _, p := range cs.trusted {
+		if p.match(o, u.Scheme, legacy) {
+			return true, nil
+		}
+	}
+
+	// Check exact match against dynamic sources of trusted origins
+	for _, src := range cs.originSources {
+		for _, p := range src.origins() {
+			if p.match(o, u.Scheme, legacy) {
+				return true, nil
+			}
+		}
+	}
+
+	if cs.opts.TrustedOriginsCallback == nil && cs.opts.TrustedOriginsContext == nil {
+		return false, nil
+	}
+
+	// Use the cached decision of the callbacks, if any.
+	key := r.Host + " " + referer.Scheme + "://" + referer.Host
+	if cs.origins != nil {
+		if valid, ok := cs.origins.get(key); ok {
+			return valid, nil
+		}
+	}
+
+	valid, err := cs.originCallbacks(r, referer)
+	if err != nil {
+		return false, err
+	}
+
+	if cs.origins != nil {
+		cs.origins.put(key, valid)
+	}
+
+	return valid, nil
+}
+
+// RefererMatchMode controls which Referers are accepted without being listed
+// in TrustedOrigins.
+type RefererMatchMode int
+
+// Referer match modes
+const (
+	// RefererExactOrigin only accepts Referers with the origin - scheme, host
+	// and port - of the request. This is the default.
+	RefererExactOrigin RefererMatchMode = iota
+	// RefererSameSite accepts Referers from the same site as the request: the
+	// same scheme and registrable domain as per the public suffix list, e.g.
+	// "https://app.example.com" for "https://api.example.com", on any port.
+	RefererSameSite
+)
+
+// sameSite reports whether referer is on the same site as r, with the URL u
+// (see requestURL): it has the same scheme and registrable domain (eTLD+1).
+// Hosts without a registrable domain, e.g. IP addresses or "localhost", must
+// be equal.
+func sameSite(r *http.Request, u, referer *url.URL) bool {
+	if u.Scheme != "" && !strings.EqualFold(u.Scheme, referer.Scheme) {
+		return false
+	}
+
+	hostport := u.Host
+	if hostport == "" {
+		hostport = r.Host
+	}
+	host, _ := splitHost(hostport)
+	refererHost, _ := splitHost(referer.Host)
+	if host == "" || refererHost == "" {
+		return false
+	}
+	if host == refererHost {
+		return true
+	}
+
+	site, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return false
+	}
+	refererSite, err := publicsuffix.EffectiveTLDPlusOne(refererHost)
+	if err != nil {
+		return false
+	}
+
+	return site == refererSite
+}
+
+// origin is the normalized scheme, host and port of a URL.
+type origin struct {
+	scheme string
+	host   string
+	port   string
+}
+
+// parseOrigin returns the normalized origin of u.
+func parseOrigin(u *url.URL) origin {
+	host, port := splitHost(u.Host)
+	return origin{scheme: strings.ToLower(u.Scheme), host: host, port: port}
+}
+
+// originPattern is a compiled trusted (or denied) origin, e.g. "example.com",
+// "https://example.com:8443" or "*.example.com".
+type originPattern struct {
+	// scheme is empty if the pattern applies to the scheme of the request.
+	scheme string
+	// host is the normalized host, without the "*." of a wildcard.
+	host     string
+	port     string
+	wildcard bool
+}
+
+// compileOrigins compiles trusted (or denied) origins, in the format described
+// in TrustedOrigins, into patterns.
+func compileOrigins(origins []string) []originPattern {
+	if len(origins) == 0 {
+		return nil
+	}
+
+	patterns := make([]originPattern, 0, len(origins))
+	for _, o := range origins {
+		patterns = append(patterns, compileOrigin(o))
+	}
+
+	return patterns
+}
+
+// compileOrigin compiles a single trusted (or denied) origin.
+func compileOrigin(trusted string) originPattern {
+	var p originPattern
+
+	host := trusted
+	if i := strings.Index(trusted, "://"); i >= 0 {
+		p.scheme = strings.ToLower(trusted[:i])
+		host = strings.TrimSuffix(trusted[i+len("://"):], "/")
+	}
+
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		p.wildcard = true
+		host = rest
+	}
+
+	p.host, p.port = splitHost(host)
+
+	return p
+}
+
+// match reports whether the origin o matches the pattern. Origins are
+// compared by scheme, host and port as per RFC 6454. A pattern without a
+// scheme, e.g. "example.com", is taken to have the scheme of the request. In
+// host-only (legacy) mode, only the host and port are compared, and patterns
+// with a scheme never match.
+//
+// Hosts are compared in their ASCII (punycode) form, so an internationalized
+// domain name matches in both its Unicode and its punycode representation. A
+// wildcard matches all subdomains of its host.
+func (p originPattern) match(o origin, requestScheme string, legacy bool) bool {
+	scheme := p.scheme
+	if legacy {
+		if scheme != "" {
+			return false
+		}
+		scheme = o.scheme
+	} else {
+		if scheme == "" {
+			scheme = strings.ToLower(requestScheme)
+		}
+		if o.scheme != scheme {
+			return false
+		}
+	}
+
+	if dropDefaultPort(scheme, p.port) != dropDefaultPort(o.scheme, o.port) {
+		return false
+	}
+
+	if p.wildcard {
+		return strings.HasSuffix(o.host, "."+p.host)
+	}
+
+	return o.host == p.host
+}
+
+// defaultPorts maps URL schemes to their default ports.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// dropDefaultPort returns port, or "" if it is the default port of scheme.
+func dropDefaultPort(scheme, port string) string {
+	if port == defaultPorts[strings.ToLower(scheme)] {
+		return ""
+	}
+
+	return port
+}
+
+// splitHost splits a host with an optional port and returns the lower-case
+// ASCII form of the host, e.g. "xn--mnchen-3ya.example" for "München.example".
+// Hosts that are not valid domain names are only lower-cased. IPv6 literals
+// are accepted with or without brackets and returned in their canonical form
+// without brackets.
+func splitHost(hostport string) (host, port string) {
+	host = hostport
+	if h, p, err := net.SplitHostPort(hostport); err == nil {
+		host, port = h, p
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		host = addr.String()
+	} else if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+
+	return strings.ToLower(host), port
+}
+
+// normalizeHost returns the normalized form of a host with an optional port
+// (see splitHost), e.g. "xn--mnchen-3ya.example:8443" for
+// "München.example:8443". The port is dropped if it is the default port of
+// scheme, e.g. 443 for https. IPv6 literals are returned in their canonical
+// bracketed form, e.g. "[2001:db8::1]:8443".
+func normalizeHost(scheme, hostport string) string {
+	host, port := splitHost(hostport)
+
+	if port = dropDefaultPort(scheme, port); port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+
+	return host
+}
+
+// originCallbacks asks the configured callbacks whether referer is trusted.
+func (cs *csrf) originCallbacks(r *http.Request, referer *url.URL) (bool, error) {
+	// Use a callback function to check the referer if the origin check
+	// failed.
+	if cs.opts.TrustedOriginsCallback != nil && cs.opts.TrustedOriginsCallback(referer, r) {
+		return true, nil
+	}
+
+	if cs.opts.TrustedOriginsContext != nil {
+		valid, err := cs.opts.TrustedOriginsContext(verifyContext(r), referer, r)
+		if err != nil {
+			return false, fmt.Errorf("%w: %w", ErrOriginLookup, err)
+		}
+		return valid, nil
+	}
+
+	return false, nil
+}
+
+// originCache caches origin decisions for a limited time and number of
+// entries.
+type originCache = shardedCache[bool]
+
+func newOriginCache(ttl time.Duration, maxEntries int) *originCache {
+	return newShardedCache[bool](ttl, maxEntries)
+}
@@ -0,0 +1,112 @@
+package csrf
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// originSource provides a list of trusted origins that may change while the
+// middleware is running.
+type originSource interface {
+	origins() []originPattern
+}
+
+// fileOrigins is an originSource backed by a file. The file is checked for
+// changes at most once per interval, on demand, and re-read when its size or
+// modification time changed.
+type fileOrigins struct {
+	path     string
+	interval time.Duration
+	logf     func(format string, args ...interface{})
+
+	mu      sync.Mutex
+	list    []originPattern
+	size    int64
+	modTime time.Time
+	checked time.Time
+}
+
+// newFileOrigins loads the trusted origins from path.
+func newFileOrigins(path string, interval time.Duration, logf func(string, ...interface{})) (*fileOrigins, error) {
+	fo := &fileOrigins{path: path, interval: interval, logf: logf}
+	if err := fo.load(); err != nil {
+		return fo, err
+	}
+
+	return fo, nil
+}
+
+// origins returns the current list of trusted origins, reloading the file if
+// it has changed. If the file cannot be read, the last list is kept.
+func (fo *fileOrigins) origins() []originPattern {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	if fo.interval > 0 && timeNow().Sub(fo.checked) >= fo.interval {
+		if err := fo.load(); err != nil {
+			fo.logf("reloading trusted origins: %v", err)
+		}
+	}
+
+	return fo.list
+}
+
+// load re-reads the file if it has changed since the last load.
+func (fo *fileOrigins) load() error {
+	fo.checked = timeNow()
+
+	fi, err := os.Stat(fo.path)
+	if err != nil {
+		return err
+	}
+	if fi.Size() == fo.size && fi.ModTime().Equal(fo.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(fo.path)
+	if err != nil {
+		return err
+	}
+
+	fo.list = compileOrigins(parseOrigins(data))
+	fo.size = fi.Size()
+	fo.modTime = fi.ModTime()
+
+	return nil
+}
+
+// parseOrigins parses a list of origins, one per line. Blank lines and
+// comments starting with "#" are ignored. A YAML list is accepted as well,
+// e.g.:
+//
+//	trusted_origins:
+//	  - "app.example.com"
+//	  - admin.example.com
+func parseOrigins(data []byte) []string {
+	var origins []string
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, ":") {
+			// Skip blank lines and YAML keys.
+			continue
+		}
+
+		line = strings.TrimSpace(strings.TrimPrefix(line, "- "))
+		line = strings.Trim(line, `"'`)
+		if line != "" {
+			origins = append(origins, line)
+		}
+	}
+
+	return origins
+}
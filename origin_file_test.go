@@ -0,0 +1,81 @@
+package csrf
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOrigins(t *testing.T) {
+	testTable := []struct {
+		data string
+		want []string
+	}{
+		{"a.example.com\n\n# comment\nb.example.com # inline\n", []string{"a.example.com", "b.example.com"}},
+		{"trusted_origins:\n  - \"a.example.com\"\n  - 'b.example.com'\n  - c.example.com\n", []string{"a.example.com", "b.example.com", "c.example.com"}},
+		{"", nil},
+	}
+
+	for _, item := range testTable {
+		if got := parseOrigins([]byte(item.data)); !reflect.DeepEqual(got, item.want) {
+			t.Errorf("parseOrigins(%q): got %q want %q", item.data, got, item.want)
+		}
+	}
+}
+
+// TestTrustedOriginsFile tests that trusted origins are loaded from a file and
+// reloaded when it changes.
+func TestTrustedOriginsFile(t *testing.T) {
+	start := time.Now()
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	path := filepath.Join(t.TempDir(), "origins.txt")
+	if err := os.WriteFile(path, []byte("golang.org\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := Protect(testKey, TrustedOriginsFile(path, time.Minute))(nil).(*csrf)
+
+	r, err := http.NewRequest("POST", "https://www.gorillatoolkit.org/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(host string, want bool) {
+		t.Helper()
+		referer := &url.URL{Scheme: "https", Host: host}
+		if valid, err := cs.trustedOrigin(r, referer); valid != want || err != nil {
+			t.Errorf("trustedOrigin(%q): got %v, %v want %v", host, valid, err, want)
+		}
+	}
+
+	check("golang.org", true)
+	check("example.com", false)
+
+	if err := os.WriteFile(path, []byte("example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Force a different modification time on coarse-grained file systems.
+	if err := os.Chtimes(path, start, start.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is not checked again before the reload interval has passed.
+	check("golang.org", true)
+
+	timeNow = func() time.Time { return start.Add(2 * time.Minute) }
+	check("golang.org", false)
+	check("example.com", true)
+
+	// The last list is kept if the file disappears.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	timeNow = func() time.Time { return start.Add(4 * time.Minute) }
+	check("example.com", true)
+}
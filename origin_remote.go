@@ -0,0 +1,166 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// remoteFetchTimeout bounds a single fetch of a remote trusted origins list.
+const remoteFetchTimeout = 10 * time.Second
+
+// remoteOrigins is an originSource backed by an HTTP(S) URL. The list is
+// fetched without blocking requests when one needs it and it has not been
+// fetched yet, or the last attempt is older than interval, using the ETag of
+// the last response to avoid downloading an unchanged list. If a refresh
+// fails, the last list keeps being used for up to maxStale (forever if 0).
+type remoteOrigins struct {
+	url      string
+	interval time.Duration
+	maxStale time.Duration
+	client   *http.Client
+	logf     func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	list     []originPattern
+	etag     string
+	fetched  time.Time // last successful fetch
+	checked  time.Time // last fetch attempt
+	fetching bool
+	stopped  bool
+}
+
+// newRemoteOrigins returns a source of the trusted origins at url. The list
+// is not fetched until it is first needed, or refreshed with refresh.
+func newRemoteOrigins(url string, interval, maxStale time.Duration, logf func(string, ...interface{})) *remoteOrigins {
+	return &remoteOrigins{
+		url:      url,
+		interval: interval,
+		maxStale: maxStale,
+		client:   &http.Client{Timeout: remoteFetchTimeout},
+		logf:     logf,
+	}
+}
+
+// origins returns the current list of trusted origins and starts a background
+// fetch if it is due.
+func (ro *remoteOrigins) origins() []originPattern {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	now := timeNow()
+	due := ro.checked.IsZero() || (ro.interval > 0 && now.Sub(ro.checked) >= ro.interval)
+	if due && !ro.fetching && !ro.stopped {
+		ro.fetching = true
+		go func() {
+			if err := ro.refresh(context.Background()); err != nil {
+				ro.logf("refreshing trusted origins: %v", err)
+			}
+		}()
+	}
+
+	if ro.maxStale > 0 && now.Sub(ro.fetched) > ro.maxStale {
+		// The list is too old to be trusted any longer.
+		return nil
+	}
+
+	return ro.list
+}
+
+// stop stops the background refreshes. The last list remains in use.
+func (ro *remoteOrigins) stop() {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	ro.stopped = true
+}
+
+// stale reports whether the list is older than maxStale, and therefore no
+// longer used.
+func (ro *remoteOrigins) stale() bool {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	return ro.maxStale > 0 && timeNow().Sub(ro.fetched) > ro.maxStale
+}
+
+// refresh fetches the list, keeping the current one if it has not changed or
+// cannot be fetched. The caller must have set ro.fetching.
+func (ro *remoteOrigins) refresh(ctx context.Context) error {
+	ro.mu.Lock()
+	etag := ro.etag
+	ro.mu.Unlock()
+
+	list, etag, modified, err := ro.fetch(ctx, etag)
+
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	ro.fetching = false
+	ro.checked = timeNow()
+	if err != nil {
+		return err
+	}
+
+	ro.fetched = ro.checked
+	if modified {
+		ro.list = list
+		ro.etag = etag
+	}
+
+	return nil
+}
+
+// fetch downloads the list unless it still matches etag.
+func (ro *remoteOrigins) fetch(ctx context.Context, etag string) (list []originPattern, newETag string, modified bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ro.url, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := ro.client.Do(req)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, etag, false, nil
+	case http.StatusOK:
+	default:
+		return nil, "", false, fmt.Errorf("fetching %s: unexpected status %s", ro.url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	return compileOrigins(parseOrigins(data)), resp.Header.Get("ETag"), true, nil
+}
+
+// RefreshOrigins fetches the trusted origins of TrustedOriginsURL now,
+// blocking until the list has been fetched or ctx is done, e.g. at startup so
+// that the first requests from the listed origins do not fail while the list
+// is fetched in the background.
+func (m *Middleware) RefreshOrigins(ctx context.Context) error {
+	var errs []error
+	for _, src := range m.cs.originSources {
+		if ro, ok := src.(*remoteOrigins); ok {
+			ro.mu.Lock()
+			ro.fetching = true
+			ro.mu.Unlock()
+			errs = append(errs, ro.refresh(ctx))
+		}
+	}
+
+	return errors.Join(errs...)
+}
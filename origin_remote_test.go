@@ -0,0 +1,131 @@
+package csrf
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestTrustedOriginsURL tests that the remote list is fetched, revalidated with
+// its ETag and kept while the URL is failing.
+func TestTrustedOriginsURL(t *testing.T) {
+	start := time.Now()
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	var (
+		mu      sync.Mutex
+		body    = "golang.org\n"
+		etag    = `"v1"`
+		failing bool
+		hits    int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		hits++
+		switch {
+		case failing:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.Header.Get("If-None-Match") == etag:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(body))
+		}
+	}))
+	defer srv.Close()
+
+	m, err := New(testKey, TrustedOriginsURL(srv.URL, time.Minute, 10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ro := m.cs.originSources[0].(*remoteOrigins)
+
+	// The list is not fetched while the middleware is created.
+	mu.Lock()
+	if hits != 0 {
+		t.Fatalf("list fetched on creation: got %d fetches", hits)
+	}
+	mu.Unlock()
+	if err := m.RefreshOrigins(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	refresh := func() error {
+		ro.mu.Lock()
+		ro.fetching = true
+		ro.mu.Unlock()
+		return ro.refresh(context.Background())
+	}
+	check := func(want []string) {
+		t.Helper()
+		if got := ro.origins(); !reflect.DeepEqual(got, compileOrigins(want)) {
+			t.Errorf("origins: got %v want %q", got, want)
+		}
+	}
+
+	check([]string{"golang.org"})
+
+	// An unchanged list is revalidated, not downloaded again.
+	if err := refresh(); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"golang.org"})
+
+	mu.Lock()
+	body, etag = "example.com\n", `"v2"`
+	mu.Unlock()
+	if err := refresh(); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"example.com"})
+
+	// The last list is served while the URL is failing, up to maxStale.
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	if err := refresh(); err == nil {
+		t.Fatal("refresh did not report the failure")
+	}
+	check([]string{"example.com"})
+
+	timeNow = func() time.Time { return start.Add(11 * time.Minute) }
+	ro.mu.Lock()
+	ro.fetching = true // Prevent a background refresh.
+	ro.mu.Unlock()
+	check(nil)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 4 {
+		t.Errorf("unexpected number of fetches: got %d want %d", hits, 4)
+	}
+}
+
+// TestTrustedOriginsURLBackground tests that the list is fetched in the
+// background when a request first needs it.
+func TestTrustedOriginsURLBackground(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("golang.org\n"))
+	}))
+	defer srv.Close()
+
+	cs := Protect(testKey, TrustedOriginsURL(srv.URL, 0, 0))(nil).(*csrf)
+	ro := cs.originSources[0].(*remoteOrigins)
+
+	if got := ro.origins(); got != nil {
+		t.Fatalf("origins before the first fetch: got %v", got)
+	}
+	for i := 0; i < 100 && ro.origins() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := ro.origins(); !reflect.DeepEqual(got, compileOrigins([]string{"golang.org"})) {
+		t.Fatalf("origins: got %v", got)
+	}
+}
@@ -0,0 +1,347 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTrustedOriginsContext tests that the context-aware callback can accept,
+// reject and fail an origin check, and receives the request context.
+func TestTrustedOriginsContext(t *testing.T) {
+	type ctxKey struct{}
+	errTimeout := errors.New("tenant database timed out")
+
+	testTable := []struct {
+		host   string
+		code   int
+		reason error
+	}{
+		{"golang.org", http.StatusOK, nil},
+		{"example.com", http.StatusForbidden, ErrBadReferer},
+		{"slow.example.com", http.StatusForbidden, ErrOriginLookup},
+	}
+
+	for _, item := range testTable {
+		var reason error
+		s := http.NewServeMux()
+		s.HandleFunc("/", testHandler)
+		p := Protect(testKey,
+			TrustedOriginsContext(func(ctx context.Context, referer *url.URL, r *http.Request) (bool, error) {
+				if ctx.Value(ctxKey{}) != "tenant" {
+					t.Errorf("callback did not receive the request context")
+				}
+				if referer.Host == "slow.example.com" {
+					return false, errTimeout
+				}
+				return referer.Host == "golang.org", nil
+			}),
+			ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reason = FailureReason(r)
+				w.WriteHeader(http.StatusForbidden)
+			})),
+		)(s)
+
+		var token string
+		s.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+			token = Token(r)
+		})
+
+		r, err := http.NewRequest("GET", "https://www.gorillatoolkit.org/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		ctx := context.WithValue(context.Background(), ctxKey{}, "tenant")
+		r, err = http.NewRequestWithContext(ctx, "POST", "https://www.gorillatoolkit.org/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setCookie(rr, r)
+		r.Header.Set("X-CSRF-Token", token)
+		r.Header.Set("Referer", "https://"+item.host+"/")
+
+		rr = httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("%s: got %v want %v", item.host, rr.Code, item.code)
+		}
+
+		if item.reason != nil && !errors.Is(reason, item.reason) {
+			t.Errorf("%s: got reason %v want %v", item.host, reason, item.reason)
+		}
+	}
+}
+
+// TestOriginCache tests that cached decisions expire and that the cache is
+// bounded.
+func TestOriginCache(t *testing.T) {
+	start := time.Now()
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	oc := newOriginCache(time.Minute, 2)
+	oc.put("a", true)
+	if valid, ok := oc.get("a"); !ok || !valid {
+		t.Fatalf("cached decision not returned: got %v, %v", valid, ok)
+	}
+
+	oc.put("b", false)
+	oc.put("c", true)
+	if n := oc.len(); n > 2 {
+		t.Fatalf("cache not bounded: got %d entries want at most %d", n, 2)
+	}
+	if valid, ok := oc.get("c"); !ok || !valid {
+		t.Fatalf("cached decision not returned: got %v, %v", valid, ok)
+	}
+
+	timeNow = func() time.Time { return start.Add(2 * time.Minute) }
+	if _, ok := oc.get("c"); ok {
+		t.Fatal("expired decision returned")
+	}
+}
+
+// TestCacheTrustedOrigins tests that the callback is only called once per
+// origin while its decision is cached.
+func TestCacheTrustedOrigins(t *testing.T) {
+	calls := 0
+	cs := Protect(testKey,
+		TrustedOriginsCallback(func(referer *url.URL, r *http.Request) bool {
+			calls++
+			return referer.Host == "golang.org"
+		}),
+		CacheTrustedOrigins(time.Minute, 100),
+	)(nil).(*csrf)
+
+	r, err := http.NewRequest("POST", "https://www.gorillatoolkit.org/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referer, _ := url.Parse("https://golang.org/")
+
+	for i := 0; i < 3; i++ {
+		if valid, err := cs.trustedOrigin(r, referer); !valid || err != nil {
+			t.Fatalf("trusted origin rejected: got %v, %v", valid, err)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("callback decision not cached: got %d calls want %d", calls, 1)
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	testTable := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM", "example.com"},
+		{"münchen.example", "xn--mnchen-3ya.example"},
+		{"MÜNCHEN.example:8443", "xn--mnchen-3ya.example:8443"},
+		{"xn--mnchen-3ya.example", "xn--mnchen-3ya.example"},
+		{"XN--MNCHEN-3YA.example", "xn--mnchen-3ya.example"},
+		{"example.com:443", "example.com"},
+		{"example.com:80", "example.com:80"},
+		{"[2001:db8::1]:8443", "[2001:db8::1]:8443"},
+		{"[2001:DB8:0::1]:443", "[2001:db8::1]"},
+		{"[2001:db8::1]", "[2001:db8::1]"},
+		{"2001:db8::1", "[2001:db8::1]"},
+		{"192.0.2.1:8443", "192.0.2.1:8443"},
+	}
+
+	for _, item := range testTable {
+		if got := normalizeHost("https", item.host); got != item.want {
+			t.Errorf("normalizeHost(%q): got %q want %q", item.host, got, item.want)
+		}
+	}
+}
+
+// TestNormalizedTrustedOrigins tests that internationalized domain names match
+// in both their Unicode and punycode form, that default ports are ignored and
+// that IPv6 literals match in any notation.
+func TestNormalizedTrustedOrigins(t *testing.T) {
+	r, err := http.NewRequest("POST", "https://www.gorillatoolkit.org/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		trusted string
+		referer string
+	}{
+		{"münchen.example", "https://xn--mnchen-3ya.example/"},
+		{"xn--mnchen-3ya.example", "https://münchen.example/"},
+		{"https://München.example", "https://xn--mnchen-3ya.example/"},
+		{"example.com", "https://example.com:443/"},
+		{"https://example.com:443", "https://example.com/"},
+		{"[2001:db8::1]:8443", "https://[2001:db8::1]:8443/"},
+		{"https://[2001:DB8::1]", "https://[2001:db8::1]:443/"},
+	}
+
+	for _, item := range testTable {
+		cs := Protect(testKey, TrustedOrigins([]string{item.trusted}))(nil).(*csrf)
+		referer, err := url.Parse(item.referer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if valid, err := cs.trustedOrigin(r, referer); !valid || err != nil {
+			t.Errorf("%q, %q: trusted origin rejected: got %v, %v", item.trusted, item.referer, valid, err)
+		}
+	}
+}
+
+// TestDeniedOrigins tests that wildcard origins trust all subdomains and that
+// denied origins take precedence over trusted origins and callbacks.
+func TestDeniedOrigins(t *testing.T) {
+	cs := Protect(testKey,
+		TrustedOrigins([]string{"*.example.com"}),
+		DeniedOrigins([]string{"evil.example.com", "https://*.evil.example.org"}),
+		TrustedOriginsCallback(func(referer *url.URL, r *http.Request) bool {
+			return strings.HasSuffix(referer.Host, ".example.org")
+		}),
+	)(nil).(*csrf)
+
+	r, err := http.NewRequest("POST", "https://www.gorillatoolkit.org/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		referer string
+		valid   bool
+	}{
+		{"https://app.example.com/", true},
+		{"https://a.b.example.com/", true},
+		{"https://Evil.example.com/", false},
+		{"https://example.com/", false},
+		{"https://app.example.com:8443/", false},
+		{"http://app.example.com/", false},
+		{"https://app.example.org/", true},
+		{"https://a.evil.example.org/", false},
+		{"https://www.gorillatoolkit.org/", true},
+	}
+
+	for _, item := range testTable {
+		referer, err := url.Parse(item.referer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if valid, err := cs.trustedOrigin(r, referer); valid != item.valid || err != nil {
+			t.Errorf("trustedOrigin(%q): got %v, %v want %v", item.referer, valid, err, item.valid)
+		}
+	}
+}
+
+// TestRefererSameSite tests that same-site Referers are accepted with
+// RefererSameSite, but not by default.
+func TestRefererSameSite(t *testing.T) {
+	exact := Protect(testKey)(nil).(*csrf)
+	site := Protect(testKey,
+		RefererMatch(RefererSameSite),
+		DeniedOrigins([]string{"evil.example.co.uk"}),
+	)(nil).(*csrf)
+
+	r, err := http.NewRequest("POST", "https://api.example.co.uk/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTable := []struct {
+		referer string
+		valid   bool
+	}{
+		{"https://api.example.co.uk/", true},
+		{"https://app.example.co.uk/", true},
+		{"https://example.co.uk:8443/", true},
+		{"https://evil.example.co.uk/", false},
+		{"http://app.example.co.uk/", false},
+		{"https://other.co.uk/", false},
+		{"https://example.com/", false},
+	}
+
+	for _, item := range testTable {
+		referer, err := url.Parse(item.referer)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if valid, err := site.trustedOrigin(r, referer); valid != item.valid || err != nil {
+			t.Errorf("same site %q: got %v, %v want %v", item.referer, valid, err, item.valid)
+		}
+
+		want := item.referer == "https://api.example.co.uk/"
+		if valid, _ := exact.trustedOrigin(r, referer); valid != want {
+			t.Errorf("exact origin %q: got %v want %v", item.referer, valid, want)
+		}
+	}
+
+	ip, err := http.NewRequest("POST", "https://127.0.0.1/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referer, _ := url.Parse("https://127.0.0.2/")
+	if valid, _ := site.trustedOrigin(ip, referer); valid {
+		t.Error("different IP addresses treated as same site")
+	}
+}
+
+// TestCrossSubdomain tests that tokens issued on one subdomain are accepted on
+// a sibling subdomain in cross-subdomain mode.
+func TestCrossSubdomain(t *testing.T) {
+	var token string
+	p := Protect(testKey, CrossSubdomain("example.com"), DeniedOrigins([]string{"evil.example.com"}))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token = Token(r)
+		}))
+
+	r, err := http.NewRequest("GET", "https://account.example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if c := rr.Result().Cookies(); len(c) != 1 || c[0].Domain != "example.com" {
+		t.Fatalf("cookie not issued for the parent domain: got %v", c)
+	}
+
+	testTable := []struct {
+		referer string
+		code    int
+	}{
+		{"https://account.example.com/login", http.StatusOK},
+		{"https://example.com/", http.StatusOK},
+		{"https://evil.example.com/", http.StatusForbidden},
+		{"https://example.org/", http.StatusForbidden},
+	}
+
+	for _, item := range testTable {
+		req, err := http.NewRequest("POST", "https://app.example.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setCookie(rr, req)
+		req.Header.Set("X-CSRF-Token", token)
+		req.Header.Set("Referer", item.referer)
+
+		res := httptest.NewRecorder()
+		p.ServeHTTP(res, req)
+
+		if res.Code != item.code {
+			t.Errorf("%s: got %v want %v", item.referer, res.Code, item.code)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package csrf
+
+import (
+	"net/http"
+	"time"
+)
+
+// Reasons for skipping the CSRF check of a request, as reported in
+// Outcome.Skipped.
+const (
+	SkippedScope           = "scope"            // outside of OnlyUnder/OnlyHosts/OnlyMatching
+	SkippedUnsafe          = "unsafe-skip"      // UnsafeSkipCheck
+	SkippedInjected        = "injected"         // WithToken
+	SkippedPath            = "excluded-path"    // ExcludePaths
+	SkippedPattern         = "excluded-pattern" // ExcludePatterns
+	SkippedRoute           = "excluded-route"   // ExcludeRoutes
+	SkippedMatcher         = "excluded-matcher" // ExcludeMatchers
+	SkippedAuthHeader      = "auth-header"      // SkipAuthHeader
+	SkippedClientCert      = "client-cert"      // SkipClientCerts
+	SkippedCookieless      = "cookieless"       // Cookieless(CookielessSkip)
+	SkippedSafeMethod      = "safe-method"      // GET, HEAD, OPTIONS or TRACE
+	SkippedWebhook         = "webhook"          // Webhook
+	SkippedBadCookieReport = "bad-cookie"       // BadCookie(BadCookieReportOnly)
+)
+
+// Outcome describes how the middleware handled a request, for canonical log
+// lines and access logs. See WithOutcome.
+type Outcome struct {
+	// Checked is true if the request was subject to a CSRF check.
+	Checked bool
+	// Skipped is the reason the check was skipped, e.g. SkippedPath, or
+	// empty.
+	Skipped string
+	// Failure is the reason the check failed, e.g. ErrBadToken, or nil. In
+	// report-only mode, the request was served regardless.
+	Failure error
+	// Code is the stable code of Failure, e.g. CodeBadToken, or empty.
+	Code string
+	// TokenAge is the age of the verified token, if known, and zero
+	// otherwise. Ages are known if the store records the issue time of the
+	// token, i.e. the cookie store with IdleTimeout or AbsoluteTimeout set.
+	TokenAge time.Duration
+	// Legacy is true if the request passed the transitional X-Requested-With
+	// check instead of a token check, see LegacyRequestedWith.
+	Legacy bool
+}
+
+// WithOutcome returns a shallow copy of r with an empty Outcome that the CSRF
+// middleware fills in when it handles the request. It is intended for
+// access-logging middleware that runs before the CSRF middleware:
+//
+//	func logRequests(h http.Handler) http.Handler {
+//		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//			r, outcome := csrf.WithOutcome(r)
+//			h.ServeHTTP(w, r)
+//			log.Printf("%s %s csrf_checked=%t csrf_skipped=%q csrf_failure=%v",
+//				r.Method, r.URL.Path, outcome.Checked, outcome.Skipped, outcome.Failure)
+//		})
+//	}
+func WithOutcome(r *http.Request) (*http.Request, *Outcome) {
+	o := &Outcome{}
+	return contextSave(r, outcomeKey, o), o
+}
+
+// outcome returns the Outcome attached to r by WithOutcome, or nil.
+func outcome(r *http.Request) *Outcome {
+	if val, err := contextGet(r, outcomeKey); err == nil {
+		if o, ok := val.(*Outcome); ok {
+			return o
+		}
+	}
+
+	return nil
+}
+
+// skip serves r without a CSRF check, recording the reason.
+func (cs *csrf) skip(w http.ResponseWriter, r *http.Request, reason string) {
+	if o := outcome(r); o != nil {
+		o.Skipped = reason
+	}
+	cs.stats.skipped.Add(1)
+	cs.annotateSkip(r, reason)
+
+	cs.h.ServeHTTP(w, r)
+}
@@ -0,0 +1,59 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestOutcome tests that the outcome of the CSRF check is reported to
+// middleware running before the CSRF middleware.
+func TestOutcome(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	var token string
+	p := Protect(testKey, ExcludePaths("/hooks"), IdleTimeout(time.Hour))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token = Token(r)
+		}))
+
+	serve := func(method, path string, cookies *httptest.ResponseRecorder, token string) (*httptest.ResponseRecorder, *Outcome) {
+		r, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cookies != nil {
+			setCookie(cookies, r)
+		}
+		if token != "" {
+			r.Header.Set("X-CSRF-Token", token)
+		}
+
+		r, o := WithOutcome(r)
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+		return rr, o
+	}
+
+	cookies, o := serve("GET", "/", nil, "")
+	if *o != (Outcome{Skipped: SkippedSafeMethod}) {
+		t.Errorf("GET: got %+v", *o)
+	}
+	issued := token
+
+	if _, o := serve("POST", "/hooks/1", nil, ""); *o != (Outcome{Skipped: SkippedPath}) {
+		t.Errorf("excluded POST: got %+v", *o)
+	}
+
+	if _, o := serve("POST", "/", cookies, ""); *o != (Outcome{Checked: true, Failure: ErrNoToken, Code: CodeNoToken}) {
+		t.Errorf("POST without token: got %+v", *o)
+	}
+
+	timeNow = func() time.Time { return start.Add(5 * time.Minute) }
+	if _, o := serve("POST", "/", cookies, issued); *o != (Outcome{Checked: true, TokenAge: 5 * time.Minute}) {
+		t.Errorf("POST with token: got %+v", *o)
+	}
+}
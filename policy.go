@@ -0,0 +1,94 @@
+package csrf
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ContentPolicy selects how unsafe requests of a kind of content are checked.
+type ContentPolicy int
+
+// Content policies
+const (
+	// PolicyToken checks the token and, for HTTPS requests, the Referer. This
+	// is the default.
+	PolicyToken ContentPolicy = iota
+	// PolicyOrigin only checks the Sec-Fetch-Site and Origin headers sent by
+	// browsers, and requires no token.
+	PolicyOrigin
+)
+
+// isJSONRequest reports whether r is a JSON API call: its body is JSON or,
+// for a request without a body type, the client accepts JSON. Form posts are
+// never JSON API calls.
+func isJSONRequest(r *http.Request) bool {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		return isJSONMediaType(ct)
+	}
+
+	if r.Method == http.MethodPost {
+		// A body-less POST is what a cross-site form can send.
+		return false
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if isJSONMediaType(accept) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isJSONMediaType reports whether v is application/json or a +json type.
+func isJSONMediaType(v string) bool {
+	mt, _, err := mime.ParseMediaType(strings.TrimSpace(v))
+	if err != nil {
+		return false
+	}
+
+	return mt == "application/json" || strings.HasSuffix(mt, "+json")
+}
+
+// checkFetchOrigin checks an unsafe request by the Fetch Metadata and Origin
+// headers only. Browsers cannot send a cross-origin JSON request without a
+// CORS preflight, and always send Origin with it; requests from non-browser
+// clients, which send neither header, cannot be forged by a third-party site.
+func (cs *csrf) checkFetchOrigin(r *http.Request) error {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return nil
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		if r.Header.Get("Sec-Fetch-Site") != "" {
+			// A browser request from another site without an Origin.
+			return ErrBadOrigin
+		}
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return ErrBadOrigin
+	}
+
+	// Compare against the origin the request was received at, as the scheme
+	// and host are not set on server requests. trustedOrigin does the same.
+	if sameOrigin(cs.originURL(r), u) {
+		return nil
+	}
+
+	valid, err := cs.trustedOrigin(r, u)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrBadOrigin
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIsJSONRequest(t *testing.T) {
+	testTable := []struct {
+		method      string
+		contentType string
+		accept      string
+		json        bool
+	}{
+		{"POST", "application/json", "", true},
+		{"POST", "application/json; charset=utf-8", "", true},
+		{"PUT", "application/merge-patch+json", "", true},
+		{"POST", "application/x-www-form-urlencoded", "application/json", false},
+		{"POST", "text/plain", "", false},
+		{"POST", "", "application/json", false},
+		{"DELETE", "", "text/html, application/json;q=0.9", true},
+		{"DELETE", "", "", false},
+	}
+
+	for _, item := range testTable {
+		r := httptest.NewRequest(item.method, "/", nil)
+		if item.contentType != "" {
+			r.Header.Set("Content-Type", item.contentType)
+		}
+		if item.accept != "" {
+			r.Header.Set("Accept", item.accept)
+		}
+
+		if got := isJSONRequest(r); got != item.json {
+			t.Errorf("%s %q %q: got %v want %v", item.method, item.contentType, item.accept, got, item.json)
+		}
+	}
+}
+
+// TestJSONPolicy tests that JSON API calls are checked by their origin only,
+// while form posts still require a token.
+func TestJSONPolicy(t *testing.T) {
+	p := Protect(testKey, JSONPolicy(PolicyOrigin), TrustedOrigins([]string{"ui.example.com"}))(testHandler)
+
+	testTable := []struct {
+		contentType string
+		fetchSite   string
+		origin      string
+		code        int
+	}{
+		{"application/json", "same-origin", "https://api.example.com", http.StatusOK},
+		{"application/json", "", "https://api.example.com", http.StatusOK},
+		{"application/json", "same-site", "https://ui.example.com", http.StatusOK},
+		{"application/json", "cross-site", "https://evil.example.org", http.StatusForbidden},
+		{"application/json", "cross-site", "", http.StatusForbidden},
+		{"application/json", "", "", http.StatusOK},
+		{"application/x-www-form-urlencoded", "same-origin", "https://api.example.com", http.StatusForbidden},
+	}
+
+	for _, item := range testTable {
+		r := httptest.NewRequest("POST", "https://api.example.com/items", strings.NewReader("{}"))
+		r.Header.Set("Content-Type", item.contentType)
+		if item.fetchSite != "" {
+			r.Header.Set("Sec-Fetch-Site", item.fetchSite)
+		}
+		if item.origin != "" {
+			r.Header.Set("Origin", item.origin)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("%s, %q, %q: got %v want %v", item.contentType, item.fetchSite, item.origin, rr.Code, item.code)
+		}
+	}
+}
+
+// TestJSONPolicyServer tests that trusted origins without a scheme match the
+// scheme of the connection on a TLS server, where the request URL has none.
+func TestJSONPolicyServer(t *testing.T) {
+	p := Protect(testKey, JSONPolicy(PolicyOrigin), TrustedOrigins([]string{"app.example.com"}))(testHandler)
+	srv := httptest.NewTLSServer(p)
+	defer srv.Close()
+
+	for origin, code := range map[string]int{
+		srv.URL:                      http.StatusOK,
+		"https://app.example.com":    http.StatusOK,
+		"http://app.example.com":     http.StatusForbidden,
+		"https://evil.example.org":   http.StatusForbidden,
+		"https://app.example.com.eu": http.StatusForbidden,
+	} {
+		r, err := http.NewRequest("POST", srv.URL+"/items", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Origin", origin)
+
+		resp, err := srv.Client().Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != code {
+			t.Errorf("Origin %s: got %v want %v", origin, resp.StatusCode, code)
+		}
+	}
+}
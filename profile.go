@@ -0,0 +1,135 @@
+package csrf
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CookieProfile overrides cookie attributes for a class of requests, see
+// PlaintextCookie. Fields left at their zero value keep the configured
+// attribute.
+type CookieProfile struct {
+	// Name is the cookie name, see CookieName.
+	Name string
+	// SameSite is the SameSite attribute, see SameSite.
+	SameSite SameSiteMode
+	// Domain is the cookie domain, see Domain.
+	Domain string
+}
+
+// PlaintextCookie sets the cookie attributes for requests that do not arrive
+// over TLS, for applications that serve a legacy plain HTTP path, e.g. on an
+// intranet, alongside public HTTPS. The configured options, e.g.
+// Secure(true), apply to requests over TLS only. Cookies issued to plaintext
+// requests are never Secure, and SameSiteNoneMode is rejected by New.
+//
+// A request counts as TLS if it was received over TLS, or its URL has the
+// https scheme, e.g. as set by a proxy middleware.
+func PlaintextCookie(p CookieProfile) Option {
+	return func(cs *csrf) {
+		cs.opts.PlaintextCookie = &p
+	}
+}
+
+// checkPlaintextSameSite validates that the PlaintextCookie profile does not
+// use SameSiteNoneMode, which browsers only accept for Secure cookies.
+func checkPlaintextSameSite(o options) error {
+	if p := o.PlaintextCookie; p != nil && p.SameSite == SameSiteNoneMode {
+		return fmt.Errorf("%s%w: plaintext cookies cannot be Secure, use another SameSite mode", errorPrefix, ErrInsecureSameSite)
+	}
+
+	return nil
+}
+
+// isTLS reports whether r arrived over TLS.
+func isTLS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.URL.Scheme, "https")
+}
+
+// plaintextCookies returns a copy of the cookie store cookies with the
+// attributes of the PlaintextCookie profile.
+func (cs *csrf) plaintextCookies(cookies *cookieStore) *cookieStore {
+	p := cs.opts.PlaintextCookie
+	plain := *cookies
+	plain.secure = false
+	if p.Name != "" {
+		plain.name = p.Name
+	}
+	if p.SameSite != 0 {
+		plain.sameSite = p.SameSite
+	}
+	if p.Domain != "" {
+		plain.domain = p.Domain
+	}
+
+	return &plain
+}
+
+// cookieDomain returns the cookie domain for r.
+func (cs *csrf) cookieDomain(r *http.Request) string {
+	if p := cs.opts.PlaintextCookie; p != nil && p.Domain != "" && !isTLS(r) {
+		return p.Domain
+	}
+
+	return cs.opts.Domain
+}
+
+// splitStore uses one store for requests over TLS and another for plaintext
+// requests, see PlaintextCookie.
+type splitStore struct {
+	tls   Store
+	plain Store
+}
+
+// store returns the store for r.
+func (ss *splitStore) store(r *http.Request) Store {
+	if r == nil || isTLS(r) {
+		return ss.tls
+	}
+
+	return ss.plain
+}
+
+func (ss *splitStore) Get(r *http.Request) ([]byte, error) {
+	return ss.store(r).Get(r)
+}
+
+// Save saves to the TLS store, as there is no request to select a store by.
+func (ss *splitStore) Save(token []byte, w http.ResponseWriter) error {
+	return ss.tls.Save(token, w)
+}
+
+func (ss *splitStore) SaveRequest(r *http.Request, token []byte, w http.ResponseWriter) error {
+	st := ss.store(r)
+	if rs, ok := st.(requestSaver); ok {
+		return rs.SaveRequest(r, token, w)
+	}
+
+	return st.Save(token, w)
+}
+
+func (ss *splitStore) ExpireDuplicates(r *http.Request, w http.ResponseWriter) error {
+	if de, ok := ss.store(r).(duplicateExpirer); ok {
+		return de.ExpireDuplicates(r, w)
+	}
+
+	return nil
+}
+
+func (ss *splitStore) Touch(r *http.Request, w http.ResponseWriter) error {
+	if t, ok := ss.store(r).(toucher); ok {
+		return t.Touch(r, w)
+	}
+
+	return nil
+}
+
+func (ss *splitStore) Issued(r *http.Request) (time.Time, error) {
+	if is, ok := ss.store(r).(issuer); ok {
+		return is.Issued(r)
+	}
+
+	return time.Time{}, errNotTimed
+}
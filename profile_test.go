@@ -0,0 +1,64 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPlaintextCookie tests that plaintext requests get the cookie of the
+// PlaintextCookie profile, and are verified against it.
+func TestPlaintextCookie(t *testing.T) {
+	var token string
+	s := http.NewServeMux()
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	})
+	m, err := New(testKey, Secure(true), SameSite(SameSiteStrictMode),
+		PlaintextCookie(CookieProfile{Name: "csrf_http", SameSite: SameSiteLaxMode}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := m.Wrap(s)
+
+	issue := func(url string) (*http.Cookie, string) {
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("%s: got %d cookies", url, len(cookies))
+		}
+		return cookies[0], token
+	}
+
+	tlsCookie, _ := issue("https://www.example.com/")
+	if tlsCookie.Name != cookieName || !tlsCookie.Secure || tlsCookie.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("unexpected TLS cookie: %+v", tlsCookie)
+	}
+
+	plainCookie, plainToken := issue("http://intranet.example.com/")
+	if plainCookie.Name != "csrf_http" || plainCookie.Secure || plainCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("unexpected plaintext cookie: %+v", plainCookie)
+	}
+
+	post := func(url string, cookie *http.Cookie) int {
+		r := httptest.NewRequest("POST", url, nil)
+		r.AddCookie(cookie)
+		r.Header.Set("X-CSRF-Token", plainToken)
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	if code := post("http://intranet.example.com/", plainCookie); code != http.StatusOK {
+		t.Fatalf("plaintext POST: got %v want %v", code, http.StatusOK)
+	}
+	plainCookie.Name = cookieName
+	if code := post("http://intranet.example.com/", plainCookie); code != http.StatusForbidden {
+		t.Fatalf("plaintext POST with the TLS cookie name: got %v want %v", code, http.StatusForbidden)
+	}
+
+	if _, err := New(testKey, PlaintextCookie(CookieProfile{SameSite: SameSiteNoneMode})); err == nil {
+		t.Fatal("expected an error for SameSite=None on plaintext cookies")
+	}
+}
@@ -0,0 +1,72 @@
+package csrf
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrBadRefererPath is returned if the Referer of a request to a path
+// restricted with RefererPaths does not begin with an allowed prefix.
+var ErrBadRefererPath = fmt.Errorf("%w: path not allowed", ErrBadReferer)
+
+// refererRule restricts the Referer paths allowed to submit to a path.
+type refererRule struct {
+	path     string
+	prefixes []string
+}
+
+// checkRefererPath requires the Referer of a request to a restricted path to
+// come from a trusted origin and begin with one of its allowed prefixes.
+func (cs *csrf) checkRefererPath(r *http.Request) error {
+	for _, rule := range cs.opts.RefererPaths {
+		if !matchPath(cs.opts.ExcludePathsMode, r.URL.Path, rule.path) {
+			continue
+		}
+
+		referer, err := url.Parse(r.Referer())
+		if err != nil || referer.String() == "" {
+			return ErrNoReferer
+		}
+
+		// The origin of HTTPS requests has already been checked by verify,
+		// if their URL has the scheme. trustedOrigin compares against the
+		// origin the request was received at otherwise.
+		if cs.requestURL(r).Scheme != "https" {
+			valid, err := cs.trustedOrigin(r, referer)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				return ErrBadReferer
+			}
+		}
+
+		if !hasPathPrefix(referer.EscapedPath(), rule.prefixes) {
+			return ErrBadRefererPath
+		}
+	}
+
+	return nil
+}
+
+// hasPathPrefix reports whether the cleaned p is one of prefixes or below
+// one of them, so that "/checkout" allows "/checkout/cart" but neither
+// "/checkouts" nor "/checkout/../admin".
+func hasPathPrefix(p string, prefixes []string) bool {
+	if p == "" {
+		p = "/"
+	}
+	p = path.Clean(p)
+
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
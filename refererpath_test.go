@@ -0,0 +1,133 @@
+package csrf
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRefererPaths tests that restricted paths only accept requests submitted
+// from the allowed pages, and that other paths are not affected.
+func TestRefererPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		referer string
+		want    error
+	}{
+		{"allowed", "https://example.com/payments", "https://example.com/checkout/cart?step=2", nil},
+		{"prefix itself", "https://example.com/payments", "https://example.com/checkout", nil},
+		{"other page", "https://example.com/payments", "https://example.com/redirect?to=x", ErrBadRefererPath},
+		{"sibling prefix", "https://example.com/payments", "https://example.com/checkouts", ErrBadRefererPath},
+		{"dot segments", "https://example.com/payments", "https://example.com/checkout/../admin", ErrBadRefererPath},
+		{"no referer", "https://example.com/payments", "", ErrNoReferer},
+		{"plain http", "http://example.com/payments", "http://example.com/checkout/cart", nil},
+		{"plain http other origin", "http://example.com/payments", "http://evil.com/checkout/cart", ErrBadReferer},
+		{"unrestricted path", "https://example.com/profile", "https://example.com/redirect", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reason error
+			var token string
+			p := Protect(testKey,
+				RefererPaths("/payments", "/checkout"),
+				ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					reason = FailureReason(r)
+					w.WriteHeader(http.StatusForbidden)
+				})),
+			)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				token = Token(r)
+			}))
+
+			rr := httptest.NewRecorder()
+			p.ServeHTTP(rr, httptest.NewRequest("GET", tt.url, nil))
+
+			r := httptest.NewRequest("POST", tt.url, nil)
+			setCookie(rr, r)
+			r.Header.Set("X-CSRF-Token", token)
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+
+			rr = httptest.NewRecorder()
+			p.ServeHTTP(rr, r)
+
+			if tt.want == nil {
+				if rr.Code != http.StatusOK {
+					t.Fatalf("request rejected: got %v, %v", rr.Code, reason)
+				}
+				return
+			}
+			if rr.Code != http.StatusForbidden || !errors.Is(reason, tt.want) {
+				t.Fatalf("got %v, %v want %v", rr.Code, reason, tt.want)
+			}
+		})
+	}
+}
+
+// TestRefererPathsServer tests restricted paths on a real server, where the
+// request URL has neither scheme nor host, over plain HTTP and TLS.
+func TestRefererPathsServer(t *testing.T) {
+	for _, tls := range []bool{false, true} {
+		var reason error
+		p := Protect(testKey,
+			Secure(tls),
+			RefererPaths("/payments", "/checkout"),
+			ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reason = FailureReason(r)
+				w.WriteHeader(http.StatusForbidden)
+			})),
+		)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, Token(r))
+		}))
+
+		srv := httptest.NewUnstartedServer(p)
+		if tls {
+			srv.StartTLS()
+		} else {
+			srv.Start()
+		}
+		defer srv.Close()
+
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := srv.Client()
+		client.Jar = jar
+
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		for referer, want := range map[string]int{
+			srv.URL + "/checkout/cart":       http.StatusOK,
+			srv.URL + "/redirect":            http.StatusForbidden,
+			"https://evil.com/checkout/cart": http.StatusForbidden,
+		} {
+			r, err := http.NewRequest("POST", srv.URL+"/payments", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("X-CSRF-Token", string(token))
+			r.Header.Set("Referer", referer)
+
+			resp, err := client.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != want {
+				t.Errorf("TLS %v, Referer %s: got %v (%v) want %v", tls, referer, resp.StatusCode, reason, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package csrf
+
+import "net/http"
+
+// RetryHeader is the request header a client sets when retrying a request
+// with the fresh token from a rejection (see RefreshOnFailure). Rejected
+// retries carry no fresh token, so that a client retries at most once.
+const RetryHeader = "X-CSRF-Retry"
+
+// refreshToken attaches a fresh masked token to the response for a rejected
+// request, issuing a new cookie if the request has no valid one, and saves it
+// in the request context for the error handler.
+func (cs *csrf) refreshToken(w http.ResponseWriter, r *http.Request) *http.Request {
+	if !cs.opts.RefreshOnFailure || r.Header.Get(RetryHeader) != "" {
+		return r
+	}
+
+	token, _ := contextGet(r, tokenKey)
+	masked, _ := token.(string)
+	if masked == "" {
+		realToken, err := cs.generateToken()
+		if err != nil {
+			return r
+		}
+		if err := cs.save(r, realToken, w); err != nil {
+			return r
+		}
+		masked = cs.mask(realToken, r)
+		r = cs.saveValue(r, tokenKey, masked)
+	}
+
+	w.Header().Set(cs.opts.RequestHeader, masked)
+	markVary(r)
+
+	return cs.saveValue(r, refreshKey, masked)
+}
+
+// refreshedToken returns the fresh token attached to a rejected request, if
+// any.
+func refreshedToken(r *http.Request) string {
+	if val, err := contextGet(r, refreshKey); err == nil {
+		if token, ok := val.(string); ok {
+			return token
+		}
+	}
+
+	return ""
+}
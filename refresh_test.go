@@ -0,0 +1,59 @@
+package csrf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRefreshOnFailure tests that a rejected request carries a fresh token a
+// client can retry with once.
+func TestRefreshOnFailure(t *testing.T) {
+	p := Protect(testKey, RefreshOnFailure())(testHandler)
+
+	post := func(token string, retry bool, cookies *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-CSRF-Token", token)
+		if retry {
+			r.Header.Set(RetryHeader, "1")
+		}
+		if cookies != nil {
+			setCookie(cookies, r)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+		return rr
+	}
+
+	// The first request has no cookie and a bogus token.
+	rr := post("dG9rZW4=", false, nil)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("bogus token not rejected: got %v", rr.Code)
+	}
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", rr.Body.String(), err)
+	}
+	token := rr.Header().Get("X-CSRF-Token")
+	if token == "" || body.Token != token {
+		t.Fatalf("no fresh token: header %q, body %q", token, body.Token)
+	}
+
+	// A failing retry gets no fresh token.
+	if rr := post("dG9rZW4=", true, rr); rr.Code != http.StatusForbidden || rr.Header().Get("X-CSRF-Token") != "" {
+		t.Fatalf("failed retry refreshed: got %v, %q", rr.Code, rr.Header().Get("X-CSRF-Token"))
+	}
+
+	if rr := post(token, true, rr); rr.Code != http.StatusOK {
+		t.Fatalf("retry with fresh token failed: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
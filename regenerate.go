@@ -0,0 +1,195 @@
+package csrf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrResponseWritten is returned by Regenerate if the handler has already
+// started writing the response, so that the cookie can no longer be set.
+var ErrResponseWritten = errors.New("CSRF cookie cannot be set after the response is written")
+
+// writerKey is the context key of the responseWriter of a request.
+var writerKey = contextKey{"gorilla.csrf.Writer"}
+
+// Regenerate replaces the real token of the session of r with a new one, e.g.
+// after login, so that tokens issued before are rejected. Token and
+// TemplateField return the new token for r afterwards.
+//
+// The cookie with the new token is written with the response headers, on the
+// first write of the handler, so Regenerate can be called at any point before
+// that, as often as needed. It returns ErrResponseWritten once the handler has
+// written to the response.
+func Regenerate(r *http.Request) error {
+	rw, ok := r.Context().Value(writerKey).(*responseWriter)
+	if !ok {
+		return errors.New(errorPrefix + "Regenerate requires a request served by the middleware")
+	}
+
+	return rw.regenerate()
+}
+
+// responseWriter wraps the http.ResponseWriter passed to the handler, and
+// writes the cookie of a regenerated token before the response headers. It
+// implements http.Flusher, http.Hijacker, http.Pusher and io.ReaderFrom,
+// which fail as in the standard library if the wrapped writer does not.
+type responseWriter struct {
+	http.ResponseWriter
+	cs *csrf
+	r  *http.Request
+
+	mu      sync.Mutex
+	wrote   bool
+	pending []byte
+	masked  string
+
+	// realToken is the real token of the request, see StreamTokenRefresh.
+	realToken []byte
+	// refreshAt is the time the next token event is due, and flushed is set
+	// once the handler flushes, i.e. streams the response.
+	refreshAt time.Time
+	flushed   bool
+}
+
+// wrapWriter returns w wrapped for r with the real token of r, and r with the
+// wrapper saved in its context.
+func (cs *csrf) wrapWriter(w http.ResponseWriter, r *http.Request, realToken []byte) (*responseWriter, *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, cs: cs, r: r, realToken: realToken}
+	if cs.opts.StreamRefresh > 0 {
+		rw.refreshAt = timeNow().Add(cs.opts.StreamRefresh)
+	}
+	return rw, cs.saveValue(r, writerKey, rw)
+}
+
+// regenerate generates a new real token, to be saved on the first write.
+func (rw *responseWriter) regenerate() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.wrote {
+		return ErrResponseWritten
+	}
+
+	token, err := rw.cs.generateToken()
+	if err != nil {
+		return err
+	}
+	rw.pending = token
+	rw.masked = rw.cs.mask(token, rw.r)
+
+	return nil
+}
+
+// token returns the masked regenerated token, if any.
+func (rw *responseWriter) token() string {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	return rw.masked
+}
+
+// commit saves a regenerated token, replacing the cookie issued before, if
+// any. It is called before the response headers are written. As the request
+// can no longer be rejected, errors are only logged.
+func (rw *responseWriter) commit() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.wrote {
+		return
+	}
+	rw.wrote = true
+	if rw.pending == nil {
+		return
+	}
+
+	// Replace the cookie issued before, if any.
+	dropSetCookies(rw.ResponseWriter.Header(), rw.cs.tokenCookieNames())
+	if err := rw.cs.save(boundedRequest(rw.r), rw.pending, rw.ResponseWriter); err != nil {
+		rw.cs.warnf("%s %s: %v", rw.r.Method, rw.r.URL.Path, fmt.Errorf("%w: %w", ErrCookieWrite, err))
+		return
+	}
+	markVary(rw.r)
+}
+
+// dropSetCookies removes the Set-Cookie headers of the named cookies from h.
+func dropSetCookies(h http.Header, names []string) {
+	var kept []string
+	for _, v := range h.Values("Set-Cookie") {
+		drop := false
+		for _, name := range names {
+			drop = drop || strings.HasPrefix(v, name+"=")
+		}
+		if !drop {
+			kept = append(kept, v)
+		}
+	}
+
+	if len(kept) == 0 {
+		h.Del("Set-Cookie")
+		return
+	}
+	h["Set-Cookie"] = kept
+}
+
+// tokenCookieNames returns the names of the cookies holding the real token.
+func (cs *csrf) tokenCookieNames() []string {
+	names := []string{cs.opts.CookieName}
+	if p := cs.opts.PlaintextCookie; p != nil && p.Name != "" {
+		names = append(names, p.Name)
+	}
+
+	return names
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	rw.commit()
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.commit()
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	rw.commit()
+	return io.Copy(rw.ResponseWriter, src)
+}
+
+func (rw *responseWriter) Flush() {
+	rw.commit()
+	rw.refreshStream()
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.commit()
+	if h, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+
+	return nil, nil, http.ErrNotSupported
+}
+
+func (rw *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := rw.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,124 @@
+package csrf
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRegenerate tests that a token regenerated late in a request replaces
+// the cookie issued before, and that only the new token is accepted with the
+// new cookie.
+func TestRegenerate(t *testing.T) {
+	var old, token string
+	p := Protect(testKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		old = Token(r)
+		if err := Regenerate(r); err != nil {
+			t.Fatalf("Regenerate: %v", err)
+		}
+		token = Token(r)
+		io.WriteString(w, "ok")
+	}))
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if cookies := rr.Result().Cookies(); len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	if token == "" || token == old {
+		t.Fatalf("Token after Regenerate: got %q, old %q", token, old)
+	}
+
+	for _, tc := range []struct {
+		token string
+		want  int
+	}{
+		{token, http.StatusOK},
+		{old, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set(headerName, tc.token)
+		setCookie(rr, r)
+
+		res := httptest.NewRecorder()
+		Protect(testKey)(testHandler).ServeHTTP(res, r)
+		if res.Code != tc.want {
+			t.Fatalf("POST: got %v want %v", res.Code, tc.want)
+		}
+	}
+}
+
+// TestRegenerateNoWrite tests that the cookie of a regenerated token is set
+// if the handler does not write a response.
+func TestRegenerateNoWrite(t *testing.T) {
+	var token string
+	p := Protect(testKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Regenerate(r)
+		token = Token(r)
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+	setCookie(rr, r)
+
+	cs, err := newCSRF(testKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	realToken, err := cs.st.Get(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, _ := decodeToken(token, encodedTokenLength)
+	if !compareTokens(unmask(issued), realToken) {
+		t.Fatal("cookie does not hold the regenerated token")
+	}
+}
+
+// TestRegenerateAfterWrite tests that Regenerate fails once the response is
+// written, and outside the middleware.
+func TestRegenerateAfterWrite(t *testing.T) {
+	var err error
+	p := Protect(testKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		err = Regenerate(r)
+	}))
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !errors.Is(err, ErrResponseWritten) {
+		t.Fatalf("got %v want %v", err, ErrResponseWritten)
+	}
+
+	if err := Regenerate(httptest.NewRequest("GET", "/", nil)); err == nil {
+		t.Fatal("Regenerate without the middleware did not fail")
+	}
+}
+
+// TestResponseWriterInterfaces tests that the optional interfaces of the
+// response writer remain available to the handler.
+func TestResponseWriterInterfaces(t *testing.T) {
+	p := Protect(testKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Hijacker); !ok {
+			t.Error("not a http.Hijacker")
+		}
+		if _, ok := w.(http.Pusher); !ok {
+			t.Error("not a http.Pusher")
+		}
+		if _, ok := w.(io.ReaderFrom); !ok {
+			t.Error("not an io.ReaderFrom")
+		}
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if !rr.Flushed {
+		t.Fatal("response not flushed")
+	}
+}
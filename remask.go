@@ -0,0 +1,75 @@
+package csrf
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// TokenOption modifies a single call of TokenWith or TemplateFieldWith.
+type TokenOption int
+
+// Token options
+const (
+	// FreshMask masks the token with a new one-time pad for this call,
+	// instead of returning the token masked once per request. Pages with many
+	// forms trade some CPU for a wider BREACH safety margin, as no two forms
+	// on the page carry the same token.
+	FreshMask TokenOption = iota + 1
+)
+
+// freshMaskArg is the template argument for FreshMask, see TemplateFuncs.
+const freshMaskArg = "fresh"
+
+// TokenWith is like Token, but applies the given options to this call, e.g.
+// FreshMask.
+func TokenWith(r *http.Request, opts ...TokenOption) string {
+	token := Token(r)
+	for _, opt := range opts {
+		if opt == FreshMask {
+			token = remask(token)
+		}
+	}
+
+	return token
+}
+
+// TemplateFieldWith is like TemplateField, but applies the given options to
+// the token, e.g. FreshMask.
+func TemplateFieldWith(r *http.Request, opts ...TokenOption) template.HTML {
+	name := FieldNameOf(r)
+	if name == "" {
+		return ""
+	}
+
+	return template.HTML(hiddenField(name, TokenWith(r, opts...)))
+}
+
+// remask masks the real token of a masked token with a new one-time pad. It
+// returns the token unchanged if it cannot be decoded, e.g. if it is empty.
+func remask(token string) string {
+	issued, err := decodeToken(token, encodedTokenLength)
+	if err != nil {
+		return token
+	}
+
+	if masked := mask(unmask(issued), nil); masked != "" {
+		return masked
+	}
+
+	return token
+}
+
+// templateTokenOptions parses the optional arguments of the template
+// functions, e.g. "fresh" for FreshMask.
+func templateTokenOptions(args []string) ([]TokenOption, error) {
+	opts := make([]TokenOption, 0, len(args))
+	for _, arg := range args {
+		if arg != freshMaskArg {
+			return nil, fmt.Errorf("%sunknown token option %q", errorPrefix, arg)
+		}
+		opts = append(opts, FreshMask)
+	}
+
+	return opts, nil
+}
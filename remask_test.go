@@ -0,0 +1,59 @@
+package csrf
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// TestFreshMask tests that FreshMask masks the token anew for each call, and
+// that each fresh token is accepted.
+func TestFreshMask(t *testing.T) {
+	tmpl := template.Must(template.New("forms").Funcs(TemplateFuncs()).Parse(
+		`{{ csrfField . }}{{ csrfField . }}{{ csrfField . "fresh" }}{{ csrfToken . "fresh" }}`))
+
+	var token, fresh string
+	var page bytes.Buffer
+	p := Protect(testKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, fresh = Token(r), TokenWith(r, FreshMask)
+		if err := tmpl.Execute(&page, r); err != nil {
+			t.Fatal(err)
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	tokens := regexp.MustCompile(`value="([^"]+)"`).FindAllStringSubmatch(page.String(), -1)
+	if len(tokens) != 3 || tokens[0][1] != tokens[1][1] || tokens[1][1] == tokens[2][1] {
+		t.Fatalf("unexpected fields: got %q", page.String())
+	}
+
+	for _, candidate := range []string{fresh, tokens[2][1]} {
+		if candidate == token {
+			t.Fatal("fresh token equals the request token")
+		}
+
+		r := httptest.NewRequest("POST", "/", nil)
+		setCookie(rr, r)
+		r.Header.Set("X-CSRF-Token", candidate)
+
+		post := httptest.NewRecorder()
+		p.ServeHTTP(post, r)
+		if post.Code != http.StatusOK {
+			t.Fatalf("fresh token rejected: got %v", post.Code)
+		}
+	}
+
+	if err := template.Must(template.New("bad").Funcs(TemplateFuncs()).Parse(`{{ csrfToken . "stale" }}`)).
+		Execute(&page, httptest.NewRequest("GET", "/", nil)); err == nil {
+		t.Fatal("unknown token option accepted")
+	}
+
+	if TokenWith(httptest.NewRequest("GET", "/", nil), FreshMask) != "" {
+		t.Fatal("token returned without the middleware")
+	}
+}
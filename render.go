@@ -0,0 +1,160 @@
+package csrf
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrorRenderer writes the response to a request rejected with the given
+// status and reason, in the media type it is registered for with
+// RenderErrors.
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, status int, reason error)
+
+// defaultRenderers are the renderers of the default error handler, keyed by
+// media type.
+var defaultRenderers = map[string]ErrorRenderer{
+	"text/plain":       RenderText,
+	"application/json": RenderJSON,
+	"application/xml":  RenderXML,
+	"text/xml":         RenderXML,
+}
+
+// errorBody is the body of a rejection rendered as JSON or XML.
+type errorBody struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Code    int      `json:"code" xml:"code"`
+	Message string   `json:"message" xml:"message"`
+	// Failure is the stable code of the failure, see FailureCode.
+	Failure string `json:"failure,omitempty" xml:"failure,omitempty"`
+	// Token is the fresh token attached with RefreshOnFailure, if any.
+	Token string `json:"token,omitempty" xml:"token,omitempty"`
+}
+
+func newErrorBody(r *http.Request, status int, reason error) errorBody {
+	body := errorBody{Code: status, Token: refreshedToken(r)}
+	if reason != nil {
+		body.Message = reason.Error()
+		body.Failure = failureCode(r, reason)
+	}
+
+	return body
+}
+
+// RenderText renders a rejection as plain text, e.g. "Forbidden - CSRF token
+// invalid".
+func RenderText(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	http.Error(w, fmt.Sprintf("%s - %s", http.StatusText(status), reason), status)
+}
+
+// RenderJSON renders a rejection as JSON, e.g.
+// {"code":403,"message":"CSRF token invalid"}, including the fresh token of
+// RefreshOnFailure, if any.
+func RenderJSON(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newErrorBody(r, status, reason))
+}
+
+// RenderXML renders a rejection as XML, e.g.
+// <error><code>403</code><message>CSRF token invalid</message></error>,
+// including the fresh token of RefreshOnFailure, if any.
+func RenderXML(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(newErrorBody(r, status, reason))
+}
+
+// errorHandler returns the default error handler, which renders rejections
+// with the renderer of the media type preferred by the Accept header of the
+// request. Requests that do not ask for a specific supported type get JSON if
+// they send JSON, and plain text otherwise.
+func errorHandler(renderers map[string]ErrorRenderer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render := negotiateRenderer(renderers, r.Header.Get("Accept"))
+		if render == nil {
+			render = RenderText
+			if isXHR(r) {
+				render = renderers["application/json"]
+			}
+		}
+
+		render(w, r, http.StatusForbidden, FailureReason(r))
+	})
+}
+
+// mergeRenderers returns the default renderers, overridden and extended by
+// custom.
+func mergeRenderers(custom map[string]ErrorRenderer) map[string]ErrorRenderer {
+	if len(custom) == 0 {
+		return defaultRenderers
+	}
+
+	renderers := make(map[string]ErrorRenderer, len(defaultRenderers)+len(custom))
+	for mt, fn := range defaultRenderers {
+		renderers[mt] = fn
+	}
+	for mt, fn := range custom {
+		renderers[mt] = fn
+	}
+
+	return renderers
+}
+
+// negotiateRenderer returns the renderer of the media type preferred by the
+// Accept header, or nil if no specific supported type is accepted. Ranges
+// such as "application/*" match the supported types in lexical order; "*/*"
+// is left to the caller.
+func negotiateRenderer(renderers map[string]ErrorRenderer, accept string) ErrorRenderer {
+	type acceptedType struct {
+		mediaType string
+		q         float64
+	}
+
+	var accepted []acceptedType
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 && mt != "*/*" {
+			accepted = append(accepted, acceptedType{mt, q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+
+	for _, a := range accepted {
+		if fn, ok := renderers[a.mediaType]; ok {
+			return fn
+		}
+
+		if prefix, ok := strings.CutSuffix(a.mediaType, "/*"); ok {
+			types := make([]string, 0, len(renderers))
+			for mt := range renderers {
+				if strings.HasPrefix(mt, prefix+"/") {
+					types = append(types, mt)
+				}
+			}
+			if len(types) > 0 {
+				sort.Strings(types)
+				return renderers[types[0]]
+			}
+		}
+	}
+
+	return nil
+}
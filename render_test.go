@@ -0,0 +1,72 @@
+package csrf
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestErrorRenderers tests that the default error handler renders rejections
+// in the media type negotiated by the Accept header.
+func TestErrorRenderers(t *testing.T) {
+	problem := func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+	}
+	p := Protect(testKey, RenderErrors("application/problem+json", problem))(testHandler)
+
+	for _, item := range []struct {
+		accept      string
+		contentType string
+		want        string
+	}{
+		{"", "", "text/plain; charset=utf-8"},
+		{"*/*", "application/json", "application/json"},
+		{"application/json", "", "application/json"},
+		{"text/xml", "", "application/xml; charset=utf-8"},
+		{"text/html;q=0.9, application/xml", "", "application/xml; charset=utf-8"},
+		{"application/xml;q=0.5, text/plain", "", "text/plain; charset=utf-8"},
+		{"application/json;q=0, application/xml", "application/json", "application/xml; charset=utf-8"},
+		{"application/problem+json", "", "application/problem+json"},
+		{"text/html", "", "text/plain; charset=utf-8"},
+	} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Accept", item.accept)
+		if item.contentType != "" {
+			r.Header.Set("Content-Type", item.contentType)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("%q: got %v want %v", item.accept, rr.Code, http.StatusForbidden)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != item.want {
+			t.Errorf("%q: got %q want %q", item.accept, ct, item.want)
+		}
+	}
+}
+
+// TestRenderXML tests that XML rejections are parseable.
+func TestRenderXML(t *testing.T) {
+	rr := httptest.NewRecorder()
+	RenderXML(rr, httptest.NewRequest("POST", "/", nil), http.StatusForbidden, ErrBadToken)
+
+	var body errorBody
+	if err := xml.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != http.StatusForbidden || body.Message != ErrBadToken.Error() || body.Token != "" {
+		t.Fatalf("XML body: got %+v", body)
+	}
+
+	rr = httptest.NewRecorder()
+	RenderJSON(rr, httptest.NewRequest("POST", "/", nil), http.StatusForbidden, ErrBadToken)
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || strings.Contains(rr.Body.String(), `"token"`) {
+		t.Fatalf("JSON body: got %q, %v", rr.Body.String(), err)
+	}
+}
@@ -0,0 +1,126 @@
+package csrf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// reportQueueSize bounds the number of violation reports waiting to be
+	// sent; further reports are dropped.
+	reportQueueSize = 256
+	// reportTimeout bounds a single POST of a violation report.
+	reportTimeout = 5 * time.Second
+)
+
+// ReportURI sends a JSON violation report for requests failing CSRF
+// validation, rejected and reported alike (see ReportOnly), to endpoint, much
+// like the report-uri directive of a Content-Security-Policy. sampleRate is
+// the fraction of failures reported, from 0 to 1, e.g. 0.1 for every tenth
+// failure on average.
+//
+// Reports are POSTed as application/json in the background, one at a time,
+// and never delay the response: reports are dropped while too many are
+// waiting to be sent, or if the endpoint fails. Each report holds the audit
+// Event of the failure:
+//
+//	{"csrf-report":{"time":"...","action":"report","reason":"CSRF token invalid","code":"CSRF003_BAD_TOKEN",...}}
+//
+// The reports include request paths and client addresses: use an internal
+// endpoint.
+func ReportURI(endpoint string, sampleRate float64) Option {
+	return func(cs *csrf) {
+		cs.opts.ReportURI = endpoint
+		cs.opts.ReportSampleRate = sampleRate
+	}
+}
+
+// violationReport is the body of a violation report.
+type violationReport struct {
+	Report Event `json:"csrf-report"`
+}
+
+// reporter sends sampled violation reports to the ReportURI endpoint through
+// an AsyncEventSink.
+type reporter struct {
+	rate  float64
+	async *AsyncEventSink
+	// failed counts the reports the endpoint failed to accept.
+	failed atomic.Int64
+}
+
+// newReporter returns a reporter sending to endpoint.
+func newReporter(endpoint string, rate float64, logf func(string, ...interface{})) *reporter {
+	rp := &reporter{rate: rate}
+	rp.async = AsyncSink(&reportSink{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: reportTimeout},
+		logf:     logf,
+		failed:   &rp.failed,
+	}, reportQueueSize)
+
+	return rp
+}
+
+// report queues a report of e, if sampled. It never blocks.
+func (rp *reporter) report(e Event) {
+	if rp.rate < 1 && rand.Float64() >= rp.rate {
+		return
+	}
+
+	rp.async.Emit(e)
+}
+
+// dropped returns the number of reports dropped, because the queue was full
+// or the endpoint failed.
+func (rp *reporter) dropped() int64 {
+	return rp.async.Dropped() + rp.failed.Load()
+}
+
+// reportSink is an EventSink POSTing violation reports to an endpoint. It is
+// only called from the goroutine of an AsyncEventSink.
+type reportSink struct {
+	endpoint string
+	client   *http.Client
+	logf     func(format string, args ...interface{})
+	failed   *atomic.Int64
+	failing  bool
+}
+
+// Emit sends the report of e. Errors are logged when the endpoint starts
+// failing, not for every report.
+func (rs *reportSink) Emit(e Event) {
+	err := rs.send(e)
+	if err != nil {
+		rs.failed.Add(1)
+		if !rs.failing {
+			rs.logf("sending violation report: %v", err)
+		}
+	}
+	rs.failing = err != nil
+}
+
+// send POSTs the report of e.
+func (rs *reportSink) send(e Event) error {
+	body, err := json.Marshal(violationReport{Report: e})
+	if err != nil {
+		return err
+	}
+
+	resp, err := rs.client.Post(rs.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", rs.endpoint, resp.Status)
+	}
+
+	return nil
+}
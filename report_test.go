@@ -0,0 +1,54 @@
+package csrf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestReportURI tests that failures are reported to the endpoint in the
+// background, and that a sample rate of 0 reports nothing.
+func TestReportURI(t *testing.T) {
+	reports := make(chan violationReport, 1)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report violationReport
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected report request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Error(err)
+		}
+		reports <- report
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer endpoint.Close()
+
+	p := Protect(testKey, ReportURI(endpoint.URL, 1), ReportOnly())(testHandler)
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("POST", "/form", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	select {
+	case report := <-reports:
+		e := report.Report
+		if e.Action != ActionReport || e.Code != CodeNoToken || e.Path != "/form" {
+			t.Fatalf("unexpected report: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no report received")
+	}
+
+	p = Protect(testKey, ReportURI(endpoint.URL, 0))(testHandler)
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/form", nil))
+
+	select {
+	case report := <-reports:
+		t.Fatalf("unsampled failure reported: %+v", report.Report)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,69 @@
+package csrf
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parsePrefixes parses CIDR prefixes, e.g. "10.0.0.0/8" or "2001:db8::/32".
+// Single addresses are taken as prefixes of their full length.
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("%sinvalid report-only address %q: %v", errorPrefix, cidr, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%sinvalid report-only prefix %q: %v", errorPrefix, cidr, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// reportOnly reports whether validation failures of r are to be reported
+// instead of rejected, either as decided by the EnforcementCallback, for all
+// requests or because r comes from one of the ReportOnlyFrom prefixes.
+func (cs *csrf) reportOnly(r *http.Request) bool {
+	switch cs.enforcement(r) {
+	case EnforceReject:
+		return false
+	case EnforceReportOnly:
+		return true
+	}
+	if cs.opts.ReportOnly || (cs.opts.LegacyReportOnly && cs.isLegacyPath(r)) {
+		return true
+	}
+	if len(cs.reportPrefixes) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, p := range cs.reportPrefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
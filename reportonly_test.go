@@ -0,0 +1,56 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestReportOnlyFrom tests that failures are reported for requests from the
+// configured prefixes and rejected for all other clients.
+func TestReportOnlyFrom(t *testing.T) {
+	p := Protect(testKey, ReportOnlyFrom("10.1.0.0/16", "2001:db8::/32", "192.0.2.7"))(testHandler)
+
+	for _, item := range []struct {
+		remote string
+		code   int
+	}{
+		{"10.1.2.3:1234", http.StatusOK},
+		{"[::ffff:10.1.2.3]:1234", http.StatusOK},
+		{"[2001:db8::1]:443", http.StatusOK},
+		{"192.0.2.7:80", http.StatusOK},
+		{"192.0.2.8:80", http.StatusForbidden},
+		{"10.2.0.1:1234", http.StatusForbidden},
+		{"garbage", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = item.remote
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("%s: got %v want %v", item.remote, rr.Code, item.code)
+		}
+	}
+}
+
+// TestReportOnlyFromInvalid tests that New rejects invalid prefixes.
+func TestReportOnlyFromInvalid(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/33", "office", "10.0.0/8"} {
+		if _, err := New(testKey, ReportOnlyFrom(cidr)); err == nil {
+			t.Errorf("%q: invalid prefix not rejected", cidr)
+		}
+	}
+
+	if _, err := New(testKey, ReportOnlyFrom("10.0.0.0/8", "::1")); err != nil {
+		t.Fatalf("valid prefixes rejected: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Protect did not panic on an invalid prefix")
+		}
+	}()
+	Protect(testKey, ReportOnlyFrom("office"))(testHandler)
+}
@@ -0,0 +1,55 @@
+package csrf
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// User agents that reject or mishandle cookies with SameSite=None, see
+// https://www.chromium.org/updates/same-site/incompatible-clients.
+var (
+	iOS12UA          = regexp.MustCompile(`\(iP.+; CPU .*OS 12[_\d]*.*\) AppleWebKit/`)
+	macOS1014UA      = regexp.MustCompile(`\(Macintosh;.*Mac OS X 10_14[_\d]*.*\) AppleWebKit/`)
+	safariUA         = regexp.MustCompile(`Version/.* Safari/`)
+	macEmbeddedUA    = regexp.MustCompile(`^Mozilla/[\.\d]+ \(Macintosh;.*Mac OS X [_\d]+\) AppleWebKit/[\.\d]+ \(KHTML, like Gecko\)$`)
+	chromiumUA       = regexp.MustCompile(`Chrom(?:e|ium)`)
+	chromiumVersion  = regexp.MustCompile(`Chrom(?:e|ium)/(\d+)\.`)
+	ucBrowserVersion = regexp.MustCompile(`UCBrowser/(\d+)\.(\d+)\.(\d+)\.`)
+)
+
+// sameSiteNoneIncompatible reports whether the user agent ua is known to
+// reject cookies with SameSite=None, or to treat them as SameSite=Strict:
+// Safari on iOS 12 and macOS 10.14, Chrome 51 to 66 and UC Browser before
+// 12.13.2. Such cookies have to be sent without the SameSite attribute.
+func sameSiteNoneIncompatible(ua string) bool {
+	if iOS12UA.MatchString(ua) {
+		return true
+	}
+
+	if macOS1014UA.MatchString(ua) &&
+		(safariUA.MatchString(ua) && !chromiumUA.MatchString(ua) || macEmbeddedUA.MatchString(ua)) {
+		return true
+	}
+
+	if m := chromiumVersion.FindStringSubmatch(ua); m != nil {
+		if v, _ := strconv.Atoi(m[1]); v >= 51 && v <= 66 {
+			return true
+		}
+	}
+
+	if m := ucBrowserVersion.FindStringSubmatch(ua); m != nil {
+		var v [3]int
+		for i := range v {
+			v[i], _ = strconv.Atoi(m[i+1])
+		}
+		if v[0] != 12 {
+			return v[0] < 12
+		}
+		if v[1] != 13 {
+			return v[1] < 13
+		}
+		return v[2] < 2
+	}
+
+	return false
+}
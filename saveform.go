@@ -0,0 +1,147 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// maxSavedForm is the maximum length of the encoded form cookie. The values of
+// larger forms are not saved.
+const maxSavedForm = 3072
+
+// savedFormKey is the context key of the values of a saved form.
+var savedFormKey = contextKey{"gorilla.csrf.SavedForm"}
+
+// PreserveForm saves the values of a form POST rejected for a missing, invalid
+// or expired token in an encrypted cookie that expires after ttl, so that the
+// page the user retries from can re-populate the form with SavedForm instead
+// of losing long input to a CSRF expiry.
+//
+// Forms are only saved if the request passes the Sec-Fetch-Site and Origin
+// checks of PolicyOrigin, so that other sites cannot plant values, and if
+// their encoding fits into a cookie of 3 KiB. Files, the token field and
+// fields whose names contain "password" are never saved. The cookie is removed
+// once an unsafe request passes the check. Defaults to 0 (disabled).
+func PreserveForm(ttl time.Duration) Option {
+	return func(cs *csrf) {
+		cs.opts.PreserveForm = ttl
+	}
+}
+
+// SavedForm returns the form values saved with PreserveForm for the session of
+// r, or nil if there are none. In the error handler, it returns the values of
+// the rejected form, so the form can be rendered again right away.
+func SavedForm(r *http.Request) url.Values {
+	if val, err := contextGet(r, savedFormKey); err == nil {
+		if form, ok := val.(url.Values); ok {
+			return form
+		}
+	}
+
+	return nil
+}
+
+// newFormCodec returns the codec that encrypts saved forms with keys derived
+// from the authentication key.
+func newFormCodec(key []byte, ttl time.Duration) *securecookie.SecureCookie {
+	sc := securecookie.New(hkdfSHA256(key, "form authentication"), hkdfSHA256(key, "form encryption"))
+	sc.SetSerializer(securecookie.JSONEncoder{})
+	sc.MaxAge(int(ttl / time.Second))
+
+	return sc
+}
+
+// formCookieName returns the name of the cookie holding a saved form.
+func (cs *csrf) formCookieName() string {
+	return cs.opts.CookieName + "_form"
+}
+
+// savesForm reports whether the values of r rejected with err are saved.
+func (cs *csrf) savesForm(r *http.Request, err error) bool {
+	if cs.forms == nil || r.PostForm == nil {
+		return false
+	}
+	if !errors.Is(err, ErrNoToken) && !errors.Is(err, ErrBadToken) &&
+		!errors.Is(err, ErrTokenExpired) && !errors.Is(err, ErrBadCookie) {
+		return false
+	}
+
+	return cs.checkFetchOrigin(r) == nil
+}
+
+// saveForm saves the form values of r, rejected with err, in the form cookie
+// and in the context of r.
+func (cs *csrf) saveForm(w http.ResponseWriter, r *http.Request, err error) *http.Request {
+	if !cs.savesForm(r, err) {
+		return r
+	}
+
+	form := url.Values{}
+	for name, values := range r.PostForm {
+		if name == cs.opts.FieldName || strings.Contains(strings.ToLower(name), "password") {
+			continue
+		}
+		form[name] = values
+	}
+	if len(form) == 0 {
+		return r
+	}
+
+	encoded, err := cs.forms.Encode(cs.formCookieName(), form)
+	if err != nil || len(encoded) > maxSavedForm {
+		return r
+	}
+	cs.setFormCookie(w, encoded, int(cs.opts.PreserveForm/time.Second))
+
+	return cs.saveValue(r, savedFormKey, form)
+}
+
+// loadForm saves the form values in the form cookie of r, if any, in the
+// context of r.
+func (cs *csrf) loadForm(r *http.Request) *http.Request {
+	if cs.forms == nil {
+		return r
+	}
+
+	cookie, err := r.Cookie(cs.formCookieName())
+	if err != nil {
+		return r
+	}
+
+	var form url.Values
+	if err := cs.forms.Decode(cs.formCookieName(), cookie.Value, &form); err != nil {
+		return r
+	}
+
+	return cs.saveValue(r, savedFormKey, form)
+}
+
+// clearForm removes the form cookie of r, if any.
+func (cs *csrf) clearForm(w http.ResponseWriter, r *http.Request) {
+	if cs.forms == nil {
+		return
+	}
+
+	if _, err := r.Cookie(cs.formCookieName()); err == nil {
+		cs.setFormCookie(w, "", -1)
+	}
+}
+
+// setFormCookie sets the form cookie with the given value and max age.
+func (cs *csrf) setFormCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cs.formCookieName(),
+		Value:    value,
+		Path:     "/",
+		Domain:   cs.opts.Domain,
+		MaxAge:   maxAge,
+		Secure:   cs.opts.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
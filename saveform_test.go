@@ -0,0 +1,84 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPreserveForm tests that the values of a rejected form are available to
+// the error handler and the retry page, without the token and passwords, and
+// that the cookie is removed once a form passes.
+func TestPreserveForm(t *testing.T) {
+	var saved url.Values
+	errorHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		saved = SavedForm(r)
+		w.WriteHeader(http.StatusForbidden)
+	})
+	var token string
+	p := Protect(testKey, PreserveForm(time.Hour), ErrorHandler(errorHandler))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		saved = SavedForm(r)
+		token = Token(r)
+	}))
+
+	form := url.Values{"comment": {"a long comment"}, "password": {"secret"}, fieldName: {"stale"}}
+	r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Sec-Fetch-Site", "same-origin")
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	want := url.Values{"comment": {"a long comment"}}
+	if rr.Code != http.StatusForbidden || saved.Encode() != want.Encode() {
+		t.Fatalf("rejected POST: got %v, saved form %v", rr.Code, saved)
+	}
+
+	get := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rr.Result().Cookies() {
+		get.AddCookie(c)
+	}
+	saved = nil
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, get)
+	if saved.Encode() != want.Encode() {
+		t.Fatalf("retry page: got saved form %v want %v", saved, want)
+	}
+
+	post := httptest.NewRequest("POST", "/", nil)
+	post.Header.Set(headerName, token)
+	for _, c := range get.Cookies() {
+		post.AddCookie(c)
+	}
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, post)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST: got %v", rr.Code)
+	}
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == cookieName+"_form" && c.MaxAge >= 0 {
+			t.Fatalf("form cookie not removed: %v", c)
+		}
+	}
+}
+
+// TestPreserveFormCrossSite tests that forms submitted from other sites are
+// not saved.
+func TestPreserveFormCrossSite(t *testing.T) {
+	p := Protect(testKey, PreserveForm(time.Hour))(testHandler)
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader("comment=planted"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	r.Header.Set("Origin", "https://evil.example")
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == cookieName+"_form" {
+			t.Fatalf("form of a cross-site request saved: %v", c)
+		}
+	}
+}
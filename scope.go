@@ -0,0 +1,76 @@
+package csrf
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// inScope reports whether r falls within the part of the application the
+// middleware has been scoped to via OnlyUnder, OnlyHosts and OnlyMatching. Requests outside of the scope
+// are passed to the wrapped handler untouched: no token is issued and no
+// validation takes place.
+func (cs *csrf) inScope(r *http.Request) bool {
+	if len(cs.opts.OnlyUnder) > 0 {
+		found := false
+		for _, prefix := range cs.opts.OnlyUnder {
+			if underPath(r.URL.Path, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(cs.opts.OnlyHosts) > 0 {
+		host := requestHost(r)
+		found := false
+		for _, h := range cs.opts.OnlyHosts {
+			if strings.EqualFold(host, h) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(cs.opts.OnlyMatchers) > 0 && !matchAny(cs.opts.OnlyMatchers, r) {
+		return false
+	}
+
+	return true
+}
+
+// requestHost returns the host of r without a port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// underPath reports whether path is equal to or within the subtree rooted at
+// prefix, comparing whole path segments: "/app" covers "/app" and "/app/x"
+// but not "/apple".
+func underPath(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
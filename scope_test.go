@@ -0,0 +1,125 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestOnlyUnder checks that requests outside of the configured subtree are
+// neither issued a token nor validated.
+func TestOnlyUnder(t *testing.T) {
+	s := http.NewServeMux()
+	s.HandleFunc("/", testHandler)
+	p := Protect(testKey, OnlyUnder("/app"))(s)
+
+	testTable := []struct {
+		method string
+		path   string
+		code   int
+		cookie bool
+	}{
+		{"GET", "/app", http.StatusOK, true},
+		{"GET", "/app/form", http.StatusOK, true},
+		{"POST", "/app/form", http.StatusForbidden, true},
+		{"GET", "/apple", http.StatusOK, false},
+		{"POST", "/apple", http.StatusOK, false},
+		{"POST", "/static/upload", http.StatusOK, false},
+	}
+
+	for _, item := range testTable {
+		r, err := http.NewRequest(item.method, item.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("%s %s: got %v want %v", item.method, item.path, rr.Code, item.code)
+		}
+
+		if got := rr.Header().Get("Set-Cookie") != ""; got != item.cookie {
+			t.Errorf("%s %s: cookie set %v want %v", item.method, item.path, got, item.cookie)
+		}
+	}
+}
+
+// TestOnlyHosts checks that requests for other hosts are neither issued a
+// token nor validated.
+func TestOnlyHosts(t *testing.T) {
+	s := http.NewServeMux()
+	s.HandleFunc("/", testHandler)
+	p := Protect(testKey, OnlyHosts("www.example.com", "account.example.com"))(s)
+
+	testTable := []struct {
+		method string
+		url    string
+		code   int
+		cookie bool
+	}{
+		{"GET", "http://www.example.com/", http.StatusOK, true},
+		{"GET", "http://WWW.example.com:8080/", http.StatusOK, true},
+		{"POST", "http://account.example.com/", http.StatusForbidden, true},
+		{"GET", "http://api.example.com/", http.StatusOK, false},
+		{"POST", "http://api.example.com/", http.StatusOK, false},
+	}
+
+	for _, item := range testTable {
+		r, err := http.NewRequest(item.method, item.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("%s %s: got %v want %v", item.method, item.url, rr.Code, item.code)
+		}
+
+		if got := rr.Header().Get("Set-Cookie") != ""; got != item.cookie {
+			t.Errorf("%s %s: cookie set %v want %v", item.method, item.url, got, item.cookie)
+		}
+	}
+}
+
+// TestOnlyMatching checks that requests no PathMatcher matches are neither
+// issued a token nor validated.
+func TestOnlyMatching(t *testing.T) {
+	app := PathMatcherFunc(func(r *http.Request) bool {
+		return r.URL.Query().Get("app") != ""
+	})
+	p := Protect(testKey, OnlyMatching(app))(testHandler)
+
+	testTable := []struct {
+		method string
+		path   string
+		code   int
+		cookie bool
+	}{
+		{"GET", "/?app=1", http.StatusOK, true},
+		{"POST", "/?app=1", http.StatusForbidden, true},
+		{"GET", "/", http.StatusOK, false},
+		{"POST", "/", http.StatusOK, false},
+	}
+
+	for _, item := range testTable {
+		r, err := http.NewRequest(item.method, item.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("%s %s: got %v want %v", item.method, item.path, rr.Code, item.code)
+		}
+
+		if got := rr.Header().Get("Set-Cookie") != ""; got != item.cookie {
+			t.Errorf("%s %s: cookie set %v want %v", item.method, item.path, got, item.cookie)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package csrf
+
+import "net/http"
+
+// RoutePolicy is the CSRF policy of a pattern registered with a ServeMux.
+type RoutePolicy int
+
+// Route policies
+const (
+	// Protected routes issue tokens and reject invalid requests.
+	Protected RoutePolicy = iota
+	// Exempt routes are served without any CSRF processing.
+	Exempt
+	// ReportOnlyRoute routes issue tokens and report invalid requests, but
+	// do not reject them. See ReportOnly.
+	ReportOnlyRoute
+)
+
+// ServeMux is a http.ServeMux whose patterns are registered with a CSRF
+// policy each, instead of one policy for the entire mux. All protected and
+// report-only patterns share the same key and options, and therefore the same
+// CSRF cookie.
+type ServeMux struct {
+	mux     *http.ServeMux
+	protect *csrf
+	report  *csrf
+}
+
+// NewServeMux returns a ServeMux that protects its patterns with the given
+// authentication key and options, see Protect.
+func NewServeMux(authKey []byte, opts ...Option) *ServeMux {
+	protect := mustCSRF(authKey, nil, opts...)
+	report := protect.wrap(nil)
+	report.opts.ReportOnly = true
+
+	return &ServeMux{
+		mux:     http.NewServeMux(),
+		protect: protect,
+		report:  report,
+	}
+}
+
+// Handle registers a protected handler for the given pattern.
+func (m *ServeMux) Handle(pattern string, h http.Handler) {
+	m.HandlePolicy(pattern, Protected, h)
+}
+
+// HandleFunc registers a protected handler function for the given pattern.
+func (m *ServeMux) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	m.HandlePolicy(pattern, Protected, http.HandlerFunc(h))
+}
+
+// HandlePolicy registers the handler for the given pattern with the given
+// policy. Like http.ServeMux.Handle, it panics if the pattern is invalid or
+// conflicts with another pattern.
+func (m *ServeMux) HandlePolicy(pattern string, p RoutePolicy, h http.Handler) {
+	switch p {
+	case Exempt:
+	case ReportOnlyRoute:
+		h = m.report.wrap(h)
+	default:
+		h = m.protect.wrap(h)
+	}
+
+	m.mux.Handle(pattern, h)
+}
+
+// ServeHTTP dispatches the request to the handler whose pattern matches.
+func (m *ServeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.ServeHTTP(w, r)
+}
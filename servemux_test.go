@@ -0,0 +1,50 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestServeMux tests that each pattern is served with its own CSRF policy.
+func TestServeMux(t *testing.T) {
+	var reason error
+	m := NewServeMux(testKey)
+	m.HandleFunc("POST /form", testHandler)
+	m.HandlePolicy("POST /hooks/{id}", Exempt, testHandler)
+	m.HandlePolicy("POST /beta", ReportOnlyRoute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason = FailureReason(r)
+	}))
+
+	testTable := []struct {
+		path   string
+		code   int
+		cookie bool
+	}{
+		{"/form", http.StatusForbidden, true},
+		{"/hooks/1", http.StatusOK, false},
+		{"/beta", http.StatusOK, true},
+	}
+
+	for _, item := range testTable {
+		r, err := http.NewRequest("POST", item.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		m.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("POST %s: got %v want %v", item.path, rr.Code, item.code)
+		}
+
+		if got := rr.Header().Get("Set-Cookie") != ""; got != item.cookie {
+			t.Errorf("POST %s: cookie set %v want %v", item.path, got, item.cookie)
+		}
+	}
+
+	if reason != ErrNoToken {
+		t.Fatalf("report-only failure not reported: got %v want %v", reason, ErrNoToken)
+	}
+}
@@ -0,0 +1,70 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// sessionValueKey is the session value key holding the real CSRF token.
+const sessionValueKey = "gorilla.csrf.Token"
+
+// errNoSessionToken is returned if the session does not hold a CSRF token.
+var errNoSessionToken = errors.New("no CSRF token in session")
+
+// requestSaver is implemented by stores that need the request in order to
+// save the token, e.g. because they load the session from it.
+type requestSaver interface {
+	// SaveRequest stores the real CSRF token for the session of r.
+	SaveRequest(r *http.Request, token []byte, w http.ResponseWriter) error
+}
+
+// sessionStore stores CSRF tokens in a gorilla/sessions session.
+type sessionStore struct {
+	store sessions.Store
+	name  string
+}
+
+// Get retrieves the CSRF token from the named session.
+func (ss *sessionStore) Get(r *http.Request) ([]byte, error) {
+	session, err := ss.store.Get(r, ss.name)
+	if err != nil {
+		return nil, err
+	}
+
+	token, ok := session.Values[sessionValueKey].([]byte)
+	if !ok {
+		return nil, errNoSessionToken
+	}
+
+	return token, nil
+}
+
+// Save is not supported without a request; see SaveRequest.
+func (ss *sessionStore) Save(token []byte, w http.ResponseWriter) error {
+	return errors.New(errorPrefix + "session store requires a request to save")
+}
+
+// SaveRequest stores the CSRF token in the named session and saves it.
+func (ss *sessionStore) SaveRequest(r *http.Request, token []byte, w http.ResponseWriter) error {
+	// A decoding error still returns a new session, which is then saved.
+	session, _ := ss.store.Get(r, ss.name)
+	session.Values[sessionValueKey] = token
+
+	return session.Save(r, w)
+}
+
+// save stores the real token in the configured store, passing the request
+// along to stores that need it.
+func (cs *csrf) save(r *http.Request, token []byte, w http.ResponseWriter) error {
+	if err := cs.checkCookieDomain(r); err != nil {
+		return err
+	}
+
+	if rs, ok := cs.st.(requestSaver); ok {
+		return rs.SaveRequest(r, token, w)
+	}
+
+	return cs.st.Save(token, w)
+}
@@ -0,0 +1,54 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+// Check store implementations
+var _ Store = &sessionStore{}
+var _ requestSaver = &sessionStore{}
+
+// TestSessionStore tests that the token is stored in and validated against a
+// gorilla/sessions session instead of a separate CSRF cookie.
+func TestSessionStore(t *testing.T) {
+	ss := sessions.NewCookieStore(testKey)
+
+	var token string
+	s := http.NewServeMux()
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	})
+	p := Protect(testKey, SessionStore(ss, "app-session"))(s)
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "app-session" {
+		t.Fatalf("token not stored in the session: got %v", rr.Header().Values("Set-Cookie"))
+	}
+
+	r, err = http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(cookies[0])
+	r.Header.Set("X-CSRF-Token", token)
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("middleware rejected a valid session token: got %v want %v",
+			rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,170 @@
+package csrf
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxShards is the number of shards of a shardedCache without an entry
+// limit, or with a limit of at least maxShards entries.
+const maxShards = 32
+
+// sweepEvery is the number of writes after which the expired entries of the
+// next shard, in turn, are removed.
+const sweepEvery = 64
+
+// shardedCache is an in-memory map of expiring entries for per-request
+// bookkeeping, e.g. cached decisions, nonces or failure counters. Keys are
+// spread over lock-striped shards, so that concurrent requests rarely contend
+// for the same lock. Memory is bounded by the maximum number of entries, and
+// expired entries are swept periodically as the cache is written to.
+type shardedCache[V any] struct {
+	ttl         time.Duration
+	maxPerShard int
+	seed        maphash.Seed
+	shards      []cacheShard[V]
+	writes      atomic.Uint64
+}
+
+// cacheShard is a single lock-striped part of a shardedCache.
+type cacheShard[V any] struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry[V]
+}
+
+// cacheEntry is an entry of a shardedCache.
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// newShardedCache returns a cache whose entries expire after ttl, holding at
+// most maxEntries entries (0 for no limit), rounded up to a multiple of the
+// number of shards.
+func newShardedCache[V any](ttl time.Duration, maxEntries int) *shardedCache[V] {
+	n := maxShards
+	if maxEntries > 0 && maxEntries < n {
+		n = maxEntries
+	}
+
+	sc := &shardedCache[V]{
+		ttl:    ttl,
+		seed:   maphash.MakeSeed(),
+		shards: make([]cacheShard[V], n),
+	}
+	if maxEntries > 0 {
+		// Round up, so that the cache holds at least maxEntries entries.
+		sc.maxPerShard = (maxEntries + n - 1) / n
+	}
+	for i := range sc.shards {
+		sc.shards[i].entries = make(map[string]cacheEntry[V])
+	}
+
+	return sc
+}
+
+// shard returns the shard holding key.
+func (sc *shardedCache[V]) shard(key string) *cacheShard[V] {
+	return &sc.shards[maphash.String(sc.seed, key)%uint64(len(sc.shards))]
+}
+
+// get returns the value of key, if present and not expired.
+func (sc *shardedCache[V]) get(key string) (V, bool) {
+	s := sc.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || timeNow().After(e.expires) {
+		var zero V
+		return zero, false
+	}
+
+	return e.value, true
+}
+
+// put sets the value of key, resetting its expiry.
+func (sc *shardedCache[V]) put(key string, value V) {
+	sc.update(key, func(V, bool) V { return value })
+}
+
+// update atomically replaces the value of key with fn(current, found), where
+// found is false if key is not present or has expired, and returns the new
+// value. The expiry of key is reset.
+func (sc *shardedCache[V]) update(key string, fn func(current V, found bool) V) V {
+	now := timeNow()
+	value := sc.updateShard(sc.shard(key), key, now, fn)
+
+	// Sweep the shards in turn, so that expired entries of keys that are
+	// never written again do not linger.
+	if n := sc.writes.Add(1); n%sweepEvery == 0 {
+		s := &sc.shards[(n/sweepEvery)%uint64(len(sc.shards))]
+		s.mu.Lock()
+		s.sweep(now)
+		s.mu.Unlock()
+	}
+
+	return value
+}
+
+// updateShard updates key in shard s, see update.
+func (sc *shardedCache[V]) updateShard(s *cacheShard[V], key string, now time.Time, fn func(V, bool) V) V {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, found := s.entries[key]
+	if found && now.After(e.expires) {
+		found = false
+	}
+
+	if !found && sc.maxPerShard > 0 && len(s.entries) >= sc.maxPerShard {
+		s.sweep(now)
+		s.evict(sc.maxPerShard)
+	}
+
+	value := fn(e.value, found)
+	s.entries[key] = cacheEntry[V]{value: value, expires: now.Add(sc.ttl)}
+
+	return value
+}
+
+// len returns the number of entries, including expired ones not yet swept.
+func (sc *shardedCache[V]) len() int {
+	n := 0
+	for i := range sc.shards {
+		s := &sc.shards[i]
+		s.mu.Lock()
+		n += len(s.entries)
+		s.mu.Unlock()
+	}
+
+	return n
+}
+
+// sweep removes the expired entries of the shard. The caller must hold s.mu.
+func (s *cacheShard[V]) sweep(now time.Time) {
+	for k, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+// evict removes the entries closest to expiry until there is room for another
+// entry. The caller must hold s.mu.
+func (s *cacheShard[V]) evict(max int) {
+	for len(s.entries) >= max {
+		var (
+			oldest  string
+			expires time.Time
+		)
+		for k, e := range s.entries {
+			if expires.IsZero() || e.expires.Before(expires) {
+				oldest, expires = k, e.expires
+			}
+		}
+		delete(s.entries, oldest)
+	}
+}
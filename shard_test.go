@@ -0,0 +1,80 @@
+package csrf
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestShardedCache tests that entries expire and that the cache is bounded.
+func TestShardedCache(t *testing.T) {
+	start := time.Now()
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	sc := newShardedCache[bool](time.Minute, 100)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		sc.put(key, true)
+		if v, ok := sc.get(key); !ok || !v {
+			t.Fatalf("%s: cached value not returned: got %v, %v", key, v, ok)
+		}
+	}
+
+	// The limit is rounded up to a multiple of the number of shards.
+	if n, max := sc.len(), (100+maxShards-1)/maxShards*maxShards; n < 100 || n > max {
+		t.Fatalf("cache not bounded: got %d entries want %d to %d", n, 100, max)
+	}
+
+	timeNow = func() time.Time { return start.Add(2 * time.Minute) }
+	if _, ok := sc.get("999"); ok {
+		t.Fatal("expired value returned")
+	}
+
+}
+
+// TestShardedCacheSweep tests that writes sweep the expired entries of all
+// shards.
+func TestShardedCacheSweep(t *testing.T) {
+	start := time.Now()
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	sc := newShardedCache[bool](time.Minute, 0)
+	for i := 0; i < 1000; i++ {
+		sc.put(strconv.Itoa(i), true)
+	}
+
+	timeNow = func() time.Time { return start.Add(2 * time.Minute) }
+	for i := 0; i < maxShards*sweepEvery; i++ {
+		sc.put("new", true)
+	}
+
+	if n := sc.len(); n != 1 {
+		t.Fatalf("expired entries not swept: got %d entries want %d", n, 1)
+	}
+}
+
+// TestShardedCacheUpdate tests that concurrent updates are atomic.
+func TestShardedCacheUpdate(t *testing.T) {
+	sc := newShardedCache[int](time.Minute, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				sc.update(strconv.Itoa(j%4), func(n int, _ bool) int { return n + 1 })
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 4; i++ {
+		if n, _ := sc.get(strconv.Itoa(i)); n != 200 {
+			t.Errorf("counter %d: got %d want %d", i, n, 200)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package csrf
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// recentFailures is the number of failures kept for the admin handler.
+const recentFailures = 20
+
+// adminStats counts the decisions of a middleware, and keeps its most recent
+// failures, for the admin handler.
+type adminStats struct {
+	started  time.Time
+	passed   atomic.Int64
+	rejected atomic.Int64
+	reported atomic.Int64
+	skipped  atomic.Int64
+	timeouts atomic.Int64
+	issuance issuance
+
+	mu     sync.Mutex
+	recent []Event // ring buffer of the most recent failures
+	next   int
+	codes  map[string]int64 // failures per code
+}
+
+func newAdminStats() *adminStats {
+	return &adminStats{
+		started: timeNow(),
+		recent:  make([]Event, 0, recentFailures),
+		codes:   make(map[string]int64),
+	}
+}
+
+// fail counts a failure and records it as one of the recent failures.
+func (s *adminStats) fail(e Event) {
+	if e.Action == ActionReport {
+		s.reported.Add(1)
+	} else {
+		s.rejected.Add(1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.codes[e.Code]++
+	if len(s.recent) < recentFailures {
+		s.recent = append(s.recent, e)
+		return
+	}
+	s.recent[s.next] = e
+	s.next = (s.next + 1) % recentFailures
+}
+
+// failures returns the recent failures, the most recent first.
+func (s *adminStats) failures() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]Event, 0, len(s.recent))
+	for i := len(s.recent) - 1; i >= 0; i-- {
+		events = append(events, s.recent[(s.next+i)%len(s.recent)])
+	}
+
+	return events
+}
+
+// failureCodes returns a copy of the failure counts per code.
+func (s *adminStats) failureCodes() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := make(map[string]int64, len(s.codes))
+	for code, n := range s.codes {
+		codes[code] = n
+	}
+
+	return codes
+}
+
+// FailureCounts returns the number of validation failures per failure code,
+// e.g. CodeBadToken, rejected and reported alike, since the middleware was
+// created. The codes are a small fixed set, so they are suitable as metric
+// labels.
+func (m *Middleware) FailureCounts() map[string]int64 {
+	return m.cs.stats.failureCodes()
+}
+
+// AdminHandler returns a handler rendering the decision counters, the cookie
+// issuance counters (see Middleware.Issuance), the failures per code (see
+// Middleware.FailureCounts), the configuration (see Describe), token metrics
+// (see CollectTokenMetrics) and the most recent failures of the middleware as
+// plain text, for debugging. The output includes request paths and client
+// addresses: mount the handler behind authentication.
+func (m *Middleware) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, m.cs.adminReport())
+	})
+}
+
+// adminReport renders the report of the admin handler.
+func (cs *csrf) adminReport() string {
+	var b strings.Builder
+	s := cs.stats
+
+	fmt.Fprintf(&b, "%-24s %v\n", "Uptime:", timeNow().Sub(s.started).Truncate(time.Second))
+	fmt.Fprintf(&b, "%-24s %d\n", "Passed:", s.passed.Load())
+	fmt.Fprintf(&b, "%-24s %d\n", "Rejected:", s.rejected.Load())
+	fmt.Fprintf(&b, "%-24s %d\n", "Reported:", s.reported.Load())
+	fmt.Fprintf(&b, "%-24s %d\n", "Skipped:", s.skipped.Load())
+	fmt.Fprintf(&b, "%-24s %d\n", "Timeouts:", s.timeouts.Load())
+	is := s.issuance.snapshot()
+	fmt.Fprintf(&b, "%-24s %d\n", "Issued:", is.Issued)
+	fmt.Fprintf(&b, "%-24s %d\n", "Reused:", is.Reused)
+	fmt.Fprintf(&b, "%-24s %d\n", "Reissued:", is.Reissued)
+	if cs.reporter != nil {
+		fmt.Fprintf(&b, "%-24s %d\n", "ReportsDropped:", cs.reporter.dropped())
+	}
+	if as, ok := cs.opts.EventSink.(*AsyncEventSink); ok {
+		fmt.Fprintf(&b, "%-24s %d\n", "AuditDropped:", as.Dropped())
+	}
+	if cs.opts.TokenMetrics != nil {
+		fmt.Fprintf(&b, "%-24s %s\n", "TokenMetrics:", cs.opts.TokenMetrics)
+	}
+
+	b.WriteString("\nConfiguration:\n")
+	b.WriteString(cs.Describe())
+
+	if ws := cs.warnings(); len(ws) > 0 {
+		b.WriteString("\nWarnings:\n")
+		for _, w := range ws {
+			fmt.Fprintf(&b, "%s\n", w)
+		}
+	}
+
+	if codes := s.failureCodes(); len(codes) > 0 {
+		b.WriteString("\nFailures by code:\n")
+		names := make([]string, 0, len(codes))
+		for code := range codes {
+			names = append(names, code)
+		}
+		sort.Strings(names)
+		for _, code := range names {
+			fmt.Fprintf(&b, "%-24s %d\n", code, codes[code])
+		}
+	}
+
+	if len(is.Paths) > 0 {
+		b.WriteString("\nRe-issuing paths:\n")
+		for _, path := range is.reissuePaths() {
+			fmt.Fprintf(&b, "%-24s %d\n", path, is.Paths[path])
+		}
+	}
+
+	b.WriteString("\nRecent failures:\n")
+	for _, e := range s.failures() {
+		fmt.Fprintf(&b, "%s %-6s %s %s %s%s (%s)\n", e.Time.Format(time.RFC3339), e.Action,
+			e.RemoteAddr, e.Method, e.Host, e.Path, e.Reason)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package csrf
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAdminHandler tests that the admin handler reports the counters, the
+// configuration and the recent failures, the most recent first.
+func TestAdminHandler(t *testing.T) {
+	m, err := New(testKey, ExcludePaths("/hook"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Wrap(testHandler)
+
+	for i := 0; i < recentFailures+5; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", fmt.Sprintf("/form/%d", i), nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/hook", nil))
+
+	rr := httptest.NewRecorder()
+	m.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/debug/csrf", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("admin handler failed: got %v", rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		"Rejected:                25\n",
+		"Skipped:                 1\n",
+		"ExcludePaths:",
+		"POST example.com/form/24 (" + ErrNoToken.Error() + ")",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("admin report does not contain %q:\n%s", want, body)
+		}
+	}
+
+	if strings.Contains(body, "/form/4 ") || !strings.Contains(body, "/form/5 ") {
+		t.Errorf("admin report does not keep the %d most recent failures:\n%s", recentFailures, body)
+	}
+	if i, j := strings.Index(body, "/form/24 "), strings.Index(body, "/form/23 "); i > j {
+		t.Errorf("recent failures not in reverse order:\n%s", body)
+	}
+}
@@ -5,13 +5,14 @@ package csrf
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/securecookie"
 )
 
-// store represents the session storage used for CSRF tokens.
-type store interface {
+// Store represents the session storage used for CSRF tokens.
+type Store interface {
 	// Get returns the real CSRF token from the store.
 	Get(*http.Request) ([]byte, error)
 	// Save stores the real CSRF token in the store and writes a
@@ -22,6 +23,12 @@ type store interface {
 	Save(token []byte, w http.ResponseWriter) error
 }
 
+// duplicateExpirer is implemented by stores that can expire duplicate
+// session cookies sent with a request.
+type duplicateExpirer interface {
+	ExpireDuplicates(r *http.Request, w http.ResponseWriter) error
+}
+
 // cookieStore is a signed cookie session store for CSRF tokens.
 type cookieStore struct {
 	name     string
@@ -29,23 +36,63 @@ type cookieStore struct {
 	secure   bool
 	httpOnly bool
 	path     string
+	// pathFunc, if set, returns the cookie path for a request. See PathFunc.
+	pathFunc func(*http.Request) string
 	domain   string
 	sc       *securecookie.SecureCookie
 	sameSite SameSiteMode
+	// idleTimeout and absTimeout embed timestamps in the cookie value
+	// when set. See IdleTimeout and AbsoluteTimeout.
+	idleTimeout time.Duration
+	absTimeout  time.Duration
+	// duplicateDomains are the cookie domains ("" for host-only) to expire
+	// when a request carries more than one CSRF cookie.
+	duplicateDomains []string
 }
 
 // Get retrieves a CSRF token from the session cookie. It returns an empty token
 // if decoding fails (e.g. HMAC validation fails or the named cookie doesn't exist).
+//
+// If the request carries several cookies with the same name (e.g. a stale
+// cookie for another Domain), each is tried in order and the first one that
+// decodes is used.
 func (cs *cookieStore) Get(r *http.Request) ([]byte, error) {
-	// Retrieve the cookie from the request
-	cookie, err := r.Cookie(cs.name)
-	if err != nil {
-		return nil, err
+	_, token, err := cs.find(r)
+	return token, err
+}
+
+// find returns the value and decoded token of the first cookie in r that
+// decodes successfully. It returns the last decoding error otherwise.
+func (cs *cookieStore) find(r *http.Request) (string, []byte, error) {
+	err := http.ErrNoCookie
+	for _, cookie := range r.Cookies() {
+		if cookie.Name != cs.name {
+			continue
+		}
+
+		var token []byte
+		token, err = cs.decode(cookie.Value)
+		if err == nil {
+			return cookie.Value, token, nil
+		}
+	}
+
+	return "", nil, err
+}
+
+// decode decodes an encoded cookie value into the real CSRF token.
+func (cs *cookieStore) decode(value string) ([]byte, error) {
+	if cs.timed() {
+		tt, err := cs.decodeTimed(value)
+		if err != nil {
+			return nil, err
+		}
+		return tt.Token, nil
 	}
 
 	token := make([]byte, tokenLength)
 	// Decode the HMAC authenticated cookie.
-	err = cs.sc.Decode(cs.name, cookie.Value, &token)
+	err := cs.sc.Decode(cs.name, value, &token)
 	if err != nil {
 		return nil, err
 	}
@@ -53,22 +100,104 @@ func (cs *cookieStore) Get(r *http.Request) ([]byte, error) {
 	return token, nil
 }
 
+// encode encodes the real CSRF token into a cookie value, the inverse of
+// decode.
+func (cs *cookieStore) encode(token []byte) (string, error) {
+	var value interface{} = token
+	if cs.timed() {
+		now := timeNow().Unix()
+		value = &timedToken{Token: token, Issued: now, Seen: now}
+	}
+
+	// Generate an encoded cookie value with the CSRF token.
+	return cs.sc.Encode(cs.name, value)
+}
+
+// ExpireDuplicates expires the CSRF cookie for each of the configured
+// duplicate domains if the request carries more than one cookie with the
+// same name, and re-issues the cookie that decoded successfully.
+func (cs *cookieStore) ExpireDuplicates(r *http.Request, w http.ResponseWriter) error {
+	if len(cs.duplicateDomains) == 0 {
+		return nil
+	}
+
+	n := 0
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == cs.name {
+			n++
+		}
+	}
+	if n < 2 {
+		return nil
+	}
+
+	value, _, err := cs.find(r)
+	if err != nil {
+		return err
+	}
+
+	for _, domain := range cs.duplicateDomains {
+		if domain == cs.domain {
+			continue
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:   cs.name,
+			Path:   cs.cookiePath(r),
+			Domain: domain,
+			MaxAge: -1,
+		})
+	}
+
+	cs.setCookie(r, value, w)
+
+	return nil
+}
+
 // Save stores the CSRF token in the session cookie.
 func (cs *cookieStore) Save(token []byte, w http.ResponseWriter) error {
-	// Generate an encoded cookie value with the CSRF token.
-	encoded, err := cs.sc.Encode(cs.name, token)
+	return cs.SaveRequest(nil, token, w)
+}
+
+// SaveRequest stores the CSRF token in the session cookie, adapting the
+// cookie to the user agent of r (see setCookie).
+func (cs *cookieStore) SaveRequest(r *http.Request, token []byte, w http.ResponseWriter) error {
+	// Re-send a cookie of r that already holds token unchanged, so that
+	// re-issuing it, e.g. from TokenHandler, does not reset its issue time
+	// and keep it alive past the AbsoluteTimeout.
+	if r != nil {
+		if value, current, err := cs.find(r); err == nil && compareTokens(current, token) {
+			cs.setCookie(r, value, w)
+			return nil
+		}
+	}
+
+	encoded, err := cs.encode(token)
 	if err != nil {
 		return err
 	}
 
+	cs.setCookie(r, encoded, w)
+
+	return nil
+}
+
+// setCookie writes the encoded cookie value to the response. If SameSite=None
+// is configured, the attribute is left out for user agents that are known to
+// reject such cookies; r may be nil if the request is not known.
+func (cs *cookieStore) setCookie(r *http.Request, encoded string, w http.ResponseWriter) {
+	sameSite := cs.sameSite
+	if sameSite == SameSiteNoneMode && r != nil && sameSiteNoneIncompatible(r.UserAgent()) {
+		sameSite = 0
+	}
+
 	cookie := &http.Cookie{
 		Name:     cs.name,
 		Value:    encoded,
 		MaxAge:   cs.maxAge,
 		HttpOnly: cs.httpOnly,
 		Secure:   cs.secure,
-		SameSite: http.SameSite(cs.sameSite),
-		Path:     cs.path,
+		SameSite: http.SameSite(sameSite),
+		Path:     cs.cookiePath(r),
 		Domain:   cs.domain,
 	}
 
@@ -80,8 +209,45 @@ func (cs *cookieStore) Save(token []byte, w http.ResponseWriter) error {
 			time.Duration(cs.maxAge) * time.Second)
 	}
 
+	// Replace any cookie of the same name that is already pending, e.g. when
+	// the middleware has been applied twice, so that the response carries a
+	// single cookie that matches the token in the request context.
+	removeSetCookie(w.Header(), cs.name)
+
 	// Write the authenticated cookie to the response.
 	http.SetCookie(w, cookie)
+}
 
-	return nil
+// cookiePath returns the cookie path for r, which may be nil if the request is
+// not known. Requests made up by the middleware, e.g. by Mint, get the static
+// path.
+func (cs *cookieStore) cookiePath(r *http.Request) string {
+	if cs.pathFunc != nil && r != nil && r.Context().Value(syntheticKey) == nil {
+		return cs.pathFunc(r)
+	}
+
+	return cs.path
+}
+
+// removeSetCookie removes pending Set-Cookie headers for the named cookie.
+// Pending deletions (cookies with an empty value) are kept.
+func removeSetCookie(h http.Header, name string) {
+	values := h.Values("Set-Cookie")
+	if len(values) == 0 {
+		return
+	}
+
+	kept := values[:0:0]
+	for _, v := range values {
+		if !strings.HasPrefix(v, name+"=") || strings.HasPrefix(v, name+"=;") {
+			kept = append(kept, v)
+		}
+	}
+
+	if len(kept) == 0 {
+		h.Del("Set-Cookie")
+		return
+	}
+
+	h["Set-Cookie"] = kept
 }
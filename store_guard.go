@@ -0,0 +1,283 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStoreUnavailable is returned when the token store cannot be reached, e.g.
+// because a call timed out or the circuit breaker is open. Store
+// implementations should wrap backend (connection, I/O) errors with it - e.g.
+// fmt.Errorf("%w: %v", csrf.ErrStoreUnavailable, err) - to tell them apart
+// from a missing or invalid token.
+var ErrStoreUnavailable = errors.New("CSRF token store unavailable")
+
+// FailurePolicy governs how unsafe requests are handled while the token store
+// is unavailable.
+type FailurePolicy int
+
+// Failure policies
+const (
+	// FailClosed rejects unsafe requests while the store is unavailable. This
+	// is the default.
+	FailClosed FailurePolicy = iota
+	// FailOpen lets unsafe requests through without validation while the
+	// store is unavailable.
+	FailOpen
+)
+
+// guardedStore wraps a Store with timeouts, retries and a circuit breaker. It
+// also stops waiting on a store call as soon as the request context is done,
+// e.g. because the client disconnected.
+type guardedStore struct {
+	st        Store
+	timeout   time.Duration
+	retries   int
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// Get retrieves the token from the wrapped store.
+func (gs *guardedStore) Get(r *http.Request) ([]byte, error) {
+	return gs.call(r.Context(), func(ctx context.Context, w http.ResponseWriter) ([]byte, error) {
+		return gs.st.Get(r.WithContext(ctx))
+	}, nil)
+}
+
+// Save stores the token in the wrapped store.
+func (gs *guardedStore) Save(token []byte, w http.ResponseWriter) error {
+	_, err := gs.call(context.Background(), func(ctx context.Context, w http.ResponseWriter) ([]byte, error) {
+		return nil, gs.st.Save(token, w)
+	}, w)
+
+	return err
+}
+
+// SaveRequest stores the token in the wrapped store, passing the request to
+// stores that need it.
+func (gs *guardedStore) SaveRequest(r *http.Request, token []byte, w http.ResponseWriter) error {
+	rs, ok := gs.st.(requestSaver)
+	if !ok {
+		_, err := gs.call(r.Context(), func(ctx context.Context, w http.ResponseWriter) ([]byte, error) {
+			return nil, gs.st.Save(token, w)
+		}, w)
+		return err
+	}
+
+	_, err := gs.call(r.Context(), func(ctx context.Context, w http.ResponseWriter) ([]byte, error) {
+		return nil, rs.SaveRequest(r.WithContext(ctx), token, w)
+	}, w)
+
+	return err
+}
+
+// Touch refreshes the token in the wrapped store, if it supports timeouts.
+func (gs *guardedStore) Touch(r *http.Request, w http.ResponseWriter) error {
+	t, ok := gs.st.(toucher)
+	if !ok {
+		return nil
+	}
+
+	_, err := gs.call(r.Context(), func(ctx context.Context, w http.ResponseWriter) ([]byte, error) {
+		return nil, t.Touch(r.WithContext(ctx), w)
+	}, w)
+
+	return err
+}
+
+// ExpireDuplicates expires the duplicate cookies of the wrapped store, if it
+// writes cookies.
+func (gs *guardedStore) ExpireDuplicates(r *http.Request, w http.ResponseWriter) error {
+	de, ok := gs.st.(duplicateExpirer)
+	if !ok {
+		return nil
+	}
+
+	_, err := gs.call(r.Context(), func(ctx context.Context, w http.ResponseWriter) ([]byte, error) {
+		return nil, de.ExpireDuplicates(r.WithContext(ctx), w)
+	}, w)
+
+	return err
+}
+
+// Issued returns the time the token of r was issued, if the wrapped store
+// records it.
+func (gs *guardedStore) Issued(r *http.Request) (time.Time, error) {
+	is, ok := gs.st.(issuer)
+	if !ok {
+		return time.Time{}, errNotTimed
+	}
+
+	// issued is only read once the call has completed in time.
+	var issued time.Time
+	_, err := gs.call(r.Context(), func(ctx context.Context, w http.ResponseWriter) ([]byte, error) {
+		var err error
+		issued, err = is.Issued(r.WithContext(ctx))
+		return nil, err
+	}, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return issued, nil
+}
+
+// storeFunc is a single call to the wrapped store.
+type storeFunc func(ctx context.Context, w http.ResponseWriter) ([]byte, error)
+
+// call runs fn, retrying it on ErrStoreUnavailable and failing fast while the
+// circuit breaker is open. Headers written by fn are only copied to w once fn
+// has completed in time, so that an abandoned call cannot touch the response.
+func (gs *guardedStore) call(ctx context.Context, fn storeFunc, w http.ResponseWriter) ([]byte, error) {
+	if !gs.allow() {
+		return nil, ErrStoreUnavailable
+	}
+
+	var (
+		token []byte
+		err   error
+	)
+	for attempt := 0; attempt <= gs.retries; attempt++ {
+		hw := &headerWriter{header: http.Header{}}
+		token, err = gs.attempt(ctx, fn, hw)
+		if err == nil {
+			if w != nil {
+				mergeHeader(w.Header(), hw.header)
+			}
+			break
+		}
+		if !errors.Is(err, ErrStoreUnavailable) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	gs.record(err)
+
+	return token, err
+}
+
+// mergeHeader copies the headers written by a store call to dst. They replace
+// those of an earlier call, and cookies replace pending Set-Cookie headers for
+// the same cookie, so that a token saved twice is not sent twice.
+func mergeHeader(dst, src http.Header) {
+	for k, v := range src {
+		if k != "Set-Cookie" {
+			dst[k] = v
+			continue
+		}
+		for _, c := range v {
+			// Deletions, e.g. of duplicate cookies, replace nothing.
+			if name, value, ok := strings.Cut(c, "="); ok && value != "" && !strings.HasPrefix(value, ";") {
+				removeSetCookie(dst, name)
+			}
+		}
+		dst[k] = append(dst[k], v...)
+	}
+}
+
+// attempt runs fn once, bounded by the configured timeout and the lifetime of
+// ctx. Without a timeout fn runs on the calling goroutine, and is expected to
+// honor ctx itself. With a timeout fn runs on its own goroutine, which is
+// abandoned if fn does not complete in time: it keeps running, and is leaked,
+// until fn returns.
+func (gs *guardedStore) attempt(ctx context.Context, fn storeFunc, hw *headerWriter) ([]byte, error) {
+	if gs.timeout <= 0 {
+		token, err := fn(ctx, hw)
+		if err != nil && ctx.Err() != nil {
+			// The request itself is done; report why.
+			return nil, fmt.Errorf("%w: %w", ErrStoreUnavailable, ctx.Err())
+		}
+		return token, err
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, gs.timeout)
+	defer cancel()
+
+	type result struct {
+		token []byte
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		token, err := fn(ctx, hw)
+		done <- result{token, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.token, res.err
+	case <-ctx.Done():
+		if err := parent.Err(); err != nil {
+			// The request itself is done; report why.
+			return nil, fmt.Errorf("%w: %w", ErrStoreUnavailable, err)
+		}
+		return nil, ErrStoreUnavailable
+	}
+}
+
+// allow reports whether a call may be made, i.e. the breaker is not open.
+func (gs *guardedStore) allow() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	return gs.openUntil.IsZero() || !timeNow().Before(gs.openUntil)
+}
+
+// record updates the circuit breaker with the outcome of a call.
+func (gs *guardedStore) record(err error) {
+	if gs.threshold <= 0 {
+		return
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if errors.Is(err, context.Canceled) {
+		// A client going away says nothing about the store.
+		return
+	}
+
+	if !errors.Is(err, ErrStoreUnavailable) {
+		gs.failures = 0
+		gs.openUntil = time.Time{}
+		return
+	}
+
+	gs.failures++
+	if gs.failures >= gs.threshold {
+		gs.openUntil = timeNow().Add(gs.cooldown)
+	}
+}
+
+// headerWriter is a http.ResponseWriter that only collects headers.
+type headerWriter struct {
+	header http.Header
+}
+
+func (hw *headerWriter) Header() http.Header         { return hw.header }
+func (hw *headerWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (hw *headerWriter) WriteHeader(int)             {}
+
+// storeUnavailable handles a request while the token store is unavailable.
+// Safe requests are served without a token; unsafe requests are handled
+// according to the configured FailurePolicy.
+func (cs *csrf) storeUnavailable(w http.ResponseWriter, r *http.Request, err error) {
+	if contains(safeMethods, r.Method) || cs.opts.StoreFailurePolicy == FailOpen {
+		cs.annotateSkip(r, skippedStoreUnavailable)
+		cs.h.ServeHTTP(w, r)
+		return
+	}
+
+	cs.fail(w, r, err)
+}
@@ -0,0 +1,243 @@
+package csrf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyStore is a Store whose backend fails a configurable number of times.
+type flakyStore struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	delay    time.Duration
+	token    []byte
+}
+
+func (fs *flakyStore) Get(r *http.Request) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.calls++
+	select {
+	case <-time.After(fs.delay):
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+	if fs.failures != 0 {
+		fs.failures--
+		return nil, fmt.Errorf("%w: connection refused", ErrStoreUnavailable)
+	}
+	if fs.token == nil {
+		return nil, errors.New("no token")
+	}
+	return fs.token, nil
+}
+
+func (fs *flakyStore) Save(token []byte, w http.ResponseWriter) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.token = token
+	return nil
+}
+
+// TestStoreRetries tests that unavailable store calls are retried.
+func TestStoreRetries(t *testing.T) {
+	fs := &flakyStore{failures: 2}
+	p := Protect(testKey, TokenStore(fs), StoreRetries(2))(testHandler)
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if fs.calls != 3 || fs.token == nil {
+		t.Fatalf("store call not retried: got %d calls want %d", fs.calls, 3)
+	}
+}
+
+// TestStoreFailurePolicy tests that unsafe requests are rejected or let
+// through while the store is unavailable, and safe requests are served.
+func TestStoreFailurePolicy(t *testing.T) {
+	testTable := []struct {
+		policy FailurePolicy
+		method string
+		code   int
+	}{
+		{FailClosed, "GET", http.StatusOK},
+		{FailClosed, "POST", http.StatusForbidden},
+		{FailOpen, "GET", http.StatusOK},
+		{FailOpen, "POST", http.StatusOK},
+	}
+
+	for _, item := range testTable {
+		fs := &flakyStore{failures: -1}
+		p := Protect(testKey, TokenStore(fs), StoreRetries(1), StoreFailurePolicy(item.policy))(testHandler)
+
+		r, err := http.NewRequest(item.method, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Errorf("policy %v, %s: got %v want %v", item.policy, item.method, rr.Code, item.code)
+		}
+	}
+}
+
+// TestStoreCircuitBreaker tests that the breaker opens after consecutive
+// failures and closes again after the cooldown.
+func TestStoreCircuitBreaker(t *testing.T) {
+	fs := &flakyStore{failures: 2, token: make([]byte, tokenLength)}
+	gs := &guardedStore{st: fs, threshold: 2, cooldown: time.Minute}
+
+	start := time.Now()
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := gs.Get(r); !errors.Is(err, ErrStoreUnavailable) {
+			t.Fatalf("call %d: got %v want %v", i, err, ErrStoreUnavailable)
+		}
+	}
+
+	if fs.calls != 2 {
+		t.Fatalf("open breaker did not short-circuit: got %d calls want %d", fs.calls, 2)
+	}
+
+	timeNow = func() time.Time { return start.Add(2 * time.Minute) }
+	if _, err := gs.Get(r); err != nil {
+		t.Fatalf("breaker did not close after cooldown: got %v", err)
+	}
+}
+
+// TestStoreTimeout tests that slow store calls fail with ErrStoreUnavailable.
+func TestStoreTimeout(t *testing.T) {
+	fs := &flakyStore{delay: 50 * time.Millisecond, token: make([]byte, tokenLength)}
+	gs := &guardedStore{st: fs, timeout: time.Millisecond}
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := gs.Get(r); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("slow store call did not time out: got %v", err)
+	}
+}
+
+// TestStoreRequestCanceled tests that store calls see the request context, so
+// that they end as soon as the request is canceled.
+func TestStoreRequestCanceled(t *testing.T) {
+	fs := &flakyStore{delay: time.Second, token: make([]byte, tokenLength)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := http.NewRequestWithContext(ctx, "POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	var reason error
+	p := Protect(testKey, TokenStore(fs), ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason = FailureReason(r)
+	})))(testHandler)
+
+	start := time.Now()
+	p.ServeHTTP(httptest.NewRecorder(), r)
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("store call not abandoned on cancellation: took %v", elapsed)
+	}
+
+	if !errors.Is(reason, context.Canceled) {
+		t.Fatalf("cancellation not reported: got %v want %v", reason, context.Canceled)
+	}
+}
+
+// TestStoreSaveTwice tests that a token saved twice through the guard, e.g.
+// when a handler regenerates it, is sent in a single cookie.
+func TestStoreSaveTwice(t *testing.T) {
+	gs := &guardedStore{st: fuzzCookieStore(false)}
+
+	rr := httptest.NewRecorder()
+	rr.Header().Add("Set-Cookie", "session=s1")
+	for i := 0; i < 2; i++ {
+		if err := gs.Save(make([]byte, tokenLength), rr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 2 || cookies[0].Name != "session" || cookies[1].Name != cookieName {
+		t.Fatalf("got cookies %v, want the session and a single CSRF cookie", cookies)
+	}
+}
+
+// TestStoreGuardTimeouts tests that a guarded cookie store still refreshes
+// the idle timeout on use, and expires duplicate cookies.
+func TestStoreGuardTimeouts(t *testing.T) {
+	start := time.Now()
+	clock := start
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	var token string
+	s := http.NewServeMux()
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	})
+	p := Protect(testKey, IdleTimeout(30*time.Minute), AbsoluteTimeout(time.Hour),
+		StoreTimeout(time.Second), Domain("example.com"), ExpireDuplicateCookies(""))(s)
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "http://www.example.com/", nil))
+	cookie := rr.Result().Cookies()[0]
+
+	for _, elapsed := range []time.Duration{20 * time.Minute, 45 * time.Minute} {
+		clock = start.Add(elapsed)
+
+		r := httptest.NewRequest("POST", "http://www.example.com/", nil)
+		r.AddCookie(&http.Cookie{Name: cookieName, Value: "stale"})
+		r.AddCookie(cookie)
+		r.Header.Set("X-CSRF-Token", token)
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("after %v: got %v want %v", elapsed, rr.Code, http.StatusOK)
+		}
+
+		var expired bool
+		for _, c := range rr.Result().Cookies() {
+			switch {
+			case c.MaxAge < 0 && c.Domain == "":
+				expired = true
+			case c.Value != "":
+				cookie = c
+			}
+		}
+		if !expired {
+			t.Fatalf("after %v: duplicate cookie not expired: got %v", elapsed, rr.Header().Values("Set-Cookie"))
+		}
+	}
+}
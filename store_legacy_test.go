@@ -17,11 +17,11 @@ import (
 )
 
 // Check store implementations
-var _ store = &cookieStore{}
+var _ Store = &cookieStore{}
 
 // brokenSaveStore is a CSRF store that cannot, well, save.
 type brokenSaveStore struct {
-	store
+	Store
 }
 
 func (bs *brokenSaveStore) Get(*http.Request) ([]byte, error) {
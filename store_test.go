@@ -15,11 +15,11 @@ import (
 )
 
 // Check store implementations
-var _ store = &cookieStore{}
+var _ Store = &cookieStore{}
 
 // brokenSaveStore is a CSRF store that cannot, well, save.
 type brokenSaveStore struct {
-	store
+	Store
 }
 
 func (bs *brokenSaveStore) Get(*http.Request) ([]byte, error) {
@@ -70,7 +70,14 @@ func TestCookieDecode(t *testing.T) {
 	// Test with a nil hash key
 	sc := securecookie.New(nil, nil)
 	sc.MaxAge(age)
-	st := &cookieStore{cookieName, age, true, true, "", "", sc, SameSiteDefaultMode}
+	st := &cookieStore{
+		name:     cookieName,
+		maxAge:   age,
+		secure:   true,
+		httpOnly: true,
+		sc:       sc,
+		sameSite: SameSiteDefaultMode,
+	}
 
 	// Set a fake cookie value so r.Cookie passes.
 	r.Header.Set("Cookie", fmt.Sprintf("%s=%s", cookieName, "notacookie"))
@@ -88,7 +95,14 @@ func TestCookieEncode(t *testing.T) {
 	// Test with a nil hash key
 	sc := securecookie.New(nil, nil)
 	sc.MaxAge(age)
-	st := &cookieStore{cookieName, age, true, true, "", "", sc, SameSiteDefaultMode}
+	st := &cookieStore{
+		name:     cookieName,
+		maxAge:   age,
+		secure:   true,
+		httpOnly: true,
+		sc:       sc,
+		sameSite: SameSiteDefaultMode,
+	}
 
 	rr := httptest.NewRecorder()
 
@@ -190,3 +204,119 @@ func TestSameSiteDefaultLaxMode(t *testing.T) {
 		t.Fatalf("cookie should contain %q by default: got %s", sameSiteLax, cookie)
 	}
 }
+
+// TestSameSiteNoneFallback tests that SameSite=None is left out for user
+// agents that reject it, and kept for all others.
+func TestSameSiteNoneFallback(t *testing.T) {
+	p := Protect(testKey, SameSite(SameSiteNoneMode), Secure(true))(testHandler)
+
+	for _, item := range []struct {
+		ua   string
+		none bool
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 12_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.0 Mobile/15E148 Safari/604.1", false},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1.2 Safari/605.1.15", false},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/605.1.15 (KHTML, like Gecko)", false},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.87 Safari/537.36", true},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/59.0.3071.115 Safari/537.36", false},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/67.0.3396.99 Safari/537.36", true},
+		{"Mozilla/5.0 (Linux; U; Android 8.1.0; en-US) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/57.0.2987.108 UCBrowser/12.13.0.1207 Mobile Safari/537.36", false},
+		{"Mozilla/5.0 (Linux; U; Android 9; en-US) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/72.0.3626.121 UCBrowser/12.13.2.1208 Mobile Safari/537.36", true},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 13_3 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0.5 Mobile/15E148 Safari/604.1", true},
+		{"", true},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", item.ua)
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		cookie := rr.Header().Get("Set-Cookie")
+		if strings.Contains(cookie, "SameSite=None") != item.none {
+			t.Errorf("%q: got %q, want SameSite=None %v", item.ua, cookie, item.none)
+		}
+	}
+}
+
+// TestDuplicateCookies tests that every cookie with the CSRF cookie name is
+// tried in order and that stale duplicates can be expired.
+func TestDuplicateCookies(t *testing.T) {
+	var token string
+	s := http.NewServeMux()
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	})
+	p := Protect(testKey, Domain("example.com"), ExpireDuplicateCookies(""))(s)
+
+	r, err := http.NewRequest("GET", "http://www.example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+	cookie := rr.Result().Cookies()[0]
+
+	r, err = http.NewRequest("POST", "http://www.example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: "stale"})
+	r.AddCookie(&http.Cookie{Name: cookieName, Value: cookie.Value})
+	r.Header.Set("X-CSRF-Token", token)
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("middleware rejected a valid duplicate cookie: got %v want %v",
+			rr.Code, http.StatusOK)
+	}
+
+	var expired, reissued bool
+	for _, c := range rr.Result().Cookies() {
+		switch {
+		case c.MaxAge < 0 && c.Domain == "":
+			expired = true
+		case c.Value == cookie.Value && c.Domain == "example.com":
+			reissued = true
+		}
+	}
+
+	if !expired || !reissued {
+		t.Fatalf("duplicate cookies not cleaned up: got %v", rr.Header().Values("Set-Cookie"))
+	}
+}
+
+// TestPathFunc tests that cookies are scoped to the path returned for the
+// request, and that the static Path is used without a request.
+func TestPathFunc(t *testing.T) {
+	app := func(r *http.Request) string {
+		return "/" + strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	}
+	p := Protect(testKey, Path("/"), PathFunc(app))(testHandler)
+
+	for path, want := range map[string]string{
+		"/app1/form":  "Path=/app1",
+		"/app2/a/b/c": "Path=/app2",
+	} {
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+		if cookie := rr.Header().Get("Set-Cookie"); !strings.Contains(cookie, "; "+want+";") {
+			t.Errorf("%s: cookie not scoped to the application: got %q want %q", path, cookie, want)
+		}
+	}
+
+	m, err := New(testKey, Path("/"), PathFunc(app))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cookie, err := m.Mint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie.Path != "/" {
+		t.Fatalf("minted cookie path: got %q want %q", cookie.Path, "/")
+	}
+}
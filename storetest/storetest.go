@@ -0,0 +1,285 @@
+// Package storetest provides conformance tests for implementations of
+// csrf.Store and csrf.TokenBackend, e.g. stores backed by Redis or SQL:
+//
+//	func TestRedisStore(t *testing.T) {
+//		storetest.TestStore(t, newRedisStore(t))
+//	}
+//
+// The tests check the contract of the interface, concurrent use, and that the
+// middleware accepts the tokens kept in the store.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/meplato/csrf"
+)
+
+// tokenLength is the length of the real CSRF tokens kept by the middleware.
+const tokenLength = 32
+
+// concurrency is the number of goroutines using the store at once.
+const concurrency = 32
+
+// TestStore tests that s implements csrf.Store: a token saved with Save is
+// returned by Get for a request carrying the cookies written by Save, and no
+// other request gets it.
+func TestStore(t *testing.T, s csrf.Store) {
+	t.Helper()
+
+	t.Run("SaveGet", func(t *testing.T) {
+		token := randomBytes(t, tokenLength)
+		r := saveRequest(t, s, token)
+
+		got, err := s.Get(r)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !bytes.Equal(got, token) {
+			t.Fatalf("Get: got %x want %x", got, token)
+		}
+	})
+
+	t.Run("NoCookie", func(t *testing.T) {
+		got, err := s.Get(httptest.NewRequest("GET", "/", nil))
+		if err == nil && len(got) > 0 {
+			t.Fatalf("Get without cookies: got %x want an error or no token", got)
+		}
+	})
+
+	t.Run("TamperedCookie", func(t *testing.T) {
+		token := randomBytes(t, tokenLength)
+		r := saveRequest(t, s, token)
+
+		tampered := httptest.NewRequest("GET", "/", nil)
+		for _, c := range r.Cookies() {
+			c.Value = flipFirst(c.Value)
+			tampered.AddCookie(c)
+		}
+
+		got, err := s.Get(tampered)
+		if err == nil && bytes.Equal(got, token) {
+			t.Fatal("Get with tampered cookies: got the saved token")
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			token := randomBytes(t, tokenLength)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				rr := httptest.NewRecorder()
+				if err := s.Save(token, rr); err != nil {
+					t.Errorf("Save: %v", err)
+					return
+				}
+				got, err := s.Get(cookieRequest(rr))
+				if err != nil || !bytes.Equal(got, token) {
+					t.Errorf("Get: got %x, %v want %x", got, err, token)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("Middleware", func(t *testing.T) {
+		testMiddleware(t, csrf.TokenStore(s))
+	})
+}
+
+// TestBackend tests that b implements csrf.TokenBackend: a sealed token of
+// arbitrary bytes stored for an ID is loaded for that ID until its TTL
+// expires, and unknown IDs load nil without an error.
+//
+// The TTL test stores a token with a TTL of one second and waits for it to
+// expire; the backend may discard the token or keep it, but must not fail.
+func TestBackend(t *testing.T, b csrf.TokenBackend) {
+	t.Helper()
+	ctx := context.Background()
+
+	t.Run("StoreLoad", func(t *testing.T) {
+		id, sealed := randomID(t), randomBytes(t, 60)
+		if err := b.Store(ctx, id, sealed, time.Hour); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+
+		got, err := b.Load(ctx, id)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if !bytes.Equal(got, sealed) {
+			t.Fatalf("Load: got %x want %x", got, sealed)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		got, err := b.Load(ctx, randomID(t))
+		if err != nil || got != nil {
+			t.Fatalf("Load of an unknown ID: got %x, %v want nil, nil", got, err)
+		}
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		id := randomID(t)
+		for _, sealed := range [][]byte{randomBytes(t, 60), randomBytes(t, 60)} {
+			if err := b.Store(ctx, id, sealed, time.Hour); err != nil {
+				t.Fatalf("Store: %v", err)
+			}
+			got, err := b.Load(ctx, id)
+			if err != nil || !bytes.Equal(got, sealed) {
+				t.Fatalf("Load after Store: got %x, %v want %x", got, err, sealed)
+			}
+		}
+	})
+
+	t.Run("NoTTL", func(t *testing.T) {
+		id, sealed := randomID(t), randomBytes(t, 60)
+		if err := b.Store(ctx, id, sealed, 0); err != nil {
+			t.Fatalf("Store without TTL: %v", err)
+		}
+		got, err := b.Load(ctx, id)
+		if err != nil || !bytes.Equal(got, sealed) {
+			t.Fatalf("Load: got %x, %v want %x", got, err, sealed)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		id, sealed := randomID(t), randomBytes(t, 60)
+		if err := b.Store(ctx, id, sealed, time.Second); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+		got, err := b.Load(ctx, id)
+		if err != nil || !bytes.Equal(got, sealed) {
+			t.Fatalf("Load before expiry: got %x, %v want %x", got, err, sealed)
+		}
+
+		time.Sleep(1100 * time.Millisecond)
+
+		got, err = b.Load(ctx, id)
+		if err != nil || (got != nil && !bytes.Equal(got, sealed)) {
+			t.Fatalf("Load after expiry: got %x, %v want nil or %x", got, err, sealed)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			id, sealed := randomID(t), randomBytes(t, 60)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				if err := b.Store(ctx, id, sealed, time.Hour); err != nil {
+					t.Errorf("Store: %v", err)
+					return
+				}
+				got, err := b.Load(ctx, id)
+				if err != nil || !bytes.Equal(got, sealed) {
+					t.Errorf("Load: got %x, %v want %x", got, err, sealed)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("Middleware", func(t *testing.T) {
+		testMiddleware(t, csrf.OpaqueTokens(b))
+	})
+}
+
+// testMiddleware tests that a token issued by the middleware configured with
+// opt is accepted on the next request.
+func testMiddleware(t *testing.T, opt csrf.Option) {
+	t.Helper()
+
+	key := randomBytes(t, 32)
+	m, err := csrf.New(key, opt)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	var token string
+	h := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = csrf.Token(r)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK || token == "" {
+		t.Fatalf("GET: got %v, token %q", rr.Code, token)
+	}
+
+	r := cookieRequest(rr)
+	r.Method = "POST"
+	r.Header.Set("X-CSRF-Token", token)
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, r)
+	if res.Code != http.StatusOK {
+		t.Fatalf("POST with the issued token: got %v %q", res.Code, res.Body)
+	}
+}
+
+// saveRequest saves token to s and returns a request with the cookies
+// written.
+func saveRequest(t *testing.T, s csrf.Store, token []byte) *http.Request {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	if err := s.Save(token, rr); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if len(rr.Result().Cookies()) == 0 {
+		t.Fatal("Save: no cookie written")
+	}
+
+	return cookieRequest(rr)
+}
+
+// cookieRequest returns a GET request with the cookies set in rr.
+func cookieRequest(rr *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range rr.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	return r
+}
+
+// flipFirst returns v with its first character changed.
+func flipFirst(v string) string {
+	if v == "" {
+		return "A"
+	}
+	if v[0] == 'A' {
+		return "B" + v[1:]
+	}
+
+	return "A" + v[1:]
+}
+
+// randomID returns a random session ID as used by the middleware.
+func randomID(t *testing.T) string {
+	return base64.RawURLEncoding.EncodeToString(randomBytes(t, 32))
+}
+
+// randomBytes returns n random bytes.
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("storetest: %v", err)
+	}
+
+	return b
+}
@@ -0,0 +1,88 @@
+package storetest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is a server-side csrf.Store keeping tokens in memory, keyed by
+// a random ID in the cookie.
+type memoryStore struct {
+	mu     sync.Mutex
+	tokens map[string][]byte
+}
+
+func (ms *memoryStore) Get(r *http.Request) ([]byte, error) {
+	c, err := r.Cookie("_csrf_id")
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	token, ok := ms.tokens[c.Value]
+	if !ok {
+		return nil, errors.New("unknown ID")
+	}
+	return token, nil
+}
+
+func (ms *memoryStore) Save(token []byte, w http.ResponseWriter) error {
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	v := base64.RawURLEncoding.EncodeToString(id)
+
+	ms.mu.Lock()
+	ms.tokens[v] = token
+	ms.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: "_csrf_id", Value: v, Path: "/"})
+	return nil
+}
+
+// memoryBackend is a csrf.TokenBackend keeping tokens in memory until their
+// TTL expires.
+type memoryBackend struct {
+	mu      sync.Mutex
+	tokens  map[string][]byte
+	expires map[string]time.Time
+}
+
+func (mb *memoryBackend) Load(ctx context.Context, id string) ([]byte, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if exp, ok := mb.expires[id]; ok && time.Now().After(exp) {
+		delete(mb.tokens, id)
+		delete(mb.expires, id)
+	}
+	return mb.tokens[id], nil
+}
+
+func (mb *memoryBackend) Store(ctx context.Context, id string, sealed []byte, ttl time.Duration) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.tokens[id] = sealed
+	delete(mb.expires, id)
+	if ttl > 0 {
+		mb.expires[id] = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+// TestMemoryStore runs the conformance tests for a correct Store.
+func TestMemoryStore(t *testing.T) {
+	TestStore(t, &memoryStore{tokens: map[string][]byte{}})
+}
+
+// TestMemoryBackend runs the conformance tests for a correct TokenBackend.
+func TestMemoryBackend(t *testing.T) {
+	TestBackend(t, &memoryBackend{tokens: map[string][]byte{}, expires: map[string]time.Time{}})
+}
@@ -0,0 +1,97 @@
+package csrf
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenEvent is the name of the server-sent events carrying a fresh token,
+// see StreamTokenRefresh.
+const TokenEvent = "csrf-token"
+
+// StreamTokenRefresh delivers the current masked token to clients of
+// streaming responses, e.g. pages holding a server-sent event stream open for
+// hours:
+//
+//   - Event streams (Content-Type text/event-stream) get a TokenEvent event
+//     with the token as data, at most once per interval, when the handler
+//     flushes.
+//   - Other responses the handler flushes get the token in the trailer named
+//     after the request header, see RequestHeader.
+//
+// The delivered token is the token of the CSRF cookie, masked anew, or the
+// token set by Regenerate during the stream. It does not extend the cookie:
+// once the response headers are sent, no new cookie can be delivered, so the
+// cookie and its IdleTimeout and AbsoluteTimeout timestamps do not change
+// during the stream, and the events do not count as uses of the token.
+// Clients holding a stream open longer than the timeouts must load a page or
+// make a safe request to obtain a new cookie and token before they submit.
+//
+// In the browser, listen for the event on the EventSource:
+//
+//	source.addEventListener("csrf-token", e => token = e.data)
+//
+// The event is only written on Flush, between the events of the handler, so
+// handlers must flush after each complete event. Defaults to 0 (disabled).
+func StreamTokenRefresh(interval time.Duration) Option {
+	return func(cs *csrf) {
+		cs.opts.StreamRefresh = interval
+	}
+}
+
+// streamToken returns the token of the response masked anew, or the
+// regenerated token if any.
+func (rw *responseWriter) streamToken() string {
+	realToken := rw.realToken
+	if rw.pending != nil {
+		realToken = rw.pending
+	}
+
+	return rw.cs.mask(realToken, rw.r)
+}
+
+// isEventStream reports whether the response is a server-sent event stream.
+func (rw *responseWriter) isEventStream() bool {
+	return strings.HasPrefix(rw.ResponseWriter.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// refreshStream writes a TokenEvent to an event stream if one is due. It is
+// called on Flush.
+func (rw *responseWriter) refreshStream() {
+	if rw.cs.opts.StreamRefresh <= 0 {
+		return
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.flushed = true
+	if !rw.isEventStream() || timeNow().Before(rw.refreshAt) {
+		return
+	}
+	rw.refreshAt = timeNow().Add(rw.cs.opts.StreamRefresh)
+
+	if token := rw.streamToken(); token != "" {
+		fmt.Fprintf(rw.ResponseWriter, "event: %s\ndata: %s\n\n", TokenEvent, token)
+	}
+}
+
+// trailer sets the token trailer of a flushed response that is not an event
+// stream. It is called after the handler returns.
+func (rw *responseWriter) trailer() {
+	if rw.cs.opts.StreamRefresh <= 0 {
+		return
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if !rw.flushed || rw.isEventStream() {
+		return
+	}
+	if token := rw.streamToken(); token != "" {
+		rw.ResponseWriter.Header().Set(http.TrailerPrefix+rw.cs.opts.RequestHeader, token)
+	}
+}
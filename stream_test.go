@@ -0,0 +1,66 @@
+package csrf
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStreamTokenRefreshEvents tests that event streams get a token event on
+// the first flush after each interval.
+func TestStreamTokenRefreshEvents(t *testing.T) {
+	start := time.Now()
+	timeNow = func() time.Time { return start }
+	defer func() { timeNow = time.Now }()
+
+	p := Protect(testKey, StreamTokenRefresh(time.Hour))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i, elapsed := range []time.Duration{0, 30 * time.Minute, 61 * time.Minute, 62 * time.Minute} {
+			timeNow = func() time.Time { return start.Add(elapsed) }
+			io.WriteString(w, "data: "+string(rune('a'+i))+"\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	events := strings.Split(strings.TrimSpace(rr.Body.String()), "\n\n")
+	if len(events) != 5 || !strings.HasPrefix(events[3], "event: "+TokenEvent+"\ndata: ") {
+		t.Fatalf("got events %q", events)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set(headerName, strings.TrimPrefix(events[3], "event: "+TokenEvent+"\ndata: "))
+	setCookie(rr, r)
+
+	res := httptest.NewRecorder()
+	Protect(testKey)(testHandler).ServeHTTP(res, r)
+	if res.Code != http.StatusOK {
+		t.Fatalf("POST with the streamed token: got %v", res.Code)
+	}
+}
+
+// TestStreamTokenRefreshTrailer tests that flushed responses get the token in
+// a trailer, and others do not.
+func TestStreamTokenRefreshTrailer(t *testing.T) {
+	for _, flush := range []bool{true, false} {
+		p := Protect(testKey, StreamTokenRefresh(time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "chunk")
+			if flush {
+				w.(http.Flusher).Flush()
+			}
+		}))
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		token := rr.Result().Trailer.Get(headerName)
+		if (token != "") != flush {
+			t.Fatalf("flush %v: got trailer %q", flush, token)
+		}
+	}
+}
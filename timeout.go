@@ -0,0 +1,111 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrTokenExpired is returned by a store when the token in the session has
+// exceeded its idle or absolute timeout.
+var ErrTokenExpired = errors.New("CSRF token expired")
+
+// timeNow returns the current time. It is a variable to allow tests to
+// control the clock.
+var timeNow = time.Now
+
+// toucher is implemented by stores that can record a verified use of the
+// token, e.g. to extend an idle timeout.
+type toucher interface {
+	// Touch marks the token in the session as used and writes the updated
+	// session to the http.ResponseWriter.
+	Touch(r *http.Request, w http.ResponseWriter) error
+}
+
+// timedToken is the cookie value used when timeouts are configured. It
+// embeds the time the token was issued and last used (as Unix seconds).
+type timedToken struct {
+	Token  []byte `json:"t"`
+	Issued int64  `json:"i"`
+	Seen   int64  `json:"s"`
+}
+
+// expired reports whether tt has exceeded the given idle or absolute timeout.
+// A zero timeout is not enforced.
+func (tt *timedToken) expired(idle, abs time.Duration) bool {
+	now := timeNow()
+	if abs > 0 && now.Sub(time.Unix(tt.Issued, 0)) > abs {
+		return true
+	}
+	if idle > 0 && now.Sub(time.Unix(tt.Seen, 0)) > idle {
+		return true
+	}
+	return false
+}
+
+// timed reports whether the cookie store embeds timestamps in its cookies.
+func (cs *cookieStore) timed() bool {
+	return cs.idleTimeout > 0 || cs.absTimeout > 0
+}
+
+// decodeTimed decodes and validates a cookie value holding a timedToken.
+func (cs *cookieStore) decodeTimed(value string) (*timedToken, error) {
+	tt := &timedToken{}
+	if err := cs.sc.Decode(cs.name, value, tt); err != nil {
+		return nil, err
+	}
+
+	if tt.expired(cs.idleTimeout, cs.absTimeout) {
+		return nil, ErrTokenExpired
+	}
+
+	return tt, nil
+}
+
+// Touch refreshes the last-used timestamp embedded in the session cookie,
+// keeping the original issue time. It is a no-op if no timeouts are set.
+func (cs *cookieStore) Touch(r *http.Request, w http.ResponseWriter) error {
+	if !cs.timed() {
+		return nil
+	}
+
+	value, _, err := cs.find(r)
+	if err != nil {
+		return err
+	}
+
+	tt, err := cs.decodeTimed(value)
+	if err != nil {
+		return err
+	}
+
+	tt.Seen = timeNow().Unix()
+	encoded, err := cs.sc.Encode(cs.name, tt)
+	if err != nil {
+		return err
+	}
+
+	cs.setCookie(r, encoded, w)
+
+	return nil
+}
+
+// Issued returns the time the token in the session cookie was issued. It
+// returns an error if no timeouts are set, as the time is not recorded then.
+func (cs *cookieStore) Issued(r *http.Request) (time.Time, error) {
+	if !cs.timed() {
+		return time.Time{}, errNotTimed
+	}
+
+	value, _, err := cs.find(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	tt, err := cs.decodeTimed(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(tt.Issued, 0), nil
+}
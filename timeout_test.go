@@ -0,0 +1,83 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestTimeouts checks that verified requests extend the idle timeout while the
+// absolute timeout still applies from the time the token was issued.
+func TestTimeouts(t *testing.T) {
+	start := time.Now()
+	clock := start
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	var token string
+	s := http.NewServeMux()
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+	})
+	p := Protect(testKey, IdleTimeout(30*time.Minute), AbsoluteTimeout(time.Hour))(s)
+
+	r, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+	cookie := rr.Header().Get("Set-Cookie")
+
+	testTable := []struct {
+		elapsed time.Duration
+		code    int
+	}{
+		{20 * time.Minute, http.StatusOK},
+		{45 * time.Minute, http.StatusOK},
+		{70 * time.Minute, http.StatusForbidden},
+	}
+
+	for _, item := range testTable {
+		clock = start.Add(item.elapsed)
+
+		r, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Cookie", cookie)
+		r.Header.Set("X-CSRF-Token", token)
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, r)
+
+		if rr.Code != item.code {
+			t.Fatalf("after %v: got %v want %v", item.elapsed, rr.Code, item.code)
+		}
+
+		if c := rr.Header().Get("Set-Cookie"); c != "" {
+			cookie = c
+		}
+	}
+
+	// Without a verified use in between, the idle timeout expires the token.
+	clock = start.Add(31 * time.Minute)
+	st := p.(*csrf).st.(*cookieStore)
+	rr = httptest.NewRecorder()
+	if err := st.Save(make([]byte, tokenLength), rr); err != nil {
+		t.Fatal(err)
+	}
+
+	clock = clock.Add(31 * time.Minute)
+	r, err = http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Cookie", rr.Header().Get("Set-Cookie"))
+
+	if _, err := st.Get(r); err != ErrTokenExpired {
+		t.Fatalf("idle token not expired: got %v want %v", err, ErrTokenExpired)
+	}
+}
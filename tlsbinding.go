@@ -0,0 +1,41 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrTLSBinding is returned if BindTLS is set and the keying material of the
+// TLS connection of a request cannot be exported, e.g. because the request
+// was not made over TLS.
+var ErrTLSBinding = errors.New("TLS keying material unavailable")
+
+// tlsExporterLabel is the label for the keying material exported from TLS
+// connections, as per RFC 5705.
+const tlsExporterLabel = "EXPORTER-gorilla-csrf-token-binding"
+
+// bindToken returns realToken bound to the TLS connection of r if BindTLS is
+// set, or realToken itself otherwise. Tokens minted without a request are not
+// bound.
+func (cs *csrf) bindToken(r *http.Request, realToken []byte) ([]byte, error) {
+	if !cs.opts.BindTLS || r == nil {
+		return realToken, nil
+	}
+
+	if r.TLS == nil {
+		return nil, ErrTLSBinding
+	}
+
+	ekm, err := r.TLS.ExportKeyingMaterial(tlsExporterLabel, nil, sha256.Size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTLSBinding, err)
+	}
+
+	mac := hmac.New(sha256.New, ekm)
+	mac.Write(realToken)
+
+	return mac.Sum(nil)[:tokenLength], nil
+}
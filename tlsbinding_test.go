@@ -0,0 +1,92 @@
+package csrf
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestBindTLS tests that tokens bound to a TLS connection are accepted over
+// that connection only, and that requests without TLS are rejected.
+func TestBindTLS(t *testing.T) {
+	var reason error
+	p := Protect(testKey,
+		BindTLS(),
+		ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reason = FailureReason(r)
+			w.WriteHeader(http.StatusForbidden)
+		})),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, Token(r))
+	}))
+
+	srv := httptest.NewTLSServer(p)
+	defer srv.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := srv.Client()
+	client.Jar = jar
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(token) == 0 {
+		t.Fatal("no token issued")
+	}
+
+	post := func(c *http.Client) int {
+		r, err := http.NewRequest("POST", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-CSRF-Token", string(token))
+		r.Header.Set("Referer", srv.URL+"/")
+
+		resp, err := c.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post(client); code != http.StatusOK {
+		t.Fatalf("token rejected on its connection: got %v, %v", code, reason)
+	}
+
+	// A new connection with the same cookies must not accept the token.
+	other := &http.Client{
+		Transport: srv.Client().Transport.(*http.Transport).Clone(),
+		Jar:       jar,
+	}
+	if code := post(other); code != http.StatusForbidden || !errors.Is(reason, ErrBadToken) {
+		t.Fatalf("token accepted on another connection: got %v, %v", code, reason)
+	}
+
+	// Requests without TLS cannot be bound.
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/", nil))
+	if rr.Body.Len() != 0 {
+		t.Fatalf("token issued without TLS: got %q", rr.Body.String())
+	}
+
+	r := httptest.NewRequest("POST", "http://example.com/", nil)
+	setCookie(rr, r)
+	r.Header.Set("X-CSRF-Token", string(token))
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, r)
+	if rr.Code != http.StatusForbidden || !errors.Is(reason, ErrTLSBinding) {
+		t.Fatalf("request without TLS accepted: got %v, %v", rr.Code, reason)
+	}
+}
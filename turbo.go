@@ -0,0 +1,48 @@
+package csrf
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+)
+
+const (
+	// turboRequestHeader is set by Turbo (Hotwire) on every fetch request it
+	// makes, e.g. for form submissions and frame navigations.
+	turboRequestHeader = "X-Turbo-Request-Id"
+	// turboTokenHeader is the request header Turbo and Rails UJS send the
+	// token of the csrf-token meta tag in. It cannot be configured.
+	turboTokenHeader = "X-CSRF-Token"
+)
+
+// MetaTags returns the csrf-param and csrf-token <meta> tags that Turbo
+// (Hotwire) and Rails UJS read the CSRF token from, for the <head> of a page:
+//
+//	<meta name="csrf-param" content="gorilla.csrf.Token">
+//	<meta name="csrf-token" content="<token>">
+//
+// Turbo sends the token in the X-CSRF-Token header of its fetch requests,
+// which the middleware accepts regardless of RequestHeader, so no JavaScript
+// shim is needed. An empty string is returned if the middleware has not been
+// applied.
+func MetaTags(r *http.Request) template.HTML {
+	name := FieldNameOf(r)
+	if name == "" {
+		return ""
+	}
+
+	return template.HTML(fmt.Sprintf("<meta name=\"csrf-param\" content=\"%s\">\n<meta name=\"csrf-token\" content=\"%s\">",
+		html.EscapeString(name), html.EscapeString(Token(r))))
+}
+
+// turboToken returns the token of a Turbo fetch request sent in the
+// X-CSRF-Token header, if r is one. It is only consulted if RequestHeader
+// names a different header.
+func (cs *csrf) turboToken(r *http.Request) string {
+	if r.Header.Get(turboRequestHeader) == "" || http.CanonicalHeaderKey(cs.opts.RequestHeader) == turboTokenHeader {
+		return ""
+	}
+
+	return r.Header.Get(turboTokenHeader)
+}
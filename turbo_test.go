@@ -0,0 +1,71 @@
+package csrf
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestMetaTags tests that the meta tags carry the field name and the token.
+func TestMetaTags(t *testing.T) {
+	var token, tags string
+	s := http.NewServeMux()
+	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		token = Token(r)
+		tags = string(MetaTags(r))
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	Protect(testKey, FieldName("authenticity_token"))(s).ServeHTTP(httptest.NewRecorder(), r)
+
+	want := fmt.Sprintf("<meta name=\"csrf-param\" content=\"authenticity_token\">\n<meta name=\"csrf-token\" content=\"%s\">", token)
+	if tags != want {
+		t.Fatalf("unexpected meta tags: got %q want %q", tags, want)
+	}
+
+	if MetaTags(r) != "" {
+		t.Fatal("meta tags rendered without the middleware")
+	}
+}
+
+// TestTurboRequest tests that Turbo fetch requests are accepted with the token
+// in X-CSRF-Token when a different request header is configured.
+func TestTurboRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		turbo bool
+		code  int
+	}{
+		{"turbo", true, http.StatusOK},
+		{"not turbo", false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var token string
+			s := http.NewServeMux()
+			s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				token = Token(r)
+			})
+			p := Protect(testKey, RequestHeader("X-Token"))(s)
+
+			rr := httptest.NewRecorder()
+			p.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+			r := httptest.NewRequest("POST", "/", nil)
+			setCookie(rr, r)
+			r.Header.Set("X-CSRF-Token", token)
+			if tt.turbo {
+				r.Header.Set("X-Turbo-Request-Id", "5a0f8b5e-8d2c-4d0e-9b7a-3f3c2f1e6d4a")
+			}
+
+			rr = httptest.NewRecorder()
+			p.ServeHTTP(rr, r)
+
+			if rr.Code != tt.code {
+				t.Fatalf("got %v want %v", rr.Code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package csrf
+
+import (
+	"net/http"
+	"sync"
+)
+
+// lazyVary adds "Vary: Cookie" to a response once the token of its request
+// is read, see Token and LazyVaryCookie.
+type lazyVary struct {
+	header http.Header
+	once   sync.Once
+}
+
+// add adds "Vary: Cookie" to the response, once.
+func (lv *lazyVary) add() {
+	lv.once.Do(func() {
+		lv.header.Add("Vary", "Cookie")
+	})
+}
+
+// prepareVary prepares r to add "Vary: Cookie" to the response once its token
+// is read, if LazyVaryCookie is set.
+func (cs *csrf) prepareVary(w http.ResponseWriter, r *http.Request) *http.Request {
+	if !cs.opts.LazyVaryCookie {
+		return r
+	}
+
+	return contextSave(r, varyKey, &lazyVary{header: w.Header()})
+}
+
+// vary adds "Vary: Cookie" to the response to protect clients from caching
+// it. It must be called before the handler writes the header. With
+// LazyVaryCookie, it is only added if a cookie is set, or once the token is
+// read.
+func (cs *csrf) vary(w http.ResponseWriter, r *http.Request) {
+	if !cs.opts.LazyVaryCookie {
+		w.Header().Add("Vary", "Cookie")
+		return
+	}
+
+	if len(w.Header().Values("Set-Cookie")) > 0 {
+		markVary(r)
+	}
+}
+
+// markVary adds "Vary: Cookie" to the response for r, if it is not yet set.
+func markVary(r *http.Request) {
+	if lv, ok := r.Context().Value(varyKey).(*lazyVary); ok {
+		lv.add()
+	}
+}
@@ -0,0 +1,56 @@
+package csrf
+
+import "net/http"
+
+// Verification annotations of requests served without a check, besides the
+// Skipped reasons, see VerifiedHeader.
+const (
+	skippedStoreUnavailable = "store-unavailable" // FailOpen on store errors
+	skippedVerifyTimeout    = "verify-timeout"    // FailOpen on VerifyDeadline
+)
+
+// VerifiedHeader annotates each request passed to the handler with the
+// request header name, e.g. "X-CSRF-Verified", so that services behind a
+// reverse proxy running the middleware can assert that CSRF was checked at
+// the edge. The value is one of
+//
+//   - "pass" if the request passed the check,
+//   - "skip:<reason>" if it was served without a check, with one of the
+//     Skipped reasons of Outcome, e.g. "skip:safe-method", or with
+//     "store-unavailable" or "verify-timeout" if it was served despite a
+//     store failure or verification timeout, or
+//   - "report:<code>" if it failed the check in report-only mode, with the
+//     FailureCode of the error, e.g. "report:CSRF003_BAD_TOKEN".
+//
+// The header is removed from all incoming requests, so clients cannot set it.
+// Defaults to "" (disabled).
+func VerifiedHeader(name string) Option {
+	return func(cs *csrf) {
+		cs.opts.VerifiedHeader = name
+	}
+}
+
+// stripVerified removes the verification header set by clients from r.
+func (cs *csrf) stripVerified(r *http.Request) {
+	if cs.opts.VerifiedHeader != "" {
+		r.Header.Del(cs.opts.VerifiedHeader)
+	}
+}
+
+// annotate sets the verification header of r to value.
+func (cs *csrf) annotate(r *http.Request, value string) {
+	if cs.opts.VerifiedHeader != "" {
+		r.Header.Set(cs.opts.VerifiedHeader, value)
+	}
+}
+
+// annotateSkip sets the verification header of r to the skip reason, or to
+// "pass" if reason is empty.
+func (cs *csrf) annotateSkip(r *http.Request, reason string) {
+	if reason == "" {
+		cs.annotate(r, "pass")
+		return
+	}
+
+	cs.annotate(r, "skip:"+reason)
+}
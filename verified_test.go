@@ -0,0 +1,56 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestVerifiedHeader tests that requests passed to the handler are annotated
+// with the result of the check, and that annotations set by clients are
+// removed.
+func TestVerifiedHeader(t *testing.T) {
+	var got, token string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-CSRF-Verified")
+		token = Token(r)
+	})
+
+	p := Protect(testKey, VerifiedHeader("X-CSRF-Verified"), ExcludePaths("/hooks"))(h)
+
+	rr := httptest.NewRecorder()
+	get := httptest.NewRequest("GET", "/", nil)
+	get.Header.Set("X-CSRF-Verified", "pass")
+	p.ServeHTTP(rr, get)
+	if got != "skip:"+SkippedSafeMethod {
+		t.Fatalf("GET: got %q", got)
+	}
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set(headerName, token)
+	setCookie(rr, r)
+	p.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "pass" {
+		t.Fatalf("POST with token: got %q", got)
+	}
+
+	r = httptest.NewRequest("POST", "/hooks", nil)
+	r.Header.Set("X-CSRF-Verified", "pass")
+	p.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "skip:"+SkippedPath {
+		t.Fatalf("excluded POST: got %q", got)
+	}
+
+	got = ""
+	r = httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("X-CSRF-Verified", "pass")
+	p.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "" {
+		t.Fatalf("rejected POST reached the handler with %q", got)
+	}
+
+	Protect(testKey, VerifiedHeader("X-CSRF-Verified"), ReportOnly())(h).ServeHTTP(httptest.NewRecorder(), r)
+	if got != "report:"+CodeNoToken {
+		t.Fatalf("report-only POST: got %q", got)
+	}
+}
@@ -0,0 +1,127 @@
+package csrf
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// maxReasonableAge is the MaxAge in seconds above which a warning is issued.
+const maxReasonableAge = 365 * 24 * 60 * 60
+
+// environmentVars are the environment variables inspected to tell whether the
+// middleware runs in production.
+var environmentVars = []string{"APP_ENV", "GO_ENV", "ENV", "ENVIRONMENT"}
+
+// Warning is a non-fatal configuration finding, e.g. an option that is likely
+// a mistake in production. See Middleware.Warnings.
+type Warning struct {
+	// Option is the name of the option the finding is about.
+	Option string
+	// Message describes the finding.
+	Message string
+}
+
+// String returns the warning as "Option: Message".
+func (w Warning) String() string {
+	return w.Option + ": " + w.Message
+}
+
+// Warnings returns the non-fatal configuration findings of the middleware,
+// e.g. Secure(false) in production, a MaxAge over a year, exclusions covered
+// by other exclusions or a cookie Domain that is a public suffix or does not
+// cover the OnlyHosts. Protect and NewServeMux log them to the ErrorLog
+// instead.
+func (m *Middleware) Warnings() []Warning {
+	return m.cs.warnings()
+}
+
+// logWarnings logs the configuration warnings of cs.
+func (cs *csrf) logWarnings() {
+	for _, w := range cs.warnings() {
+		cs.warnf("%v", w)
+	}
+}
+
+// warnings returns the configuration warnings of cs.
+func (cs *csrf) warnings() []Warning {
+	var ws []Warning
+	warn := func(option, format string, args ...interface{}) {
+		ws = append(ws, Warning{Option: option, Message: fmt.Sprintf(format, args...)})
+	}
+
+	o := cs.opts
+	if !o.Secure {
+		if env, ok := productionEnv(); ok {
+			warn("Secure", "cookies are sent over plain HTTP, but %s indicates production", env)
+		}
+	}
+
+	if o.MaxAge > maxReasonableAge {
+		warn("MaxAge", "cookies live for %d days, more than a year", o.MaxAge/(24*60*60))
+	}
+
+	for i, path := range o.ExcludePaths {
+		for j, other := range o.ExcludePaths {
+			if i == j || (path == other && i < j) {
+				continue
+			}
+			if matchPath(o.ExcludePathsMode, path, other) {
+				warn("ExcludePaths", "%q is already covered by %q", path, other)
+				break
+			}
+		}
+	}
+
+	for _, rule := range o.RefererPaths {
+		for _, path := range o.ExcludePaths {
+			if matchPath(o.ExcludePathsMode, rule.path, path) {
+				warn("RefererPaths", "%q is never checked, it is excluded by %q", rule.path, path)
+				break
+			}
+		}
+	}
+
+	if publicSuffixDomain(o.Domain) {
+		warn("Domain", "%q is a public suffix, browsers ignore cookies for it", o.Domain)
+	}
+
+	if o.Domain != "" {
+		for _, host := range o.OnlyHosts {
+			if !domainCovers(o.Domain, host) {
+				warn("Domain", "%q does not cover %q, browsers reject cookies for it", o.Domain, host)
+			}
+		}
+	}
+
+	return ws
+}
+
+// productionEnv returns the first environment variable that names a
+// production environment, e.g. APP_ENV=production.
+func productionEnv() (string, bool) {
+	for _, name := range environmentVars {
+		switch strings.ToLower(os.Getenv(name)) {
+		case "prod", "production":
+			return name + "=" + os.Getenv(name), true
+		}
+	}
+
+	return "", false
+}
+
+// publicSuffixDomain reports whether domain is a public suffix, e.g. "co.uk"
+// or "appspot.com", for which browsers refuse to set cookies. Single labels
+// not on the list, e.g. "localhost", are not reported.
+func publicSuffixDomain(domain string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" {
+		return false
+	}
+
+	suffix, icann := publicsuffix.PublicSuffix(domain)
+
+	return suffix == domain && (icann || strings.Contains(suffix, "."))
+}
@@ -0,0 +1,59 @@
+package csrf
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+// TestWarnings tests that likely misconfigurations are reported as warnings
+// by New and logged by Protect, including those New rejects.
+func TestWarnings(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		option string
+	}{
+		{"insecure in production", []Option{Secure(false)}, "Secure"},
+		{"long max age", []Option{MaxAge(2 * maxReasonableAge)}, "MaxAge"},
+		{"covered exclusion", []Option{ExcludePaths("/api/", "/api/hooks")}, "ExcludePaths"},
+		{"duplicate exclusion", []Option{ExcludePaths("/hooks", "/hooks")}, "ExcludePaths"},
+		{"excluded referer path", []Option{ExcludePaths("/pay"), RefererPaths("/payments", "/checkout")}, "RefererPaths"},
+		{"uncovered host", []Option{Domain("example.com"), OnlyHosts("www.example.com", "staging.example.net")}, "Domain"},
+		{"clean", []Option{Domain("example.co.uk"), OnlyHosts("www.example.co.uk"), ExcludePaths("/a", "/b"), MaxAge(3600)}, ""},
+		{"exact exclusions", []Option{ExcludePaths("/api", "/api/hooks"), ExcludePathsMode(PathMatchExact)}, ""},
+		{"localhost", []Option{Domain("localhost")}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(testKey, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ws := m.Warnings()
+			if tt.option == "" {
+				if len(ws) != 0 {
+					t.Fatalf("unexpected warnings: %v", ws)
+				}
+				return
+			}
+			if len(ws) != 1 || ws[0].Option != tt.option {
+				t.Fatalf("got %v want one warning for %s", ws, tt.option)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	Protect(testKey, Secure(false), Domain("co.uk"), ErrorLog(log.New(&buf, "", 0)))(testHandler)
+
+	for _, want := range []string{"Secure: cookies are sent over plain HTTP", `Domain: "co.uk" is a public suffix`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("warning not logged: got %q want %q", buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package csrf
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadWebhookSignature is returned if a request to a webhook path does not
+// carry a valid provider signature, see Webhook.
+var ErrBadWebhookSignature = errors.New("webhook signature invalid")
+
+// defaultWebhookBodySize is the maximum size of a webhook body read for
+// verification if no MaxBodySize is set.
+const defaultWebhookBodySize = 1 << 20
+
+// WebhookVerifier verifies the provider signature of a webhook request, as a
+// replacement for the CSRF check, see Webhook.
+type WebhookVerifier interface {
+	// VerifyWebhook returns an error if body is not signed by the provider.
+	VerifyWebhook(r *http.Request, body []byte) error
+}
+
+// webhook is a WebhookVerifier registered for a path.
+type webhook struct {
+	path     string
+	verifier WebhookVerifier
+}
+
+// HMACSignature returns a WebhookVerifier for signatures in the style of
+// GitHub: the header holds prefix followed by the hex-encoded HMAC-SHA256 of
+// the body under secret, e.g. HMACSignature("X-Hub-Signature-256", "sha256=",
+// secret).
+func HMACSignature(header, prefix string, secret []byte) WebhookVerifier {
+	return &hmacVerifier{header: header, prefix: prefix, secret: secret}
+}
+
+type hmacVerifier struct {
+	header string
+	prefix string
+	secret []byte
+}
+
+func (hv *hmacVerifier) VerifyWebhook(r *http.Request, body []byte) error {
+	sig, ok := strings.CutPrefix(r.Header.Get(hv.header), hv.prefix)
+	if !ok || !validHMAC(hv.secret, body, sig) {
+		return ErrBadWebhookSignature
+	}
+
+	return nil
+}
+
+// TimestampedSignature returns a WebhookVerifier for signatures in the style
+// of Stripe: the header holds a timestamp and one or more signatures, e.g.
+// "t=1492774577,v1=5257a869...", each the hex-encoded HMAC-SHA256 of the
+// timestamp, a dot and the body under secret. Signatures older than
+// tolerance are rejected, to limit replays.
+func TimestampedSignature(header string, secret []byte, tolerance time.Duration) WebhookVerifier {
+	return &timestampedVerifier{header: header, secret: secret, tolerance: tolerance}
+}
+
+type timestampedVerifier struct {
+	header    string
+	secret    []byte
+	tolerance time.Duration
+}
+
+func (tv *timestampedVerifier) VerifyWebhook(r *http.Request, body []byte) error {
+	var timestamp string
+	var sigs []string
+	for _, part := range strings.Split(r.Header.Get(tv.header), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadWebhookSignature
+	}
+	if age := timeNow().Sub(time.Unix(t, 0)); tv.tolerance > 0 && (age > tv.tolerance || age < -tv.tolerance) {
+		return fmt.Errorf("%w: timestamp outside of tolerance", ErrBadWebhookSignature)
+	}
+
+	payload := append([]byte(timestamp+"."), body...)
+	for _, sig := range sigs {
+		if validHMAC(tv.secret, payload, sig) {
+			return nil
+		}
+	}
+
+	return ErrBadWebhookSignature
+}
+
+// validHMAC reports whether sig is the hex-encoded HMAC-SHA256 of payload.
+func validHMAC(secret, payload []byte, sig string) bool {
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// webhookFor returns the verifier registered for the path of r, if any.
+func (cs *csrf) webhookFor(r *http.Request) WebhookVerifier {
+	for _, wh := range cs.opts.Webhooks {
+		if matchPath(cs.opts.ExcludePathsMode, r.URL.Path, wh.path) {
+			return wh.verifier
+		}
+	}
+
+	return nil
+}
+
+// verifyWebhook reads the body of r within the body size limit, restores it
+// for the handler and verifies its signature.
+func (cs *csrf) verifyWebhook(w http.ResponseWriter, r *http.Request, v WebhookVerifier) error {
+	limit := cs.opts.MaxBodySize
+	if limit <= 0 {
+		limit = defaultWebhookBodySize
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		r.Body.Close()
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return ErrBodyTooLarge
+			}
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if err := v.VerifyWebhook(r, body); err != nil {
+		if errors.Is(err, ErrBadWebhookSignature) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrBadWebhookSignature, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestWebhookHMAC tests that webhook paths accept requests with a valid
+// signature instead of a token, pass the body on and reject others.
+func TestWebhookHMAC(t *testing.T) {
+	secret := []byte("webhook-secret")
+	body := `{"action":"opened"}`
+
+	var got string
+	var reason error
+	h := Protect(testKey,
+		Webhook("/hooks/github", HMACSignature("X-Hub-Signature-256", "sha256=", secret)),
+		ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reason = FailureReason(r)
+			w.WriteHeader(http.StatusForbidden)
+		})),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+
+	tests := []struct {
+		name   string
+		path   string
+		sig    string
+		status int
+	}{
+		{"valid", "/hooks/github", "sha256=" + sign(secret, body), http.StatusOK},
+		{"wrong secret", "/hooks/github", "sha256=" + sign([]byte("other"), body), http.StatusForbidden},
+		{"missing", "/hooks/github", "", http.StatusForbidden},
+		{"other path", "/other", "sha256=" + sign(secret, body), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason = "", nil
+			r := httptest.NewRequest("POST", tt.path, strings.NewReader(body))
+			r.Header.Set("X-Hub-Signature-256", tt.sig)
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+
+			if rr.Code != tt.status {
+				t.Fatalf("got %v want %v", rr.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && got != body {
+				t.Fatalf("body not passed on: got %q", got)
+			}
+			if tt.status != http.StatusOK && tt.path == "/hooks/github" && !errors.Is(reason, ErrBadWebhookSignature) {
+				t.Fatalf("bad reason: got %v want %v", reason, ErrBadWebhookSignature)
+			}
+		})
+	}
+}
+
+// TestWebhookTimestamped tests that timestamped signatures are verified and
+// rejected outside of the tolerance.
+func TestWebhookTimestamped(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	secret := []byte("whsec_test")
+	body := `{"type":"charge.succeeded"}`
+	v := TimestampedSignature("Stripe-Signature", secret, 5*time.Minute)
+
+	header := func(ts time.Time, secret []byte) string {
+		t := strconv.FormatInt(ts.Unix(), 10)
+		return "t=" + t + ",v1=" + sign([]byte("other"), t+"."+body) + ",v1=" + sign(secret, t+"."+body)
+	}
+
+	tests := []struct {
+		name  string
+		sig   string
+		valid bool
+	}{
+		{"valid", header(now, secret), true},
+		{"within tolerance", header(now.Add(-time.Minute), secret), true},
+		{"expired", header(now.Add(-time.Hour), secret), false},
+		{"wrong secret", header(now, []byte("other")), false},
+		{"no timestamp", "v1=" + sign(secret, "."+body), false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/hooks/stripe", nil)
+		r.Header.Set("Stripe-Signature", tt.sig)
+
+		if err := v.VerifyWebhook(r, []byte(body)); (err == nil) != tt.valid {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
+
+// TestWebhookBodySize tests that oversized webhook bodies are rejected.
+func TestWebhookBodySize(t *testing.T) {
+	secret := []byte("webhook-secret")
+	body := strings.Repeat("a", 100)
+
+	var reason error
+	h := Protect(testKey,
+		MaxBodySize(10),
+		Webhook("/hooks", HMACSignature("X-Signature", "", secret)),
+		ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reason = FailureReason(r)
+			w.WriteHeader(http.StatusForbidden)
+		})),
+	)(testHandler)
+
+	r := httptest.NewRequest("POST", "/hooks", strings.NewReader(body))
+	r.Header.Set("X-Signature", sign(secret, body))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusForbidden || reason != ErrBodyTooLarge {
+		t.Fatalf("oversized body not rejected: got %v, %v", rr.Code, reason)
+	}
+}